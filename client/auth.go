@@ -0,0 +1,72 @@
+package client
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	headerKey       = "X-Polly-Key"
+	headerTimestamp = "X-Polly-Timestamp"
+	headerSignature = "X-Polly-Signature"
+)
+
+// WithToken sets the bearer token sent in the `Authorization` header.
+func WithToken(token string) Option {
+	return func(client *Client) {
+		client.token = token
+	}
+}
+
+// WithNamespace sends all requests to the namespace using the `/ns/{namespace}` URL prefix.
+func WithNamespace(namespace string) Option {
+	return func(client *Client) {
+		client.namespace = namespace
+	}
+}
+
+// WithHMAC enables signing of requests with the secret of the client named `key`.
+// It takes precedence over the bearer token.
+func WithHMAC(key, secret string) Option {
+	return func(client *Client) {
+		client.hmacKey = key
+		client.hmacSecret = secret
+	}
+}
+
+// authorize adds the credentials to the request.
+func (client *Client) authorize(req *http.Request, body []byte) {
+	if client.hmacSecret != "" {
+		timestamp := strconv.FormatInt(time.Now().Unix(), 10)
+		req.Header.Set(headerKey, client.hmacKey)
+		req.Header.Set(headerTimestamp, timestamp)
+		req.Header.Set(headerSignature,
+			signRequest(client.hmacSecret, req.Method, client.requestURI(req), timestamp, body))
+		return
+	}
+
+	if client.token != "" {
+		req.Header.Set("Authorization", "Bearer "+client.token)
+	}
+}
+
+// requestURI returns the URI of the request as it is received by the server,
+// the reverse proxy strips the base path.
+func (client *Client) requestURI(req *http.Request) string {
+	return "/" + strings.TrimPrefix(req.URL.RequestURI(), client.basePath)
+}
+
+// signRequest computes the HMAC-SHA256 signature of the request,
+// it should be in sync with `server.SignRequest`.
+func signRequest(secret, method, requestURI, timestamp string, body []byte) string {
+	bodyHash := sha256.Sum256(body)
+
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(method + "\n" + requestURI + "\n" + timestamp + "\n" + hex.EncodeToString(bodyHash[:])))
+	return hex.EncodeToString(mac.Sum(nil))
+}
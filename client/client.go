@@ -2,16 +2,24 @@ package client
 
 import (
 	"bytes"
+	"context"
+	"crypto/tls"
 	"encoding/json"
+	"io"
+	"io/ioutil"
 	"net/http"
 	"net/url"
+	"time"
 
 	"github.com/pkg/errors"
 )
 
 type Message struct {
-	Topic string          `json:"topic"`
-	Data  json.RawMessage `json:"data,omitempty"`
+	// ID is the identifier of the polled message.
+	ID      int64           `json:"id,omitempty"`
+	Topic   string          `json:"topic"`
+	Data    json.RawMessage `json:"data,omitempty"`
+	Dropped int64           `json:"dropped,omitempty"`
 }
 
 type PollReq struct {
@@ -19,10 +27,51 @@ type PollReq struct {
 	Subscriber string `json:"subscriber"`
 }
 
+// SubscribeOptions is a limits of the subscriber queue.
+type SubscribeOptions struct {
+	// MaxPending is a limit of unread messages for the subscriber, 0 means no limit.
+	MaxPending int `json:"max_pending,omitempty"`
+	// Overflow is the policy applied when the queue is full:
+	// `drop_oldest` (default), `drop_newest`, `unsubscribe` or `reject`.
+	Overflow string `json:"overflow,omitempty"`
+}
+
+// nackReq is the request of the message to return to the queue.
+type nackReq struct {
+	PollReq
+	ID int64 `json:"id"`
+}
+
+type SubscribeReq struct {
+	PollReq
+	SubscribeOptions
+}
+
+// TopicInfo is a snapshot of the topic state.
+type TopicInfo struct {
+	Name             string        `json:"name"`
+	Subscribers      int64         `json:"subscribers"`
+	Messages         int           `json:"messages"`
+	Bytes            int64         `json:"bytes"`
+	LastID           int64         `json:"last_id"`
+	OldestPendingAge time.Duration `json:"oldest_pending_age"`
+}
+
+// SubscriberInfo is a snapshot of the subscription state.
+type SubscriberInfo struct {
+	Name       string    `json:"name"`
+	Pending    int       `json:"pending"`
+	Dropped    int64     `json:"dropped"`
+	MaxPending int       `json:"max_pending"`
+	Overflow   string    `json:"overflow"`
+	Owner      string    `json:"owner,omitempty"`
+	LastPoll   time.Time `json:"last_poll"`
+}
+
 // PollyClient is a client for the Polly Pub/Sub Server.
 type PollyClient interface {
 	// Poll receiving the next unseen message or no message if everything is seen,
-	// or an error if the subscription is not found.
+	// or ErrNotSubscribed if the subscription is not found.
 	Poll(topic, subscriber string) (json.RawMessage, error)
 	// Publish send a new message to the topic.
 	Publish(topic string, data json.RawMessage) error
@@ -32,74 +81,343 @@ type PollyClient interface {
 	Unsubscribe(topic, subscriber string) error
 }
 
-type client struct {
-	url  url.URL
-	http http.Client
+// OptionsSubscriber is implemented by the PollyClient which supports the subscription options.
+type OptionsSubscriber interface {
+	// SubscribeWithOptions add a subscriber subscription with the queue limits to a topic.
+	SubscribeWithOptions(topic, subscriber string, opts SubscribeOptions) error
 }
 
-// NewClient create new instance of the PollyClient.
-func NewClient(baseAddr string) (PollyClient, error) {
+// Inspector is implemented by the PollyClient which supports the statistics of the topics.
+type Inspector interface {
+	// Topics returns the statistics of all topics.
+	Topics() ([]TopicInfo, error)
+	// Topic returns the statistics of the topic.
+	Topic(topic string) (TopicInfo, error)
+	// Subscribers returns the statistics of the topic subscribers.
+	Subscribers(topic string) ([]SubscriberInfo, error)
+}
+
+// Client is a context-aware client for the Polly Pub/Sub Server, it is safe for concurrent use.
+// The requests respect the deadline and cancellation of the context.
+type Client struct {
+	url         url.URL
+	basePath    string
+	http        *http.Client
+	tlsConfig   *tls.Config
+	timeout     time.Duration
+	header      http.Header
+	userAgent   string
+	retryPolicy RetryPolicy
+	// rawSchemas is the JSON schemas of the topics set by the options, they are compiled into schemas.
+	rawSchemas map[string]json.RawMessage
+	schemas    map[string]*schema
+
+	namespace  string
+	token      string
+	hmacKey    string
+	hmacSecret string
+}
+
+// New creates new instance of the Client.
+func New(baseAddr string, opts ...Option) (*Client, error) {
 	parsed, err := url.Parse(baseAddr)
 	if err != nil {
 		return nil, err
 	}
 
-	return &client{url: *parsed}, nil
-}
+	client := &Client{
+		url:       *parsed,
+		basePath:  parsed.Path,
+		http:      &http.Client{},
+		timeout:   DefaultTimeout,
+		header:    http.Header{},
+		userAgent: DefaultUserAgent,
+	}
+	for _, opt := range opts {
+		opt(client)
+	}
 
-func (client *client) Publish(topic string, data json.RawMessage) error {
-	return client.postData("publish", Message{Topic: topic, Data: data})
+	client.basePath = cleanBasePath(client.basePath)
+	if client.schemas, err = compileSchemas(client.rawSchemas); err != nil {
+		return nil, err
+	}
+	if client.tlsConfig != nil {
+		client.http = withTLS(client.http, client.tlsConfig)
+	}
+	return client, nil
 }
 
-func (client *client) Subscribe(topic, subscriber string) error {
-	return client.postData("subscribe", PollReq{Topic: topic, Subscriber: subscriber})
+// NewClient create new instance of the PollyClient.
+func NewClient(baseAddr string, opts ...Option) (PollyClient, error) {
+	client, err := New(baseAddr, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return Compat(client), nil
 }
 
-func (client *client) Unsubscribe(topic, subscriber string) error {
-	return client.postData("unsubscribe", PollReq{Topic: topic, Subscriber: subscriber})
+// Publish send a new message to the topic.
+// If the retries are enabled, the message is sent with the random idempotency key, so it is published once.
+func (client *Client) Publish(ctx context.Context, topic string, data json.RawMessage) error {
+	key := ""
+	if client.retryPolicy.MaxAttempts > 1 {
+		key = newIdempotencyKey()
+	}
+	return client.PublishWithKey(ctx, topic, data, key)
 }
 
-func (client *client) Poll(topic, subscriber string) (json.RawMessage, error) {
-	query := url.Values{}
-	query.Set("topic", topic)
-	query.Set("subscriber", subscriber)
+// PublishWithKey send a new message to the topic with the idempotency key, the server publishes
+// the messages with the same key only once while the key is remembered. The empty key is not sent.
+func (client *Client) PublishWithKey(ctx context.Context, topic string, data json.RawMessage, key string) error {
+	if err := client.validate(topic, data); err != nil {
+		return err
+	}
 
-	client.url.Path = "poll"
-	client.url.RawQuery = query.Encode()
-	resp, err := client.http.Get(client.url.String())
+	header := http.Header{}
+	if key != "" {
+		header.Set(headerIdempotencyKey, key)
+	}
+	return client.postData(ctx, "publish", header, key != "", Message{Topic: topic, Data: data})
+}
+
+// PublishJSON encodes the value to JSON and sends it to the topic.
+func (client *Client) PublishJSON(ctx context.Context, topic string, v interface{}) error {
+	data, err := json.Marshal(v)
 	if err != nil {
-		return nil, errors.Wrap(err, "unable to send request")
+		return errors.Wrap(err, "unable to encode message")
 	}
+	return client.Publish(ctx, topic, data)
+}
 
-	if resp.StatusCode != http.StatusOK {
-		return nil, errors.New("request failed with status: " + resp.Status)
+// Subscribe add a subscriber subscription to a topic.
+func (client *Client) Subscribe(ctx context.Context, topic, subscriber string) error {
+	return client.postData(ctx, "subscribe", nil, true, PollReq{Topic: topic, Subscriber: subscriber})
+}
+
+// SubscribeWithOptions add a subscriber subscription with the queue limits to a topic.
+func (client *Client) SubscribeWithOptions(
+	ctx context.Context, topic, subscriber string, opts SubscribeOptions,
+) error {
+	return client.postData(ctx, "subscribe", nil, true, SubscribeReq{
+		PollReq:          PollReq{Topic: topic, Subscriber: subscriber},
+		SubscribeOptions: opts,
+	})
+}
+
+// Unsubscribe remove the subscription from the topic.
+func (client *Client) Unsubscribe(ctx context.Context, topic, subscriber string) error {
+	return client.postData(ctx, "unsubscribe", nil, false, PollReq{Topic: topic, Subscriber: subscriber})
+}
+
+// Poll receiving the next unseen message or no message if everything is seen,
+// or ErrNotSubscribed if the subscription is not found.
+func (client *Client) Poll(ctx context.Context, topic, subscriber string) (json.RawMessage, error) {
+	msg, err := client.Fetch(ctx, topic, subscriber)
+	return msg.Data, err
+}
+
+// PollInto receiving the next unseen message and decodes it into the value `v`,
+// it returns `false` if there is no message.
+func (client *Client) PollInto(ctx context.Context, topic, subscriber string, v interface{}) (bool, error) {
+	data, err := client.Poll(ctx, topic, subscriber)
+	if err != nil || data == nil {
+		return false, err
+	}
+
+	if err := json.Unmarshal(data, v); err != nil {
+		return true, errors.Wrap(err, "unable to decode message")
+	}
+	return true, nil
+}
+
+// Fetch receiving the next unseen message together with the count of messages dropped since the previous poll.
+func (client *Client) Fetch(ctx context.Context, topic, subscriber string) (Message, error) {
+	return client.FetchWait(ctx, topic, subscriber, 0)
+}
+
+// FetchWait works like Fetch, but if there are no unseen messages the server waits for a new one
+// up to `wait` (long poll). The server limits the wait time, 30s by default.
+// The timeout of the client is extended by the wait time.
+func (client *Client) FetchWait(ctx context.Context, topic, subscriber string, wait time.Duration) (Message, error) {
+	query := url.Values{}
+	query.Set("topic", topic)
+	query.Set("subscriber", subscriber)
+	if wait > 0 {
+		query.Set("wait", wait.String())
 	}
 
 	data := Message{}
-	err = json.NewDecoder(resp.Body).Decode(&data)
-	if err != nil {
-		return nil, errors.Wrap(err, "unable to decode response")
+	err := client.send(ctx, request{method: http.MethodGet, endpoint: client.endpoint("poll", query), wait: wait}, &data)
+	return data, err
+}
+
+// Nack returns the polled message to the front of the subscriber queue, so it is received by the next poll.
+func (client *Client) Nack(ctx context.Context, topic, subscriber string, msg Message) error {
+	return client.postData(ctx, "nack", nil, false, nackReq{
+		PollReq: PollReq{Topic: topic, Subscriber: subscriber},
+		ID:      msg.ID,
+	})
+}
+
+// Topics returns the statistics of all topics.
+func (client *Client) Topics(ctx context.Context) ([]TopicInfo, error) {
+	var data []TopicInfo
+	err := client.getData(ctx, "topics", nil, &data)
+	return data, err
+}
+
+// Topic returns the statistics of the topic.
+func (client *Client) Topic(ctx context.Context, topic string) (TopicInfo, error) {
+	data := TopicInfo{}
+	err := client.getData(ctx, "topics/"+url.PathEscape(topic), nil, &data)
+	return data, err
+}
+
+// Subscribers returns the statistics of the topic subscribers.
+func (client *Client) Subscribers(ctx context.Context, topic string) ([]SubscriberInfo, error) {
+	var data []SubscriberInfo
+	err := client.getData(ctx, "topics/"+url.PathEscape(topic)+"/subscribers", nil, &data)
+	return data, err
+}
+
+// endpoint builds the URL of the request, the path is relative to the base path and the namespace of the client.
+// The client URL is copied, so the concurrent requests do not interfere.
+func (client *Client) endpoint(path string, query url.Values) string {
+	if client.namespace != "" {
+		path = "ns/" + url.PathEscape(client.namespace) + "/" + path
 	}
+	path = client.basePath + path
 
-	return data.Data, nil
+	endpoint := client.url
+	endpoint.Path, _ = url.PathUnescape(path)
+	endpoint.RawPath = path
+	endpoint.RawQuery = query.Encode()
+	return endpoint.String()
 }
 
-func (client *client) postData(path string, body interface{}) error {
+// getData requests the state which is safe to retry.
+func (client *Client) getData(ctx context.Context, path string, query url.Values, result interface{}) error {
+	req := request{method: http.MethodGet, endpoint: client.endpoint(path, query), idempotent: true}
+	return client.send(ctx, req, result)
+}
+
+func (client *Client) postData(
+	ctx context.Context, path string, header http.Header, idempotent bool, body interface{},
+) error {
 	raw, err := json.Marshal(body)
 	if err != nil {
 		return errors.Wrap(err, "unable to encode request")
 	}
 
-	client.url.Path = path
-	resp, err := client.http.Post(client.url.String(),
-		"application/json", bytes.NewBuffer(raw))
+	return client.send(ctx, request{
+		method:     http.MethodPost,
+		endpoint:   client.endpoint(path, nil),
+		header:     header,
+		body:       raw,
+		idempotent: idempotent,
+	}, nil)
+}
+
+// request is the parameters of the HTTP request.
+type request struct {
+	method   string
+	endpoint string
+	header   http.Header
+	// body is the JSON body, the request has no body if it is nil.
+	body []byte
+	// idempotent marks the request which can be retried after the network error.
+	idempotent bool
+	// wait is the time the server may hold the request, it extends the timeout.
+	wait time.Duration
+}
+
+// send makes the request, retrying it according to the policy, and decodes the response into the result.
+func (client *Client) send(ctx context.Context, req request, result interface{}) error {
+	return client.retry(ctx, req.idempotent, func() error {
+		return client.attempt(ctx, req, result)
+	})
+}
+
+// attempt makes the request once, the timeout of the client is applied to each attempt.
+func (client *Client) attempt(ctx context.Context, req request, result interface{}) error {
+	if client.timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, client.timeout+req.wait)
+		defer cancel()
+	}
+
+	httpReq, err := client.newRequest(ctx, req)
+	if err != nil {
+		return err
+	}
+
+	resp, err := client.do(ctx, httpReq)
 	if err != nil {
-		return errors.Wrap(err, "unable to send request")
+		return err
 	}
+	defer closeBody(resp)
 
 	if resp.StatusCode != http.StatusOK {
-		return errors.New("request failed with status: " + resp.Status)
+		return responseError(resp)
+	}
+	if result == nil {
+		return nil
+	}
+
+	err = json.NewDecoder(resp.Body).Decode(result)
+	if err != nil {
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+		return errors.Wrap(err, "unable to decode response")
 	}
 
 	return nil
 }
+
+// newRequest creates the HTTP request with the headers and the authentication of the client.
+func (client *Client) newRequest(ctx context.Context, req request) (*http.Request, error) {
+	var reader io.Reader
+	if req.body != nil {
+		reader = bytes.NewReader(req.body)
+	}
+
+	httpReq, err := http.NewRequestWithContext(ctx, req.method, req.endpoint, reader)
+	if err != nil {
+		return nil, errors.Wrap(err, "unable to create request")
+	}
+
+	for _, header := range []http.Header{client.header, req.header} {
+		for key, values := range header {
+			httpReq.Header[key] = append([]string(nil), values...)
+		}
+	}
+	httpReq.Header.Set("User-Agent", client.userAgent)
+	if req.body != nil {
+		httpReq.Header.Set("Content-Type", "application/json")
+	}
+	client.authorize(httpReq, req.body)
+	return httpReq, nil
+}
+
+// do sends the request, the context error is returned as is if the request is canceled.
+func (client *Client) do(ctx context.Context, req *http.Request) (*http.Response, error) {
+	resp, err := client.http.Do(req)
+	if err != nil {
+		if ctx.Err() != nil {
+			return nil, ctx.Err()
+		}
+		return nil, errors.Wrap(err, "unable to send request")
+	}
+	return resp, nil
+}
+
+// maxDrainBytes is the limit of the unread response body discarded before closing,
+// the connection of the larger one is not reused.
+const maxDrainBytes = 64 << 10
+
+// closeBody reads the rest of the response body, so the connection can be reused, and closes it.
+func closeBody(resp *http.Response) {
+	_, _ = io.Copy(ioutil.Discard, io.LimitReader(resp.Body, maxDrainBytes))
+	_ = resp.Body.Close()
+}
@@ -0,0 +1,148 @@
+// Package clienttest provides the utilities for testing the code which uses the Polly client.
+package clienttest
+
+import (
+	"encoding/json"
+
+	"github.com/sheb-gregor/polly-demo/client"
+	"github.com/sheb-gregor/polly-demo/mq"
+	"github.com/sheb-gregor/polly-demo/server"
+)
+
+// Fake is the PollyClient backed directly by the in-process broker,
+// it validates the requests and returns the same errors as the server does.
+type Fake struct {
+	broker    *mq.Broker
+	namespace string
+}
+
+var (
+	_ client.PollyClient       = (*Fake)(nil)
+	_ client.OptionsSubscriber = (*Fake)(nil)
+	_ client.Inspector         = (*Fake)(nil)
+)
+
+// NewFake creates the client of the default namespace of the broker.
+func NewFake(broker *mq.Broker) *Fake {
+	return NewFakeWithNamespace(broker, "")
+}
+
+// NewFakeWithNamespace creates the client of the namespace of the broker.
+func NewFakeWithNamespace(broker *mq.Broker, namespace string) *Fake {
+	return &Fake{broker: broker, namespace: namespace}
+}
+
+// ns returns the namespace of the client, it is looked up by each request
+// as the namespace without topics is deleted from the broker.
+func (fake *Fake) ns() *mq.Namespace {
+	ns, _ := fake.broker.LookupNamespace(fake.namespace)
+	return ns
+}
+
+// Poll receiving the next unseen message or no message if everything is seen,
+// or ErrNotSubscribed if the subscription is not found.
+func (fake *Fake) Poll(topic, subscriber string) (json.RawMessage, error) {
+	if err := (server.PollReq{Topic: topic, Subscriber: subscriber}).Validate(); err != nil {
+		return nil, apiError(err)
+	}
+
+	msg, subscribed := fake.ns().Poll(topic, subscriber)
+	if !subscribed {
+		return nil, apiError(server.ErrNotSubscribed)
+	}
+	return msg, nil
+}
+
+// Publish send a new message to the topic.
+func (fake *Fake) Publish(topic string, data json.RawMessage) error {
+	if err := (server.Message{Topic: topic, Data: data}).Validate(); err != nil {
+		return apiError(err)
+	}
+	return apiError(fake.ns().HandleNewMessage(topic, data))
+}
+
+// Subscribe add a subscriber subscription to a topic.
+func (fake *Fake) Subscribe(topic, subscriber string) error {
+	return fake.SubscribeWithOptions(topic, subscriber, client.SubscribeOptions{})
+}
+
+// SubscribeWithOptions add a subscriber subscription with the queue limits to a topic.
+func (fake *Fake) SubscribeWithOptions(topic, subscriber string, opts client.SubscribeOptions) error {
+	req := server.SubscribeReq{
+		PollReq:    server.PollReq{Topic: topic, Subscriber: subscriber},
+		MaxPending: opts.MaxPending,
+		Overflow:   mq.OverflowPolicy(opts.Overflow),
+	}
+	if err := req.Validate(); err != nil {
+		return apiError(err)
+	}
+
+	ns := fake.broker.GetNamespace(fake.namespace)
+	options := req.Options()
+	if opts == (client.SubscribeOptions{}) {
+		options = ns.SubscriptionDefaults()
+	}
+	ns.SubscribeWithOptions(topic, subscriber, options)
+	return nil
+}
+
+// Unsubscribe remove the subscription from the topic.
+func (fake *Fake) Unsubscribe(topic, subscriber string) error {
+	if err := (server.PollReq{Topic: topic, Subscriber: subscriber}).Validate(); err != nil {
+		return apiError(err)
+	}
+
+	fake.ns().Unsubscribe(topic, subscriber)
+	return nil
+}
+
+// Topics returns the statistics of all topics.
+func (fake *Fake) Topics() ([]client.TopicInfo, error) {
+	var topics []client.TopicInfo
+	err := convert(fake.ns().Topics(), &topics)
+	return topics, err
+}
+
+// Topic returns the statistics of the topic.
+func (fake *Fake) Topic(topic string) (client.TopicInfo, error) {
+	info, ok := fake.ns().TopicInfo(topic)
+	if !ok {
+		return client.TopicInfo{}, apiError(server.ErrTopicNotFound)
+	}
+
+	result := client.TopicInfo{}
+	err := convert(info, &result)
+	return result, err
+}
+
+// Subscribers returns the statistics of the topic subscribers.
+func (fake *Fake) Subscribers(topic string) ([]client.SubscriberInfo, error) {
+	subscribers, ok := fake.ns().Subscribers(topic)
+	if !ok {
+		return nil, apiError(server.ErrTopicNotFound)
+	}
+
+	var result []client.SubscriberInfo
+	err := convert(subscribers, &result)
+	return result, err
+}
+
+// convert copies the broker state into the client type the same way as it is sent by the server.
+func convert(src, dest interface{}) error {
+	raw, err := json.Marshal(src)
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(raw, dest)
+}
+
+// apiError converts the errors of the request validation and the broker to the client errors
+// the same way as the server does.
+func apiError(err error) error {
+	if err == nil {
+		return nil
+	}
+
+	e := server.APIError(err)
+	return &client.APIError{StatusCode: e.Status, Code: string(e.Code), Message: e.Message}
+}
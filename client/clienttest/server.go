@@ -0,0 +1,43 @@
+package clienttest
+
+import (
+	"net/http/httptest"
+
+	"github.com/sheb-gregor/polly-demo/client"
+	"github.com/sheb-gregor/polly-demo/mq"
+	"github.com/sheb-gregor/polly-demo/server"
+)
+
+// Server is the Polly HTTP API of the broker listening on a random local port.
+type Server struct {
+	*httptest.Server
+	Broker *mq.Broker
+}
+
+// NewServer starts the API of the broker with the default configuration, it should be closed by Close.
+func NewServer(broker *mq.Broker) *Server {
+	return NewServerWithConfig(broker, server.Config{})
+}
+
+// NewServerWithConfig starts the API of the broker, it should be closed by Close.
+func NewServerWithConfig(broker *mq.Broker, cfg server.Config) *Server {
+	return &Server{
+		Server: httptest.NewServer(server.NewHandler(broker, cfg)),
+		Broker: broker,
+	}
+}
+
+// NewClient creates the client of the server.
+func (s *Server) NewClient(opts ...client.Option) *client.Client {
+	pClient, err := client.New(s.URL, opts...)
+	if err != nil {
+		// the URL of the test server is always valid.
+		panic(err)
+	}
+	return pClient
+}
+
+// NewPollyClient creates the client of the server without contexts.
+func (s *Server) NewPollyClient(opts ...client.Option) client.PollyClient {
+	return client.Compat(s.NewClient(opts...))
+}
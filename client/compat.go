@@ -0,0 +1,53 @@
+package client
+
+import (
+	"context"
+	"encoding/json"
+)
+
+// compatClient implements the PollyClient, OptionsSubscriber and Inspector with the requests without deadline.
+type compatClient struct {
+	client *Client
+}
+
+var (
+	_ OptionsSubscriber = compatClient{}
+	_ Inspector         = compatClient{}
+)
+
+// Compat wraps the Client into the PollyClient interface, its methods use the background context.
+func Compat(client *Client) PollyClient {
+	return compatClient{client: client}
+}
+
+func (c compatClient) Poll(topic, subscriber string) (json.RawMessage, error) {
+	return c.client.Poll(context.Background(), topic, subscriber)
+}
+
+func (c compatClient) Publish(topic string, data json.RawMessage) error {
+	return c.client.Publish(context.Background(), topic, data)
+}
+
+func (c compatClient) Subscribe(topic, subscriber string) error {
+	return c.client.Subscribe(context.Background(), topic, subscriber)
+}
+
+func (c compatClient) SubscribeWithOptions(topic, subscriber string, opts SubscribeOptions) error {
+	return c.client.SubscribeWithOptions(context.Background(), topic, subscriber, opts)
+}
+
+func (c compatClient) Unsubscribe(topic, subscriber string) error {
+	return c.client.Unsubscribe(context.Background(), topic, subscriber)
+}
+
+func (c compatClient) Topics() ([]TopicInfo, error) {
+	return c.client.Topics(context.Background())
+}
+
+func (c compatClient) Topic(topic string) (TopicInfo, error) {
+	return c.client.Topic(context.Background(), topic)
+}
+
+func (c compatClient) Subscribers(topic string) ([]SubscriberInfo, error) {
+	return c.client.Subscribers(context.Background(), topic)
+}
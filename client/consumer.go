@@ -0,0 +1,241 @@
+package client
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+// Defaults of the ConsumerConfig.
+const (
+	DefaultConsumerWait       = 20 * time.Second
+	DefaultConsumerRetryDelay = time.Second
+)
+
+// DefaultConsumerRedelivery returns the failed message to the queue after 100ms, doubling the delay
+// with each next failure up to 30 seconds, without the limit of attempts.
+var DefaultConsumerRedelivery = RetryPolicy{
+	MinBackoff: 100 * time.Millisecond,
+	MaxBackoff: 30 * time.Second,
+	Jitter:     0.5,
+}
+
+// Handler processes the message received by the Consumer. The message is returned
+// to the front of the queue if the handler fails, so it will be received again.
+type Handler func(ctx context.Context, msg Message) error
+
+// ConsumerConfig is the parameters of the Consumer.
+type ConsumerConfig struct {
+	Topic      string
+	Subscriber string
+	// Options is the limits of the subscriber queue.
+	Options SubscribeOptions
+	// Concurrency is the count of messages handled at once, default is 1.
+	// The messages are handled in order only if it is 1.
+	Concurrency int
+	// Wait is the time the long poll waits for a new message, default is DefaultConsumerWait.
+	Wait time.Duration
+	// RetryDelay is the pause after the failed poll, default is DefaultConsumerRetryDelay.
+	RetryDelay time.Duration
+	// Redelivery is the backoff before the message failed by the handler is returned to the queue,
+	// the delay grows with each failure of the message. Its MaxAttempts limits the handler calls
+	// of the message, then it is passed to OnDeadLetter and is not returned, 0 means no limit.
+	// The backoff is DefaultConsumerRedelivery if MinBackoff is not set.
+	Redelivery RetryPolicy
+	// OnDeadLetter is called with the message and the last error of the handler
+	// when the message fails Redelivery.MaxAttempts times.
+	OnDeadLetter func(msg Message, err error)
+	// ShutdownTimeout is the time given to the running handlers to finish after the consumer is stopped,
+	// then their context is canceled. 0 means waiting until they return.
+	ShutdownTimeout time.Duration
+	// Unsubscribe removes the subscription when the consumer is stopped.
+	Unsubscribe bool
+	// OnError is called with the errors of the polls and handlers, they are not returned by Run.
+	OnError func(err error)
+}
+
+// Consumer subscribes to the topic and dispatches the received messages to the handler.
+//
+// The server removes the message from the queue when it is polled, so the successful handling
+// acknowledges it. If the handler fails, the message is returned to the front of the queue (nack).
+type Consumer struct {
+	client  *Client
+	cfg     ConsumerConfig
+	handler Handler
+
+	mu sync.Mutex
+	// failures is the count of the handler failures by the identifier of the message.
+	failures map[int64]int
+}
+
+// NewConsumer creates the consumer of the topic, it is started by Run.
+func NewConsumer(client *Client, cfg ConsumerConfig, handler Handler) *Consumer {
+	if cfg.Concurrency <= 0 {
+		cfg.Concurrency = 1
+	}
+	if cfg.Wait <= 0 {
+		cfg.Wait = DefaultConsumerWait
+	}
+	if cfg.RetryDelay <= 0 {
+		cfg.RetryDelay = DefaultConsumerRetryDelay
+	}
+	if cfg.Redelivery.MinBackoff <= 0 {
+		maxAttempts := cfg.Redelivery.MaxAttempts
+		cfg.Redelivery = DefaultConsumerRedelivery
+		cfg.Redelivery.MaxAttempts = maxAttempts
+	}
+	return &Consumer{client: client, cfg: cfg, handler: handler, failures: map[int64]int{}}
+}
+
+// Run subscribes to the topic and handles the messages until the context is canceled.
+// Then it stops polling, waits for the running handlers and returns nil.
+// It returns the error only if the subscription fails.
+func (consumer *Consumer) Run(ctx context.Context) error {
+	if err := consumer.subscribe(ctx); err != nil {
+		return err
+	}
+
+	// the handlers are not interrupted by the consumer stop until the shutdown timeout is exceeded.
+	handlerCtx, cancelHandlers := context.WithCancel(context.Background())
+	defer cancelHandlers()
+
+	messages := make(chan Message)
+	wg := sync.WaitGroup{}
+	for i := 0; i < consumer.cfg.Concurrency; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for msg := range messages {
+				consumer.handle(ctx, handlerCtx, msg)
+			}
+		}()
+	}
+
+	consumer.poll(ctx, messages)
+	close(messages)
+
+	finished := make(chan struct{})
+	go func() {
+		wg.Wait()
+		close(finished)
+	}()
+
+	if consumer.cfg.ShutdownTimeout > 0 {
+		timer := time.NewTimer(consumer.cfg.ShutdownTimeout)
+		defer timer.Stop()
+
+		select {
+		case <-finished:
+		case <-timer.C:
+			cancelHandlers()
+		}
+	}
+	<-finished
+
+	if consumer.cfg.Unsubscribe {
+		consumer.report(consumer.client.Unsubscribe(context.Background(), consumer.cfg.Topic, consumer.cfg.Subscriber))
+	}
+	return nil
+}
+
+func (consumer *Consumer) subscribe(ctx context.Context) error {
+	return consumer.client.SubscribeWithOptions(ctx, consumer.cfg.Topic, consumer.cfg.Subscriber, consumer.cfg.Options)
+}
+
+// poll receives the messages and passes them to the handlers until the context is canceled.
+func (consumer *Consumer) poll(ctx context.Context, messages chan<- Message) {
+	for ctx.Err() == nil {
+		msg, err := consumer.client.FetchWait(ctx, consumer.cfg.Topic, consumer.cfg.Subscriber, consumer.cfg.Wait)
+		switch {
+		case ctx.Err() != nil:
+			return
+		case isNotSubscribed(err):
+			// the subscription is removed, e.g. by the idle TTL, so it is created again.
+			err = consumer.subscribe(ctx)
+		}
+
+		if err != nil {
+			consumer.report(err)
+			consumer.sleep(ctx, consumer.cfg.RetryDelay)
+			continue
+		}
+		if msg.Data == nil {
+			continue
+		}
+
+		select {
+		case messages <- msg:
+		case <-ctx.Done():
+			// no handler is free, so the message is returned to the queue.
+			consumer.nack(msg)
+			return
+		}
+	}
+}
+
+// handle calls the handler, the failed message is returned to the queue after the redelivery backoff
+// or passed to OnDeadLetter if it failed too many times. The backoff is interrupted when the consumer is stopped.
+func (consumer *Consumer) handle(stop, ctx context.Context, msg Message) {
+	err := consumer.handler(ctx, msg)
+	if err == nil {
+		consumer.forget(msg.ID)
+		return
+	}
+	consumer.report(err)
+
+	failures := consumer.fail(msg.ID)
+	if maxAttempts := consumer.cfg.Redelivery.MaxAttempts; maxAttempts > 0 && failures >= maxAttempts {
+		consumer.forget(msg.ID)
+		if consumer.cfg.OnDeadLetter != nil {
+			consumer.cfg.OnDeadLetter(msg, err)
+		}
+		return
+	}
+
+	consumer.sleep(stop, consumer.cfg.Redelivery.backoff(failures, nil))
+	if consumer.nack(msg) != nil {
+		// the message is not returned, so it will not be received again.
+		consumer.forget(msg.ID)
+	}
+}
+
+// fail increases the count of the handler failures of the message and returns it.
+func (consumer *Consumer) fail(id int64) int {
+	consumer.mu.Lock()
+	defer consumer.mu.Unlock()
+	consumer.failures[id] += 1
+	return consumer.failures[id]
+}
+
+func (consumer *Consumer) forget(id int64) {
+	consumer.mu.Lock()
+	delete(consumer.failures, id)
+	consumer.mu.Unlock()
+}
+
+func (consumer *Consumer) nack(msg Message) error {
+	err := consumer.client.Nack(context.Background(), consumer.cfg.Topic, consumer.cfg.Subscriber, msg)
+	consumer.report(err)
+	return err
+}
+
+func (consumer *Consumer) report(err error) {
+	if err != nil && consumer.cfg.OnError != nil {
+		consumer.cfg.OnError(err)
+	}
+}
+
+func (consumer *Consumer) sleep(ctx context.Context, delay time.Duration) {
+	timer := time.NewTimer(delay)
+	defer timer.Stop()
+
+	select {
+	case <-ctx.Done():
+	case <-timer.C:
+	}
+}
+
+func isNotSubscribed(err error) bool {
+	apiErr, ok := err.(*APIError)
+	return ok && apiErr.Is(ErrNotSubscribed)
+}
@@ -0,0 +1,111 @@
+package client
+
+import (
+	"encoding/json"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// Errors returned by the server, check them with `errors.Is`.
+var (
+	ErrInvalidRequest  = errors.New("invalid request")
+	ErrUnauthorized    = errors.New("unauthorized")
+	ErrAccessDenied    = errors.New("access denied")
+	ErrNotFound        = errors.New("not found")
+	ErrNotSubscribed   = errors.New("not subscribed")
+	ErrTopicNotFound   = errors.New("topic not found")
+	ErrPayloadTooLarge = errors.New("payload too large")
+	ErrRateLimited     = errors.New("rate limited")
+	ErrQueueFull       = errors.New("subscriber queue is full")
+	ErrMemoryLimit     = errors.New("memory limit exceeded")
+	ErrUnavailable     = errors.New("server unavailable")
+	ErrServer          = errors.New("server error")
+)
+
+// codeErrors maps the error codes of the server to the sentinel errors.
+var codeErrors = map[string]error{
+	"invalid_json":        ErrInvalidRequest,
+	"topic_required":      ErrInvalidRequest,
+	"subscriber_required": ErrInvalidRequest,
+	"data_required":       ErrInvalidRequest,
+	"invalid_options":     ErrInvalidRequest,
+	"invalid_parameter":   ErrInvalidRequest,
+	"method_not_allowed":  ErrInvalidRequest,
+	"unauthorized":        ErrUnauthorized,
+	"access_denied":       ErrAccessDenied,
+	"not_owner":           ErrAccessDenied,
+	"admin_disabled":      ErrAccessDenied,
+	"not_found":           ErrNotFound,
+	"not_subscribed":      ErrNotSubscribed,
+	"topic_not_found":     ErrTopicNotFound,
+	"message_not_found":   ErrNotFound,
+	"payload_too_large":   ErrPayloadTooLarge,
+	"rate_limited":        ErrRateLimited,
+	"queue_full":          ErrQueueFull,
+	"topic_memory_limit":  ErrMemoryLimit,
+	"memory_limit":        ErrMemoryLimit,
+	"unavailable":         ErrUnavailable,
+	"internal":            ErrServer,
+}
+
+// APIError is the error response of the server.
+type APIError struct {
+	StatusCode int    `json:"-"`
+	Code       string `json:"code"`
+	Message    string `json:"message"`
+	// RetryAfter is the delay requested by the server with the `Retry-After` header.
+	RetryAfter time.Duration `json:"-"`
+}
+
+func (e *APIError) Error() string {
+	if e.Code == "" {
+		return "request failed with status " + strconv.Itoa(e.StatusCode) + ": " + e.Message
+	}
+	return e.Code + ": " + e.Message
+}
+
+// Is matches the sentinel error of the code, so `errors.Is(err, client.ErrNotSubscribed)` works.
+func (e *APIError) Is(target error) bool {
+	return e.sentinel() == target
+}
+
+func (e *APIError) sentinel() error {
+	if err, ok := codeErrors[e.Code]; ok {
+		return err
+	}
+
+	switch {
+	case e.StatusCode == http.StatusUnauthorized:
+		return ErrUnauthorized
+	case e.StatusCode == http.StatusForbidden:
+		return ErrAccessDenied
+	case e.StatusCode == http.StatusNotFound:
+		return ErrNotFound
+	case e.StatusCode == http.StatusTooManyRequests:
+		return ErrRateLimited
+	case e.StatusCode == http.StatusServiceUnavailable:
+		return ErrUnavailable
+	case e.StatusCode >= http.StatusInternalServerError:
+		return ErrServer
+	default:
+		return ErrInvalidRequest
+	}
+}
+
+// responseError decodes the error response, the unknown body is kept as the message.
+func responseError(resp *http.Response) error {
+	apiErr := &APIError{StatusCode: resp.StatusCode, Message: resp.Status}
+
+	if seconds, err := strconv.Atoi(resp.Header.Get("Retry-After")); err == nil {
+		apiErr.RetryAfter = time.Duration(seconds) * time.Second
+	}
+
+	if strings.HasPrefix(resp.Header.Get("Content-Type"), "application/json") {
+		_ = json.NewDecoder(resp.Body).Decode(apiErr)
+	}
+	return apiErr
+}
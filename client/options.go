@@ -0,0 +1,63 @@
+package client
+
+import (
+	"net/http"
+	"strings"
+	"time"
+)
+
+// DefaultTimeout is the default limit of the request duration.
+const DefaultTimeout = 30 * time.Second
+
+// DefaultUserAgent is the default value of the `User-Agent` header.
+const DefaultUserAgent = "polly-client"
+
+// Option configures the PollyClient.
+type Option func(client *Client)
+
+// WithHTTPClient sets the HTTP client used to send requests, e.g. with a custom transport.
+// The client is not modified, the options like WithTLSConfig are applied to its copy.
+func WithHTTPClient(httpClient *http.Client) Option {
+	return func(client *Client) {
+		client.http = httpClient
+	}
+}
+
+// WithTimeout sets the limit of each request duration, 0 means no limit.
+// The deadline of the request context is respected as well.
+func WithTimeout(timeout time.Duration) Option {
+	return func(client *Client) {
+		client.timeout = timeout
+	}
+}
+
+// WithHeader adds the header sent with every request.
+func WithHeader(key, value string) Option {
+	return func(client *Client) {
+		client.header.Add(key, value)
+	}
+}
+
+// WithUserAgent sets the `User-Agent` header.
+func WithUserAgent(userAgent string) Option {
+	return func(client *Client) {
+		client.userAgent = userAgent
+	}
+}
+
+// WithBasePath sets the path prefix of the API, e.g. `/polly` if the server is behind
+// the reverse proxy which strips this prefix. It overrides the path of the base address.
+func WithBasePath(basePath string) Option {
+	return func(client *Client) {
+		client.basePath = basePath
+	}
+}
+
+// cleanBasePath makes the base path start and end with `/`.
+func cleanBasePath(basePath string) string {
+	basePath = strings.Trim(basePath, "/")
+	if basePath == "" {
+		return "/"
+	}
+	return "/" + basePath + "/"
+}
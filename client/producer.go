@@ -0,0 +1,239 @@
+package client
+
+import (
+	"context"
+	"encoding/json"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// Defaults of the ProducerConfig.
+const (
+	DefaultProducerBatchSize     = 100
+	DefaultProducerFlushInterval = 100 * time.Millisecond
+	DefaultProducerBufferSize    = 10000
+)
+
+// Errors of the Producer.
+var (
+	ErrProducerClosed = errors.New("producer is closed")
+	ErrBufferFull     = errors.New("producer buffer is full")
+)
+
+// Publisher sends the message to the topic, it is implemented by the Client.
+type Publisher interface {
+	Publish(ctx context.Context, topic string, data json.RawMessage) error
+}
+
+// Result is the outcome of the message sent by the Producer.
+type Result struct {
+	Topic string
+	Data  json.RawMessage
+	// Err is the error of the publishing, nil if the message is published.
+	Err error
+}
+
+// ProducerConfig is the parameters of the Producer.
+type ProducerConfig struct {
+	// BatchSize is the count of buffered messages which triggers the flush,
+	// default is DefaultProducerBatchSize.
+	BatchSize int
+	// FlushInterval is the max time the message stays in the buffer, default is DefaultProducerFlushInterval.
+	FlushInterval time.Duration
+	// BufferSize is the limit of buffered messages, Send fails with ErrBufferFull above it.
+	// Default is DefaultProducerBufferSize.
+	BufferSize int
+	// OnResult is called with the result of each message.
+	OnResult func(result Result)
+	// Results receives the result of each message, the producer waits until it is read
+	// or the context of Close is done, then the results are dropped.
+	Results chan<- Result
+}
+
+// Producer buffers the messages in memory and publishes them in the background,
+// so the caller does not wait for the server. The messages are published in order of Send.
+type Producer struct {
+	publisher Publisher
+	cfg       ProducerConfig
+
+	mu        sync.Mutex
+	buffer    []Message
+	enqueued  int64
+	completed int64
+	closed    bool
+	// progress is closed and replaced each time the batch is published.
+	progress chan struct{}
+
+	// wake triggers the flush of the buffer.
+	wake chan struct{}
+	// ctx interrupts the publishing if Close is not finished in time.
+	ctx     context.Context
+	cancel  context.CancelFunc
+	stopped chan struct{}
+}
+
+// NewProducer creates the producer and starts publishing in the background, it should be closed by Close.
+func NewProducer(publisher Publisher, cfg ProducerConfig) *Producer {
+	if cfg.BatchSize <= 0 {
+		cfg.BatchSize = DefaultProducerBatchSize
+	}
+	if cfg.FlushInterval <= 0 {
+		cfg.FlushInterval = DefaultProducerFlushInterval
+	}
+	if cfg.BufferSize <= 0 {
+		cfg.BufferSize = DefaultProducerBufferSize
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	producer := &Producer{
+		publisher: publisher,
+		cfg:       cfg,
+		progress:  make(chan struct{}),
+		wake:      make(chan struct{}, 1),
+		ctx:       ctx,
+		cancel:    cancel,
+		stopped:   make(chan struct{}),
+	}
+	go producer.run()
+	return producer
+}
+
+// Send adds the message to the buffer, the result is reported by the callback or the channel of the config.
+func (producer *Producer) Send(topic string, data json.RawMessage) error {
+	producer.mu.Lock()
+	defer producer.mu.Unlock()
+
+	if producer.closed {
+		return ErrProducerClosed
+	}
+	if len(producer.buffer) >= producer.cfg.BufferSize {
+		return ErrBufferFull
+	}
+
+	producer.buffer = append(producer.buffer, Message{Topic: topic, Data: data})
+	producer.enqueued += 1
+	if len(producer.buffer) >= producer.cfg.BatchSize {
+		producer.flush()
+	}
+	return nil
+}
+
+// Flush publishes the buffered messages and waits until they are sent or the context is done.
+func (producer *Producer) Flush(ctx context.Context) error {
+	producer.mu.Lock()
+	target := producer.enqueued
+	producer.flush()
+	producer.mu.Unlock()
+
+	for {
+		producer.mu.Lock()
+		completed, progress := producer.completed, producer.progress
+		producer.mu.Unlock()
+
+		if completed >= target {
+			return nil
+		}
+
+		select {
+		case <-progress:
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+}
+
+// Close stops accepting the messages and publishes the buffered ones. If the context is done earlier,
+// the publishing is interrupted and the rest of the messages are reported with the error.
+func (producer *Producer) Close(ctx context.Context) error {
+	producer.mu.Lock()
+	producer.closed = true
+	producer.flush()
+	producer.mu.Unlock()
+
+	select {
+	case <-producer.stopped:
+		producer.cancel()
+		return nil
+	case <-ctx.Done():
+		producer.cancel()
+		<-producer.stopped
+		return ctx.Err()
+	}
+}
+
+// flush wakes up the publishing, it should be called under the lock.
+func (producer *Producer) flush() {
+	select {
+	case producer.wake <- struct{}{}:
+	default:
+	}
+}
+
+func (producer *Producer) run() {
+	defer close(producer.stopped)
+
+	ticker := time.NewTicker(producer.cfg.FlushInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-producer.wake:
+		case <-ticker.C:
+		}
+
+		for {
+			batch, closed := producer.take()
+			if len(batch) == 0 {
+				if closed {
+					return
+				}
+				break
+			}
+			producer.publish(batch)
+		}
+	}
+}
+
+// take removes the next batch from the buffer.
+func (producer *Producer) take() ([]Message, bool) {
+	producer.mu.Lock()
+	defer producer.mu.Unlock()
+
+	size := len(producer.buffer)
+	if size > producer.cfg.BatchSize {
+		size = producer.cfg.BatchSize
+	}
+
+	batch := make([]Message, size)
+	copy(batch, producer.buffer)
+	producer.buffer = producer.buffer[size:]
+	return batch, producer.closed
+}
+
+func (producer *Producer) publish(batch []Message) {
+	for _, msg := range batch {
+		err := producer.publisher.Publish(producer.ctx, msg.Topic, msg.Data)
+		producer.report(Result{Topic: msg.Topic, Data: msg.Data, Err: err})
+	}
+
+	producer.mu.Lock()
+	producer.completed += int64(len(batch))
+	close(producer.progress)
+	producer.progress = make(chan struct{})
+	producer.mu.Unlock()
+}
+
+func (producer *Producer) report(result Result) {
+	if producer.cfg.OnResult != nil {
+		producer.cfg.OnResult(result)
+	}
+	if producer.cfg.Results != nil {
+		select {
+		case producer.cfg.Results <- result:
+		case <-producer.ctx.Done():
+			// Close is interrupted by the context, so the results which are not read are dropped.
+		}
+	}
+}
@@ -0,0 +1,118 @@
+package client
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"math"
+	mrand "math/rand"
+	"net"
+	"net/http"
+	"net/url"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// headerIdempotencyKey is the unique key of the publish request,
+// it should be in sync with `server.HeaderIdempotencyKey`.
+const headerIdempotencyKey = "Idempotency-Key"
+
+// RetryPolicy is the parameters of retrying the failed requests with the exponential backoff.
+//
+// The requests are retried if the server is unreachable or responds with `429 Too Many Requests`
+// or `503 Service Unavailable`, these requests are not handled by the server. Other network errors
+// are retried only for the safe operations: introspection, Subscribe and Publish which is sent
+// with the idempotency key. Poll and Unsubscribe are not retried on such errors, the server
+// might have handled them already.
+type RetryPolicy struct {
+	// MaxAttempts is the max count of attempts including the first one, 0 or 1 disables retries.
+	MaxAttempts int
+	// MinBackoff is the delay before the first retry, it doubles with each next one.
+	MinBackoff time.Duration
+	// MaxBackoff is the limit of the delay.
+	MaxBackoff time.Duration
+	// Jitter is the fraction of the delay which is randomized, from 0 to 1.
+	Jitter float64
+}
+
+// DefaultRetryPolicy retries the request up to 3 times within about 2 seconds.
+var DefaultRetryPolicy = RetryPolicy{
+	MaxAttempts: 4,
+	MinBackoff:  200 * time.Millisecond,
+	MaxBackoff:  5 * time.Second,
+	Jitter:      0.5,
+}
+
+// WithRetry enables retries of the failed requests. The `Retry-After` header of the response
+// overrides the backoff, the requests are not retried after the deadline of the context.
+func WithRetry(policy RetryPolicy) Option {
+	return func(client *Client) {
+		client.retryPolicy = policy
+	}
+}
+
+// backoff returns the delay before the next attempt after the `attempt` failed with the error.
+func (policy RetryPolicy) backoff(attempt int, err error) time.Duration {
+	if apiErr, ok := err.(*APIError); ok && apiErr.RetryAfter > 0 {
+		return apiErr.RetryAfter
+	}
+
+	delay := float64(policy.MinBackoff) * math.Pow(2, float64(attempt-1))
+	if policy.MaxBackoff > 0 && delay > float64(policy.MaxBackoff) {
+		delay = float64(policy.MaxBackoff)
+	}
+
+	jitter := math.Min(math.Max(policy.Jitter, 0), 1)
+	return time.Duration(delay * (1 - jitter*mrand.Float64()))
+}
+
+// retryable checks if the failed request can be sent again.
+func retryable(err error, idempotent bool) bool {
+	if apiErr, ok := err.(*APIError); ok {
+		return apiErr.StatusCode == http.StatusTooManyRequests ||
+			apiErr.StatusCode == http.StatusServiceUnavailable
+	}
+
+	if err == context.DeadlineExceeded {
+		// the timeout of the attempt is exceeded, the request might be handled.
+		return idempotent
+	}
+
+	urlErr, ok := errors.Cause(err).(*url.Error)
+	if !ok {
+		return false
+	}
+	if opErr, ok := urlErr.Err.(*net.OpError); ok && opErr.Op == "dial" {
+		// the connection is not established, so the request is not sent.
+		return true
+	}
+	return idempotent
+}
+
+// retry calls `send` until it succeeds or the error is not retryable.
+func (client *Client) retry(ctx context.Context, idempotent bool, send func() error) error {
+	for attempt := 1; ; attempt++ {
+		err := send()
+		if err == nil || ctx.Err() != nil || attempt >= client.retryPolicy.MaxAttempts || !retryable(err, idempotent) {
+			return err
+		}
+
+		timer := time.NewTimer(client.retryPolicy.backoff(attempt, err))
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return ctx.Err()
+		case <-timer.C:
+		}
+	}
+}
+
+// newIdempotencyKey generates the random key of the publish request.
+func newIdempotencyKey() string {
+	key := make([]byte, 16)
+	if _, err := rand.Read(key); err != nil {
+		return ""
+	}
+	return hex.EncodeToString(key)
+}
@@ -0,0 +1,341 @@
+package client
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"math"
+	"reflect"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/pkg/errors"
+)
+
+// ErrSchemaViolation is the reason of the ValidationError, check it with `errors.Is`.
+var ErrSchemaViolation = errors.New("message does not match the topic schema")
+
+// ValidationError is returned when the published message does not match the JSON schema of the topic.
+type ValidationError struct {
+	Topic string
+	// Path is the JSON pointer of the invalid value, empty for the whole message.
+	Path    string
+	Message string
+}
+
+func (e *ValidationError) Error() string {
+	path := e.Path
+	if path == "" {
+		path = "/"
+	}
+	return "topic " + e.Topic + ": " + path + ": " + e.Message
+}
+
+// Is makes the error match ErrSchemaViolation and ErrInvalidRequest.
+func (e *ValidationError) Is(target error) bool {
+	return target == ErrSchemaViolation || target == ErrInvalidRequest
+}
+
+// WithSchema validates the messages published to the topic against the JSON schema before sending.
+// Only a subset of the JSON schema is supported: `type`, `enum`, `const`, `properties`, `required`,
+// `additionalProperties`, `items`, `minItems`, `maxItems`, `minLength`, `maxLength`, `pattern`,
+// `minimum`, `maximum`, `exclusiveMinimum` and `exclusiveMaximum`, other keywords are ignored.
+// The invalid schema is reported by New.
+func WithSchema(topic string, schema json.RawMessage) Option {
+	return func(client *Client) {
+		if client.rawSchemas == nil {
+			client.rawSchemas = map[string]json.RawMessage{}
+		}
+		client.rawSchemas[topic] = schema
+	}
+}
+
+// compileSchemas parses the schemas of the topics.
+func compileSchemas(raw map[string]json.RawMessage) (map[string]*schema, error) {
+	schemas := make(map[string]*schema, len(raw))
+	for topic, data := range raw {
+		compiled, err := compileSchema(data)
+		if err != nil {
+			return nil, errors.Wrap(err, "invalid schema of the topic "+topic)
+		}
+		schemas[topic] = compiled
+	}
+	return schemas, nil
+}
+
+// validate checks the message against the schema of the topic, if it is set.
+func (client *Client) validate(topic string, data json.RawMessage) error {
+	compiled, ok := client.schemas[topic]
+	if !ok {
+		return nil
+	}
+
+	var value interface{}
+	if err := json.Unmarshal(data, &value); err != nil {
+		return &ValidationError{Topic: topic, Message: "invalid JSON: " + err.Error()}
+	}
+
+	if path, message, ok := compiled.validate("", value); !ok {
+		return &ValidationError{Topic: topic, Path: path, Message: message}
+	}
+	return nil
+}
+
+// schema is the compiled subset of the JSON schema.
+type schema struct {
+	// reject is the `false` schema which matches nothing.
+	reject bool
+
+	types      []string
+	enum       []interface{}
+	properties map[string]*schema
+	required   []string
+	// additional is the schema of the properties not listed in `properties`.
+	additional *schema
+	items      *schema
+
+	minItems, maxItems   *int
+	minLength, maxLength *int
+	pattern              *regexp.Regexp
+
+	minimum, maximum                   *float64
+	exclusiveMinimum, exclusiveMaximum *float64
+}
+
+func compileSchema(raw json.RawMessage) (*schema, error) {
+	raw = bytes.TrimSpace(raw)
+	switch string(raw) {
+	case "true":
+		return &schema{}, nil
+	case "false":
+		return &schema{reject: true}, nil
+	}
+
+	var keywords map[string]json.RawMessage
+	if err := json.Unmarshal(raw, &keywords); err != nil {
+		return nil, errors.Wrap(err, "schema should be an object or a boolean")
+	}
+
+	s := &schema{}
+	for keyword, value := range keywords {
+		if err := s.compileKeyword(keyword, value); err != nil {
+			return nil, errors.Wrap(err, keyword)
+		}
+	}
+	return s, nil
+}
+
+func (s *schema) compileKeyword(keyword string, value json.RawMessage) error {
+	var err error
+	switch keyword {
+	case "type":
+		var single string
+		if json.Unmarshal(value, &single) == nil {
+			s.types = []string{single}
+			return nil
+		}
+		err = json.Unmarshal(value, &s.types)
+	case "enum":
+		err = json.Unmarshal(value, &s.enum)
+	case "const":
+		var constant interface{}
+		err = json.Unmarshal(value, &constant)
+		s.enum = []interface{}{constant}
+	case "properties":
+		var properties map[string]json.RawMessage
+		if err = json.Unmarshal(value, &properties); err != nil {
+			return err
+		}
+		s.properties = make(map[string]*schema, len(properties))
+		for name, property := range properties {
+			if s.properties[name], err = compileSchema(property); err != nil {
+				return errors.Wrap(err, name)
+			}
+		}
+	case "required":
+		err = json.Unmarshal(value, &s.required)
+	case "additionalProperties":
+		s.additional, err = compileSchema(value)
+	case "items":
+		s.items, err = compileSchema(value)
+	case "minItems":
+		err = json.Unmarshal(value, &s.minItems)
+	case "maxItems":
+		err = json.Unmarshal(value, &s.maxItems)
+	case "minLength":
+		err = json.Unmarshal(value, &s.minLength)
+	case "maxLength":
+		err = json.Unmarshal(value, &s.maxLength)
+	case "pattern":
+		var pattern string
+		if err = json.Unmarshal(value, &pattern); err != nil {
+			return err
+		}
+		s.pattern, err = regexp.Compile(pattern)
+	case "minimum":
+		err = json.Unmarshal(value, &s.minimum)
+	case "maximum":
+		err = json.Unmarshal(value, &s.maximum)
+	case "exclusiveMinimum":
+		err = json.Unmarshal(value, &s.exclusiveMinimum)
+	case "exclusiveMaximum":
+		err = json.Unmarshal(value, &s.exclusiveMaximum)
+	}
+	return err
+}
+
+// validate checks the value decoded from JSON, if it does not match
+// the schema it returns the path of the invalid value and the reason.
+func (s *schema) validate(path string, value interface{}) (string, string, bool) {
+	if s.reject {
+		return path, "no value is allowed", false
+	}
+
+	if len(s.types) > 0 && !s.matchType(value) {
+		return path, "should be " + strings.Join(s.types, " or "), false
+	}
+
+	if len(s.enum) > 0 && !s.matchEnum(value) {
+		return path, "should be one of the allowed values", false
+	}
+
+	switch v := value.(type) {
+	case map[string]interface{}:
+		return s.validateObject(path, v)
+	case []interface{}:
+		return s.validateArray(path, v)
+	case string:
+		return s.validateString(path, v)
+	case float64:
+		return s.validateNumber(path, v)
+	}
+	return "", "", true
+}
+
+func (s *schema) matchType(value interface{}) bool {
+	for _, name := range s.types {
+		switch v := value.(type) {
+		case map[string]interface{}:
+			if name == "object" {
+				return true
+			}
+		case []interface{}:
+			if name == "array" {
+				return true
+			}
+		case string:
+			if name == "string" {
+				return true
+			}
+		case float64:
+			if name == "number" || name == "integer" && v == math.Trunc(v) {
+				return true
+			}
+		case bool:
+			if name == "boolean" {
+				return true
+			}
+		case nil:
+			if name == "null" {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+func (s *schema) matchEnum(value interface{}) bool {
+	for _, allowed := range s.enum {
+		if reflect.DeepEqual(allowed, value) {
+			return true
+		}
+	}
+	return false
+}
+
+func (s *schema) validateObject(path string, object map[string]interface{}) (string, string, bool) {
+	for _, name := range s.required {
+		if _, ok := object[name]; !ok {
+			return path, "property " + strconv.Quote(name) + " is required", false
+		}
+	}
+
+	// the properties are checked in order, so the same error is reported each time.
+	names := make([]string, 0, len(object))
+	for name := range object {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	for _, name := range names {
+		property, ok := s.properties[name]
+		if !ok {
+			property = s.additional
+		}
+		if property == nil {
+			continue
+		}
+		if p, message, ok := property.validate(path+"/"+escapePointer(name), object[name]); !ok {
+			return p, message, false
+		}
+	}
+	return "", "", true
+}
+
+func (s *schema) validateArray(path string, array []interface{}) (string, string, bool) {
+	if s.minItems != nil && len(array) < *s.minItems {
+		return path, fmt.Sprintf("should have at least %d items", *s.minItems), false
+	}
+	if s.maxItems != nil && len(array) > *s.maxItems {
+		return path, fmt.Sprintf("should have at most %d items", *s.maxItems), false
+	}
+
+	if s.items != nil {
+		for i, item := range array {
+			if p, message, ok := s.items.validate(path+"/"+strconv.Itoa(i), item); !ok {
+				return p, message, false
+			}
+		}
+	}
+	return "", "", true
+}
+
+func (s *schema) validateString(path, value string) (string, string, bool) {
+	length := utf8.RuneCountInString(value)
+	if s.minLength != nil && length < *s.minLength {
+		return path, fmt.Sprintf("should be at least %d characters long", *s.minLength), false
+	}
+	if s.maxLength != nil && length > *s.maxLength {
+		return path, fmt.Sprintf("should be at most %d characters long", *s.maxLength), false
+	}
+	if s.pattern != nil && !s.pattern.MatchString(value) {
+		return path, "should match the pattern " + s.pattern.String(), false
+	}
+	return "", "", true
+}
+
+func (s *schema) validateNumber(path string, value float64) (string, string, bool) {
+	format := func(limit float64) string {
+		return strconv.FormatFloat(limit, 'g', -1, 64)
+	}
+
+	switch {
+	case s.minimum != nil && value < *s.minimum:
+		return path, "should be >= " + format(*s.minimum), false
+	case s.maximum != nil && value > *s.maximum:
+		return path, "should be <= " + format(*s.maximum), false
+	case s.exclusiveMinimum != nil && value <= *s.exclusiveMinimum:
+		return path, "should be > " + format(*s.exclusiveMinimum), false
+	case s.exclusiveMaximum != nil && value >= *s.exclusiveMaximum:
+		return path, "should be < " + format(*s.exclusiveMaximum), false
+	}
+	return "", "", true
+}
+
+// escapePointer escapes the property name for the JSON pointer.
+func escapePointer(name string) string {
+	return strings.Replace(strings.Replace(name, "~", "~0", -1), "/", "~1", -1)
+}
@@ -0,0 +1,60 @@
+package client
+
+import (
+	"encoding/json"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSchema(t *testing.T) {
+	compiled, err := compileSchema(json.RawMessage(`{
+		"type": "object",
+		"required": ["id", "items"],
+		"properties": {
+			"id": {"type": "integer", "minimum": 1},
+			"status": {"enum": ["new", "paid"]},
+			"email": {"type": ["string", "null"], "pattern": "^[^@]+@[^@]+$"},
+			"items": {
+				"type": "array",
+				"minItems": 1,
+				"items": {"type": "object", "properties": {"sku": {"type": "string", "maxLength": 4}}}
+			}
+		},
+		"additionalProperties": false
+	}`))
+	assert.NoError(t, err)
+
+	cases := []struct {
+		data    string
+		path    string
+		message string
+	}{
+		{`{"id": 1, "items": [{"sku": "a1"}], "status": "new", "email": null}`, "", ""},
+		{`{"id": 1, "items": [{}], "email": "bob@example.com"}`, "", ""},
+		{`[]`, "", "should be object"},
+		{`{"id": 1}`, "", `property "items" is required`},
+		{`{"id": 1.5, "items": [{}]}`, "/id", "should be integer"},
+		{`{"id": 0, "items": [{}]}`, "/id", "should be >= 1"},
+		{`{"id": 1, "items": [{}], "status": "lost"}`, "/status", "should be one of the allowed values"},
+		{`{"id": 1, "items": [{}], "email": "bob"}`, "/email", "should match the pattern ^[^@]+@[^@]+$"},
+		{`{"id": 1, "items": []}`, "/items", "should have at least 1 items"},
+		{`{"id": 1, "items": [{}, {"sku": "long-sku"}]}`, "/items/1/sku", "should be at most 4 characters long"},
+		{`{"id": 1, "items": [{}], "a/b": 1}`, "/a~1b", "no value is allowed"},
+	}
+
+	for _, c := range cases {
+		var value interface{}
+		assert.NoError(t, json.Unmarshal([]byte(c.data), &value))
+
+		path, message, ok := compiled.validate("", value)
+		assert.Equal(t, c.message == "", ok, c.data)
+		assert.Equal(t, c.path, path, c.data)
+		assert.Equal(t, c.message, message, c.data)
+	}
+
+	_, err = compileSchema(json.RawMessage(`{"pattern": "("}`))
+	assert.Error(t, err)
+	_, err = compileSchema(json.RawMessage(`[]`))
+	assert.Error(t, err)
+}
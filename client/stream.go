@@ -0,0 +1,288 @@
+package client
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// errStreamIdle is returned when the stream connection is silent longer than the client timeout.
+var errStreamIdle = errors.New("no data is received from the stream within the timeout")
+
+// Stream receives the messages of the subscriber over the persistent connection (server-sent events).
+//
+// The broken connection is reestablished with the backoff of the retry policy of the client,
+// DefaultRetryPolicy if retries are disabled. The stream resumes from the last received message,
+// so the messages which were sent by the server but lost with the connection are delivered again.
+// The connection is considered broken if nothing, including the server heartbeats, is received
+// within the client timeout.
+type Stream struct {
+	client   *Client
+	endpoint string
+
+	messages chan Message
+	// err is set before the messages channel is closed.
+	err error
+	// lastID is the identifier of the last received message, it is sent in the `Last-Event-ID` header.
+	lastID int64
+}
+
+// Stream opens the stream of the messages of the subscriber, it should be subscribed to the topic.
+// The stream is stopped when the context is done or the error can not be fixed by reconnecting,
+// e.g. the subscription is removed. The error of the first connection is returned immediately.
+func (client *Client) Stream(ctx context.Context, topic, subscriber string) (*Stream, error) {
+	query := url.Values{}
+	query.Set("topic", topic)
+	query.Set("subscriber", subscriber)
+
+	stream := &Stream{
+		client:   client,
+		endpoint: client.endpoint("stream", query),
+		messages: make(chan Message),
+	}
+
+	var conn *streamConn
+	err := client.retry(ctx, true, func() error {
+		var err error
+		conn, err = stream.connect(ctx)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	go stream.run(ctx, conn)
+	return stream, nil
+}
+
+// Messages returns the received messages, the channel is closed when the stream is stopped.
+func (stream *Stream) Messages() <-chan Message {
+	return stream.messages
+}
+
+// Err returns the error which stopped the stream, nil if the context is done.
+// It should be called after the messages channel is closed.
+func (stream *Stream) Err() error {
+	return stream.err
+}
+
+func (stream *Stream) run(ctx context.Context, conn *streamConn) {
+	defer close(stream.messages)
+
+	policy := stream.client.retryPolicy
+	if policy.MaxAttempts <= 1 {
+		policy = DefaultRetryPolicy
+	}
+
+	for {
+		err := stream.read(ctx, conn)
+		conn = nil
+
+		for attempt := 1; conn == nil; attempt++ {
+			if ctx.Err() != nil {
+				return
+			}
+			if !reconnectable(err) {
+				stream.err = err
+				return
+			}
+
+			timer := time.NewTimer(policy.backoff(attempt, err))
+			select {
+			case <-ctx.Done():
+				timer.Stop()
+				return
+			case <-timer.C:
+			}
+
+			conn, err = stream.connect(ctx)
+		}
+	}
+}
+
+// streamConn is the open connection of the stream.
+type streamConn struct {
+	ctx    context.Context
+	cancel context.CancelFunc
+	body   io.ReadCloser
+	// watchdog breaks the connection if nothing is received within the timeout, nil without the timeout.
+	watchdog *time.Timer
+	timeout  time.Duration
+}
+
+// connect requests the stream, resuming it from the last received message.
+func (stream *Stream) connect(ctx context.Context) (*streamConn, error) {
+	connCtx, cancel := context.WithCancel(ctx)
+	conn := &streamConn{ctx: connCtx, cancel: cancel, timeout: stream.client.timeout}
+	if conn.timeout > 0 {
+		conn.watchdog = time.AfterFunc(conn.timeout, cancel)
+	}
+
+	header := http.Header{}
+	header.Set("Accept", "text/event-stream")
+	if stream.lastID > 0 {
+		header.Set("Last-Event-ID", strconv.FormatInt(stream.lastID, 10))
+	}
+
+	httpReq, err := stream.client.newRequest(connCtx, request{
+		method: http.MethodGet, endpoint: stream.endpoint, header: header,
+	})
+	if err != nil {
+		conn.close()
+		return nil, err
+	}
+
+	resp, err := stream.client.do(connCtx, httpReq)
+	if err != nil {
+		if connCtx.Err() != nil {
+			err = conn.error(ctx, err)
+		}
+		conn.close()
+		return nil, err
+	}
+
+	if resp.StatusCode != http.StatusOK {
+		err := responseError(resp)
+		closeBody(resp)
+		conn.close()
+		return nil, err
+	}
+
+	conn.body = resp.Body
+	return conn, nil
+}
+
+// read parses the events of the connection until it is broken.
+func (stream *Stream) read(ctx context.Context, conn *streamConn) error {
+	defer conn.close()
+
+	reader := bufio.NewReader(conn.body)
+	var event, id string
+	var data []string
+	for {
+		line, err := reader.ReadString('\n')
+		if err != nil {
+			return conn.error(ctx, err)
+		}
+		conn.alive()
+
+		line = strings.TrimRight(line, "\r\n")
+		if line == "" {
+			if err := stream.dispatch(ctx, conn, event, id, data); err != nil {
+				return err
+			}
+			event, id, data = "", "", nil
+			continue
+		}
+
+		if strings.HasPrefix(line, ":") {
+			// the comment is the heartbeat of the server.
+			continue
+		}
+
+		field, value := line, ""
+		if i := strings.IndexByte(line, ':'); i >= 0 {
+			field, value = line[:i], strings.TrimPrefix(line[i+1:], " ")
+		}
+		switch field {
+		case "event":
+			event = value
+		case "id":
+			id = value
+		case "data":
+			data = append(data, value)
+		}
+	}
+}
+
+// dispatch passes the received message to the channel, the error event stops the stream.
+func (stream *Stream) dispatch(ctx context.Context, conn *streamConn, event, id string, data []string) error {
+	if len(data) == 0 {
+		return nil
+	}
+	raw := []byte(strings.Join(data, "\n"))
+
+	switch event {
+	case "error":
+		apiErr := &APIError{}
+		if err := json.Unmarshal(raw, apiErr); err != nil {
+			return errors.Wrap(err, "unable to decode stream error")
+		}
+		return apiErr
+
+	case "", "message":
+		msg := Message{}
+		if err := json.Unmarshal(raw, &msg); err != nil {
+			return errors.Wrap(err, "unable to decode message")
+		}
+
+		// the connection is not broken while the message is waiting for the reader.
+		conn.pause()
+		select {
+		case stream.messages <- msg:
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+		conn.alive()
+
+		if lastID, err := strconv.ParseInt(id, 10, 64); err == nil {
+			stream.lastID = lastID
+		}
+	}
+	return nil
+}
+
+// reconnectable checks if the stream can be continued by the new connection.
+func reconnectable(err error) bool {
+	switch e := errors.Cause(err).(type) {
+	case *APIError:
+		return e.StatusCode == http.StatusTooManyRequests || e.StatusCode >= http.StatusInternalServerError
+	case *json.SyntaxError, *json.UnmarshalTypeError:
+		// the same message would be received again.
+		return false
+	}
+	return true
+}
+
+// alive postpones the watchdog after the data is received.
+func (conn *streamConn) alive() {
+	if conn.watchdog != nil {
+		conn.watchdog.Reset(conn.timeout)
+	}
+}
+
+// pause stops the watchdog until the next call of alive.
+func (conn *streamConn) pause() {
+	if conn.watchdog != nil {
+		conn.watchdog.Stop()
+	}
+}
+
+// error returns the reason of the broken connection.
+func (conn *streamConn) error(ctx context.Context, err error) error {
+	switch {
+	case ctx.Err() != nil:
+		return ctx.Err()
+	case conn.ctx.Err() != nil:
+		return errStreamIdle
+	}
+	return errors.Wrap(err, "stream is interrupted")
+}
+
+func (conn *streamConn) close() {
+	if conn.watchdog != nil {
+		conn.watchdog.Stop()
+	}
+	conn.cancel()
+	if conn.body != nil {
+		_ = conn.body.Close()
+	}
+}
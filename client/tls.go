@@ -0,0 +1,60 @@
+package client
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"io/ioutil"
+	"net/http"
+
+	"github.com/pkg/errors"
+)
+
+// WithTLSConfig sets the TLS configuration used for the `https://` server address.
+// The transport of the custom HTTP client is cloned, if it is not `*http.Transport` the default one is used.
+func WithTLSConfig(tlsConfig *tls.Config) Option {
+	return func(client *Client) {
+		client.tlsConfig = tlsConfig
+	}
+}
+
+// withTLS returns the copy of the HTTP client which transport uses the TLS configuration.
+func withTLS(httpClient *http.Client, tlsConfig *tls.Config) *http.Client {
+	transport, ok := httpClient.Transport.(*http.Transport)
+	if !ok {
+		transport = http.DefaultTransport.(*http.Transport)
+	}
+	transport = transport.Clone()
+	transport.TLSClientConfig = tlsConfig
+
+	result := *httpClient
+	result.Transport = transport
+	return &result
+}
+
+// LoadTLSConfig creates the TLS configuration which trusts the server certificates signed by the CA from `caFile`
+// and, if `certFile` and `keyFile` are set, presents the client certificate for mutual TLS.
+// The system CAs are used if `caFile` is empty.
+func LoadTLSConfig(caFile, certFile, keyFile string) (*tls.Config, error) {
+	tlsConfig := &tls.Config{MinVersion: tls.VersionTLS12}
+
+	if caFile != "" {
+		pem, err := ioutil.ReadFile(caFile)
+		if err != nil {
+			return nil, err
+		}
+
+		tlsConfig.RootCAs = x509.NewCertPool()
+		if !tlsConfig.RootCAs.AppendCertsFromPEM(pem) {
+			return nil, errors.New("no certificates found in " + caFile)
+		}
+	}
+
+	if certFile != "" || keyFile != "" {
+		cert, err := tls.LoadX509KeyPair(certFile, keyFile)
+		if err != nil {
+			return nil, err
+		}
+		tlsConfig.Certificates = []tls.Certificate{cert}
+	}
+	return tlsConfig, nil
+}
@@ -0,0 +1,338 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"runtime"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+var errNotSubscribed = errors.New("subscription is not found")
+
+// Config is the load of the benchmark.
+type Config struct {
+	Publishers  int `json:"publishers"`
+	Subscribers int `json:"subscribers"`
+	Topics      int `json:"topics"`
+	// Duration is the time of publishing.
+	Duration time.Duration `json:"duration_ns"`
+	// Rate is the limit of messages per second of each publisher, 0 - no limit.
+	Rate int `json:"rate"`
+	// Size is the size of the message data in bytes.
+	Size int `json:"size"`
+	// Wait is the max time of the long poll.
+	Wait time.Duration `json:"wait_ns"`
+	// Drain is the max time of receiving the rest of messages after the publishing is finished.
+	Drain time.Duration `json:"drain_ns"`
+	// Prefix is the prefix of the names of the topics and the subscribers.
+	Prefix string `json:"prefix"`
+}
+
+func (cfg Config) validate() error {
+	switch {
+	case cfg.Publishers <= 0:
+		return errors.New("publishers should be positive")
+	case cfg.Subscribers < 0:
+		return errors.New("subscribers should not be negative")
+	case cfg.Topics <= 0:
+		return errors.New("topics should be positive")
+	case cfg.Duration <= 0:
+		return errors.New("duration should be positive")
+	case cfg.Rate < 0:
+		return errors.New("rate should not be negative")
+	case cfg.Rate > int(time.Second):
+		return errors.New("rate should not exceed 1000000000 messages per second")
+	case cfg.Size < 0:
+		return errors.New("size should not be negative")
+	case cfg.Wait <= 0:
+		return errors.New("wait should be positive")
+	}
+	return nil
+}
+
+func (cfg Config) topic(i int) string {
+	return cfg.Prefix + "-" + strconv.Itoa(i%cfg.Topics)
+}
+
+// Report is the result of the benchmark.
+type Report struct {
+	Target  string       `json:"target"`
+	Config  Config       `json:"config"`
+	Publish PublishStats `json:"publish"`
+	Poll    PollStats    `json:"poll"`
+	Latency LatencyStats `json:"latency"`
+	Memory  MemoryStats  `json:"memory"`
+}
+
+// PublishStats is the result of the publishers.
+type PublishStats struct {
+	Messages  int64         `json:"messages"`
+	Errors    int64         `json:"errors"`
+	Elapsed   time.Duration `json:"elapsed_ns"`
+	PerSecond float64       `json:"per_second"`
+}
+
+// PollStats is the result of the subscribers.
+type PollStats struct {
+	Messages int64 `json:"messages"`
+	// Requests is the count of polls including the empty ones.
+	Requests int64 `json:"requests"`
+	Errors   int64 `json:"errors"`
+	// Lost is the count of the published messages not received by the subscribers until the drain timeout.
+	Lost      int64         `json:"lost"`
+	Elapsed   time.Duration `json:"elapsed_ns"`
+	PerSecond float64       `json:"per_second"`
+}
+
+// MemoryStats is the memory usage during the benchmark.
+type MemoryStats struct {
+	// HeapPeak is the max heap of the benchmark process, it includes the in-process broker.
+	HeapPeak uint64 `json:"heap_peak"`
+	// TotalAlloc is the bytes allocated by the benchmark process.
+	TotalAlloc uint64 `json:"total_alloc"`
+	GCCycles   uint32 `json:"gc_cycles"`
+	// BrokerPeak is the max count of bytes held by the messages in the broker.
+	BrokerPeak int64 `json:"broker_peak"`
+}
+
+// bench generates the load and collects the results.
+type bench struct {
+	cfg    Config
+	target target
+	// sampleInterval is the period of the memory sampling.
+	sampleInterval time.Duration
+}
+
+func (b *bench) run(ctx context.Context) (Report, error) {
+	report := Report{Config: b.cfg}
+	if err := b.cfg.validate(); err != nil {
+		return report, err
+	}
+
+	for i := 0; i < b.cfg.Subscribers; i++ {
+		if err := b.target.subscribe(ctx, b.cfg.topic(i), b.subscriber(i)); err != nil {
+			return report, errors.Wrap(err, "unable to subscribe")
+		}
+	}
+	defer func() {
+		for i := 0; i < b.cfg.Subscribers; i++ {
+			_ = b.target.unsubscribe(context.Background(), b.cfg.topic(i), b.subscriber(i))
+		}
+	}()
+
+	// subscribers of each topic, every published message is expected by all of them.
+	subscribers := make([]int64, b.cfg.Topics)
+	for i := 0; i < b.cfg.Subscribers; i++ {
+		subscribers[i%b.cfg.Topics]++
+	}
+
+	var before runtime.MemStats
+	runtime.ReadMemStats(&before)
+	memory, stopSampling := b.sampleMemory(ctx)
+
+	start := time.Now()
+	publishCtx, stopPublishing := context.WithTimeout(ctx, b.cfg.Duration)
+	defer stopPublishing()
+	pollCtx, stopPolling := context.WithCancel(ctx)
+	defer stopPolling()
+	published := make(chan struct{})
+
+	var expected int64
+	var publishWG sync.WaitGroup
+	for i := 0; i < b.cfg.Publishers; i++ {
+		publishWG.Add(1)
+		go func(i int) {
+			defer publishWG.Done()
+			count := b.publish(ctx, publishCtx, b.cfg.topic(i), &report.Publish)
+			atomic.AddInt64(&expected, count*subscribers[i%b.cfg.Topics])
+		}(i)
+	}
+
+	var pollWG sync.WaitGroup
+	var latenciesMu sync.Mutex
+	var latencies []time.Duration
+	var lastReceived int64
+	for i := 0; i < b.cfg.Subscribers; i++ {
+		pollWG.Add(1)
+		go func(i int) {
+			defer pollWG.Done()
+			received, last := b.poll(pollCtx, b.cfg.topic(i), b.subscriber(i), published, &report.Poll)
+
+			latenciesMu.Lock()
+			latencies = append(latencies, received...)
+			if last > lastReceived {
+				lastReceived = last
+			}
+			latenciesMu.Unlock()
+		}(i)
+	}
+
+	publishWG.Wait()
+	report.Publish.Elapsed = time.Since(start)
+	close(published)
+
+	drain := time.AfterFunc(b.cfg.Drain, stopPolling)
+	pollWG.Wait()
+	drain.Stop()
+
+	stopSampling()
+	var after runtime.MemStats
+	runtime.ReadMemStats(&after)
+
+	report.Publish.PerSecond = perSecond(report.Publish.Messages, report.Publish.Elapsed)
+	if lastReceived > 0 {
+		report.Poll.Elapsed = time.Unix(0, lastReceived).Sub(start)
+	}
+	report.Poll.PerSecond = perSecond(report.Poll.Messages, report.Poll.Elapsed)
+	report.Poll.Lost = expected - report.Poll.Messages
+	report.Latency = latencyStats(latencies)
+
+	report.Memory = <-memory
+	report.Memory.TotalAlloc = after.TotalAlloc - before.TotalAlloc
+	report.Memory.GCCycles = after.NumGC - before.NumGC
+	return report, ctx.Err()
+}
+
+func (b *bench) subscriber(i int) string {
+	return b.cfg.Prefix + "-sub-" + strconv.Itoa(i)
+}
+
+// publish sends the messages to the topic until `until` is done and returns their count.
+// The requests use the parent context, so the last message is not interrupted by the end of publishing.
+func (b *bench) publish(ctx, until context.Context, topic string, stats *PublishStats) int64 {
+	var tick <-chan time.Time
+	if b.cfg.Rate > 0 {
+		ticker := time.NewTicker(time.Second / time.Duration(b.cfg.Rate))
+		defer ticker.Stop()
+		tick = ticker.C
+	}
+
+	pad := strings.Repeat("x", b.cfg.Size)
+	var count int64
+	for until.Err() == nil {
+		if tick != nil {
+			select {
+			case <-tick:
+			case <-until.Done():
+				return count
+			}
+		}
+
+		err := b.target.publish(ctx, topic, newPayload(time.Now(), pad, b.cfg.Size))
+		switch {
+		case ctx.Err() != nil:
+			// the benchmark is interrupted, the message might be published.
+			return count
+		case err != nil:
+			atomic.AddInt64(&stats.Errors, 1)
+		default:
+			atomic.AddInt64(&stats.Messages, 1)
+			count++
+		}
+	}
+	return count
+}
+
+// poll receives the messages until the queue is empty after the publishing is finished or the context is done.
+// It returns the latencies and the unix nano time of the last received message.
+func (b *bench) poll(ctx context.Context, topic, subscriber string, published <-chan struct{},
+	stats *PollStats) ([]time.Duration, int64) {
+	var latencies []time.Duration
+	var last int64
+	for ctx.Err() == nil {
+		data, err := b.target.poll(ctx, topic, subscriber, b.cfg.Wait)
+		if ctx.Err() != nil {
+			break
+		}
+		atomic.AddInt64(&stats.Requests, 1)
+
+		if err != nil {
+			atomic.AddInt64(&stats.Errors, 1)
+			// the pause prevents the busy loop if the server is unavailable.
+			time.Sleep(10 * time.Millisecond)
+			continue
+		}
+
+		if data == nil {
+			select {
+			case <-published:
+				return latencies, last
+			default:
+				continue
+			}
+		}
+
+		now := time.Now()
+		payload := struct {
+			TS int64 `json:"ts"`
+		}{}
+		if err := json.Unmarshal(data, &payload); err != nil || payload.TS == 0 {
+			atomic.AddInt64(&stats.Errors, 1)
+			continue
+		}
+
+		atomic.AddInt64(&stats.Messages, 1)
+		latencies = append(latencies, now.Sub(time.Unix(0, payload.TS)))
+		last = now.UnixNano()
+	}
+	return latencies, last
+}
+
+// newPayload creates the message with the publishing time padded to about `size` bytes.
+func newPayload(now time.Time, pad string, size int) json.RawMessage {
+	data := make([]byte, 0, size+32)
+	data = append(data, `{"ts":`...)
+	data = strconv.AppendInt(data, now.UnixNano(), 10)
+	data = append(data, `,"pad":"`...)
+	if rest := size - len(data) - 2; rest > 0 {
+		data = append(data, pad[:rest]...)
+	}
+	return append(data, `"}`...)
+}
+
+// sampleMemory tracks the peak memory usage until it is stopped, then sends the result to the channel.
+func (b *bench) sampleMemory(ctx context.Context) (<-chan MemoryStats, func()) {
+	result := make(chan MemoryStats, 1)
+	stop := make(chan struct{})
+	stopped := make(chan struct{})
+
+	go func() {
+		defer close(stopped)
+		ticker := time.NewTicker(b.sampleInterval)
+		defer ticker.Stop()
+
+		stats := MemoryStats{}
+		sample := func() {
+			var mem runtime.MemStats
+			runtime.ReadMemStats(&mem)
+			if mem.HeapAlloc > stats.HeapPeak {
+				stats.HeapPeak = mem.HeapAlloc
+			}
+
+			if used, err := b.target.brokerBytes(ctx); err == nil && used > stats.BrokerPeak {
+				stats.BrokerPeak = used
+			}
+		}
+
+		for {
+			sample()
+			select {
+			case <-ticker.C:
+			case <-stop:
+				result <- stats
+				return
+			}
+		}
+	}()
+
+	return result, func() {
+		close(stop)
+		<-stopped
+	}
+}
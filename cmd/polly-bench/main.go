@@ -0,0 +1,160 @@
+// Command polly-bench generates the load of publishers and subscribers against the running Polly server
+// or the in-process broker and reports the throughput, the end-to-end latency and the memory usage.
+//
+// Usage:
+//
+//	polly-bench [-addr url] [-publishers n] [-subscribers n] [-topics n] [-duration d] [flags]
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"os/signal"
+	"syscall"
+	"text/tabwriter"
+	"time"
+
+	"github.com/sheb-gregor/polly-demo/client"
+	"github.com/sheb-gregor/polly-demo/mq"
+)
+
+func main() {
+	ctx, cancel := context.WithCancel(context.Background())
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, os.Interrupt, syscall.SIGTERM)
+	go func() {
+		<-signals
+		cancel()
+	}()
+
+	code := run(ctx, os.Args[1:], os.Stdout, os.Stderr)
+	cancel()
+	os.Exit(code)
+}
+
+// run executes the benchmark and returns the exit code.
+func run(ctx context.Context, args []string, stdout, stderr io.Writer) int {
+	flags := flag.NewFlagSet("polly-bench", flag.ContinueOnError)
+	flags.SetOutput(stderr)
+
+	cfg := Config{}
+	addr := flags.String("addr", "", "`url` of the running Polly server, the in-process broker is used if empty")
+	namespace := flags.String("namespace", os.Getenv("POLLY_NAMESPACE"), "namespace of the topics, env POLLY_NAMESPACE")
+	token := flags.String("token", os.Getenv("POLLY_TOKEN"), "bearer token of the client, env POLLY_TOKEN")
+	flags.IntVar(&cfg.Publishers, "publishers", 4, "count of publishers")
+	flags.IntVar(&cfg.Subscribers, "subscribers", 4, "count of subscribers")
+	flags.IntVar(&cfg.Topics, "topics", 1, "count of topics, the publishers and subscribers are spread over them")
+	flags.DurationVar(&cfg.Duration, "duration", 10*time.Second, "time of publishing")
+	flags.IntVar(&cfg.Rate, "rate", 0, "limit of messages per second of each publisher, 0 - no limit")
+	flags.IntVar(&cfg.Size, "size", 256, "size of the message in bytes")
+	flags.DurationVar(&cfg.Wait, "wait", time.Second, "max time of the long poll")
+	flags.DurationVar(&cfg.Drain, "drain", 10*time.Second,
+		"max time of receiving the rest of messages after the publishing is finished")
+	flags.StringVar(&cfg.Prefix, "prefix", "bench", "prefix of the topic and subscriber names")
+	asJSON := flags.Bool("json", false, "print the report as JSON")
+
+	if err := flags.Parse(args); err != nil {
+		return 2
+	}
+	if flags.NArg() > 0 {
+		fmt.Fprintln(stderr, "polly-bench: unexpected arguments", flags.Args())
+		return 2
+	}
+	if err := cfg.validate(); err != nil {
+		fmt.Fprintln(stderr, "polly-bench:", err)
+		return 2
+	}
+
+	b := &bench{cfg: cfg, target: brokerTarget{broker: mq.NewBroker()}, sampleInterval: 100 * time.Millisecond}
+	target := "in-process broker"
+	if *addr != "" {
+		// the connections are kept for each publisher and subscriber.
+		transport := http.DefaultTransport.(*http.Transport).Clone()
+		transport.MaxIdleConnsPerHost = cfg.Publishers + cfg.Subscribers + 1
+
+		opts := []client.Option{client.WithHTTPClient(&http.Client{Transport: transport})}
+		if *namespace != "" {
+			opts = append(opts, client.WithNamespace(*namespace))
+		}
+		if *token != "" {
+			opts = append(opts, client.WithToken(*token))
+		}
+
+		pollyClient, err := client.New(*addr, opts...)
+		if err != nil {
+			fmt.Fprintln(stderr, "polly-bench:", err)
+			return 2
+		}
+		b.target = clientTarget{client: pollyClient}
+		b.sampleInterval = 500 * time.Millisecond
+		target = *addr
+	}
+
+	report, err := b.run(ctx)
+	report.Target = target
+	if err != nil && ctx.Err() == nil {
+		fmt.Fprintln(stderr, "polly-bench:", err)
+		return 1
+	}
+
+	if *asJSON {
+		encoder := json.NewEncoder(stdout)
+		encoder.SetIndent("", "  ")
+		_ = encoder.Encode(report)
+	} else {
+		printReport(stdout, report)
+	}
+	return 0
+}
+
+func printReport(w io.Writer, report Report) {
+	cfg, latency, memory := report.Config, report.Latency, report.Memory
+
+	table := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+	fmt.Fprintf(table, "Target:\t%s\n", report.Target)
+	fmt.Fprintf(table, "Load:\t%d publishers, %d subscribers, %d topics, %d B messages, %s\n",
+		cfg.Publishers, cfg.Subscribers, cfg.Topics, cfg.Size, cfg.Duration)
+	fmt.Fprintf(table, "Publish:\t%d messages, %.1f msg/s, %d errors\n",
+		report.Publish.Messages, report.Publish.PerSecond, report.Publish.Errors)
+	fmt.Fprintf(table, "Poll:\t%d messages in %d requests, %.1f msg/s, %d errors, %d lost\n",
+		report.Poll.Messages, report.Poll.Requests, report.Poll.PerSecond, report.Poll.Errors, report.Poll.Lost)
+	fmt.Fprintf(table, "Latency:\tmin %s, mean %s, p50 %s, p90 %s, p99 %s, p99.9 %s, max %s\n",
+		round(latency.Min), round(latency.Mean), round(latency.P50), round(latency.P90),
+		round(latency.P99), round(latency.P999), round(latency.Max))
+	fmt.Fprintf(table, "Memory:\theap peak %s, allocated %s, %d GC cycles, broker peak %s\n",
+		formatBytes(int64(memory.HeapPeak)), formatBytes(int64(memory.TotalAlloc)), memory.GCCycles,
+		formatBytes(memory.BrokerPeak))
+	_ = table.Flush()
+}
+
+// round shortens the duration for the report.
+func round(d time.Duration) time.Duration {
+	switch {
+	case d >= time.Second:
+		return d.Round(time.Millisecond)
+	case d >= time.Millisecond:
+		return d.Round(time.Microsecond)
+	}
+	return d
+}
+
+func formatBytes(bytes int64) string {
+	const unit = 1024
+	if bytes < unit {
+		return fmt.Sprintf("%d B", bytes)
+	}
+
+	value, prefix := float64(bytes), ""
+	for _, p := range []string{"Ki", "Mi", "Gi", "Ti"} {
+		if value < unit {
+			break
+		}
+		value, prefix = value/unit, p
+	}
+	return fmt.Sprintf("%.1f %sB", value, prefix)
+}
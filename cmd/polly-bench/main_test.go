@@ -0,0 +1,73 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"testing"
+	"time"
+
+	"github.com/sheb-gregor/polly-demo/client/clienttest"
+	"github.com/sheb-gregor/polly-demo/mq"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestLatencyStats(t *testing.T) {
+	latencies := make([]time.Duration, 0, 1000)
+	for i := 1000; i > 0; i-- {
+		latencies = append(latencies, time.Duration(i)*time.Millisecond)
+	}
+
+	assert.Equal(t, LatencyStats{
+		Count: 1000,
+		Min:   time.Millisecond,
+		Mean:  500500 * time.Microsecond,
+		P50:   500 * time.Millisecond,
+		P90:   900 * time.Millisecond,
+		P99:   990 * time.Millisecond,
+		P999:  999 * time.Millisecond,
+		Max:   time.Second,
+	}, latencyStats(latencies))
+	assert.Equal(t, LatencyStats{}, latencyStats(nil))
+}
+
+func TestRun(t *testing.T) {
+	broker := mq.NewBroker()
+	testServer := clienttest.NewServer(broker)
+	defer testServer.Close()
+
+	for _, addr := range []string{"", testServer.URL} {
+		stdout, stderr := bytes.Buffer{}, bytes.Buffer{}
+		code := run(context.Background(), []string{
+			"-addr", addr, "-json", "-publishers", "3", "-subscribers", "4", "-topics", "2",
+			"-duration", "200ms", "-rate", "100", "-size", "64", "-wait", "50ms",
+		}, &stdout, &stderr)
+		assert.Equal(t, 0, code, stderr.String())
+
+		report := Report{}
+		assert.NoError(t, json.Unmarshal(stdout.Bytes(), &report))
+		assert.True(t, report.Publish.Messages > 0)
+		assert.Zero(t, report.Publish.Errors)
+		assert.Zero(t, report.Poll.Errors)
+		assert.Zero(t, report.Poll.Lost)
+		// the topic `bench-0` has 2 publishers and 2 subscribers, `bench-1` has 1 publisher and 2 subscribers.
+		assert.Equal(t, 2*report.Publish.Messages, report.Poll.Messages)
+		assert.Equal(t, int(report.Poll.Messages), report.Latency.Count)
+		assert.True(t, report.Memory.HeapPeak > 0)
+	}
+
+	// the subscriptions are removed after the benchmark.
+	assert.Empty(t, broker.Topics())
+
+	stderr := bytes.Buffer{}
+	assert.Equal(t, 2, run(context.Background(), []string{"-publishers", "0"}, &bytes.Buffer{}, &stderr))
+	assert.Contains(t, stderr.String(), "publishers should be positive")
+
+	stderr.Reset()
+	assert.Equal(t, 2, run(context.Background(), []string{"-size", "-1"}, &bytes.Buffer{}, &stderr))
+	assert.Contains(t, stderr.String(), "size should not be negative")
+
+	stderr.Reset()
+	assert.Equal(t, 2, run(context.Background(), []string{"-rate", "2000000000"}, &bytes.Buffer{}, &stderr))
+	assert.Contains(t, stderr.String(), "rate should not exceed")
+}
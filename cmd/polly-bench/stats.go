@@ -0,0 +1,61 @@
+package main
+
+import (
+	"math"
+	"sort"
+	"time"
+)
+
+// LatencyStats is the distribution of the end-to-end latency, the time from
+// the publishing of the message until it is received by the subscriber.
+type LatencyStats struct {
+	Count int           `json:"count"`
+	Min   time.Duration `json:"min_ns"`
+	Mean  time.Duration `json:"mean_ns"`
+	P50   time.Duration `json:"p50_ns"`
+	P90   time.Duration `json:"p90_ns"`
+	P99   time.Duration `json:"p99_ns"`
+	P999  time.Duration `json:"p999_ns"`
+	Max   time.Duration `json:"max_ns"`
+}
+
+// latencyStats sorts the latencies and returns their distribution.
+func latencyStats(latencies []time.Duration) LatencyStats {
+	if len(latencies) == 0 {
+		return LatencyStats{}
+	}
+	sort.Slice(latencies, func(i, j int) bool { return latencies[i] < latencies[j] })
+
+	var sum time.Duration
+	for _, latency := range latencies {
+		sum += latency
+	}
+
+	return LatencyStats{
+		Count: len(latencies),
+		Min:   latencies[0],
+		Mean:  sum / time.Duration(len(latencies)),
+		P50:   percentile(latencies, 0.5),
+		P90:   percentile(latencies, 0.9),
+		P99:   percentile(latencies, 0.99),
+		P999:  percentile(latencies, 0.999),
+		Max:   latencies[len(latencies)-1],
+	}
+}
+
+// percentile returns the nearest-rank percentile of the sorted values.
+func percentile(sorted []time.Duration, p float64) time.Duration {
+	rank := int(math.Ceil(p*float64(len(sorted)))) - 1
+	if rank < 0 {
+		rank = 0
+	}
+	return sorted[rank]
+}
+
+// perSecond returns the rate of the count within the duration.
+func perSecond(count int64, d time.Duration) float64 {
+	if d <= 0 {
+		return 0
+	}
+	return float64(count) / d.Seconds()
+}
@@ -0,0 +1,90 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"time"
+
+	"github.com/sheb-gregor/polly-demo/client"
+	"github.com/sheb-gregor/polly-demo/mq"
+)
+
+// target is the broker under the load.
+type target interface {
+	subscribe(ctx context.Context, topic, subscriber string) error
+	unsubscribe(ctx context.Context, topic, subscriber string) error
+	publish(ctx context.Context, topic string, data json.RawMessage) error
+	// poll returns the next message of the subscriber, waiting for it up to `wait`, nil if there is none.
+	poll(ctx context.Context, topic, subscriber string, wait time.Duration) (json.RawMessage, error)
+	// brokerBytes returns the bytes held by the messages of the topics.
+	brokerBytes(ctx context.Context) (int64, error)
+}
+
+// brokerTarget calls the in-process broker, it measures the broker without the HTTP overhead.
+type brokerTarget struct {
+	broker *mq.Broker
+}
+
+func (t brokerTarget) subscribe(_ context.Context, topic, subscriber string) error {
+	t.broker.Subscribe(topic, subscriber)
+	return nil
+}
+
+func (t brokerTarget) unsubscribe(_ context.Context, topic, subscriber string) error {
+	t.broker.Unsubscribe(topic, subscriber)
+	return nil
+}
+
+func (t brokerTarget) publish(_ context.Context, topic string, data json.RawMessage) error {
+	return t.broker.HandleNewMessage(topic, data)
+}
+
+func (t brokerTarget) poll(ctx context.Context, topic, subscriber string, wait time.Duration) (json.RawMessage, error) {
+	ctx, cancel := context.WithTimeout(ctx, wait)
+	defer cancel()
+
+	delivery, subscribed := t.broker.FetchWait(topic, subscriber, ctx.Done())
+	if !subscribed {
+		return nil, errNotSubscribed
+	}
+	return delivery.Data, nil
+}
+
+func (t brokerTarget) brokerBytes(context.Context) (int64, error) {
+	return t.broker.MemoryUsage().Used, nil
+}
+
+// clientTarget calls the running server with the HTTP API.
+type clientTarget struct {
+	client *client.Client
+}
+
+func (t clientTarget) subscribe(ctx context.Context, topic, subscriber string) error {
+	return t.client.Subscribe(ctx, topic, subscriber)
+}
+
+func (t clientTarget) unsubscribe(ctx context.Context, topic, subscriber string) error {
+	return t.client.Unsubscribe(ctx, topic, subscriber)
+}
+
+func (t clientTarget) publish(ctx context.Context, topic string, data json.RawMessage) error {
+	return t.client.Publish(ctx, topic, data)
+}
+
+func (t clientTarget) poll(ctx context.Context, topic, subscriber string, wait time.Duration) (json.RawMessage, error) {
+	msg, err := t.client.FetchWait(ctx, topic, subscriber, wait)
+	return msg.Data, err
+}
+
+func (t clientTarget) brokerBytes(ctx context.Context) (int64, error) {
+	topics, err := t.client.Topics(ctx)
+	if err != nil {
+		return 0, err
+	}
+
+	var bytes int64
+	for _, info := range topics {
+		bytes += info.Bytes
+	}
+	return bytes, nil
+}
@@ -0,0 +1,247 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"io"
+	"io/ioutil"
+	"os"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/sheb-gregor/polly-demo/client"
+)
+
+// maxLineBytes is the limit of the message read from the line of the input.
+const maxLineBytes = 16 << 20
+
+func publishCmd(ctx context.Context, cli *cli, args []string) error {
+	flags := cli.flagSet()
+	file := flags.String("file", "", "read the message from the `path`, - for stdin")
+	lines := flags.Bool("lines", false, "publish each line of the file as a separate message")
+	text := flags.Bool("text", false, "publish the data as JSON strings")
+	if err := parseArgs(flags, args, 1, -1); err != nil {
+		return err
+	}
+
+	topic, data := flags.Arg(0), flags.Args()[1:]
+	if len(data) > 0 && *file != "" {
+		return newUsageError("the data arguments and -file can not be used together")
+	}
+
+	count := 0
+	publish := func(raw []byte) error {
+		if len(bytes.TrimSpace(raw)) == 0 {
+			return nil
+		}
+
+		msg, err := messageData(raw, *text)
+		if err != nil {
+			return errors.Wrapf(err, "message %d", count+1)
+		}
+		if err := cli.client.Publish(ctx, topic, msg); err != nil {
+			return errors.Wrapf(err, "message %d", count+1)
+		}
+		count++
+		return nil
+	}
+
+	var err error
+	switch {
+	case len(data) > 0:
+		for _, value := range data {
+			if err = publish([]byte(value)); err != nil {
+				break
+			}
+		}
+	case *file != "" && !*lines:
+		var raw []byte
+		if raw, err = readFile(cli, *file); err == nil {
+			err = publish(raw)
+		}
+	case *file != "" && *file != "-":
+		var f *os.File
+		if f, err = os.Open(*file); err == nil {
+			err = readLines(f, publish)
+			_ = f.Close()
+		}
+	default:
+		// the messages are published as they are read, so the input may be a pipe of the running process.
+		err = readLines(cli.stdin, publish)
+	}
+
+	cli.out.result(map[string]interface{}{"topic": topic, "published": count},
+		"published %d message(s) to %s", count, topic)
+	return err
+}
+
+// messageData returns the JSON of the message, the text is encoded as the JSON string.
+func messageData(raw []byte, text bool) (json.RawMessage, error) {
+	if text {
+		return json.Marshal(string(raw))
+	}
+
+	raw = bytes.TrimSpace(raw)
+	if !json.Valid(raw) {
+		return nil, errors.New("data is not valid JSON, use -text to publish it as a string")
+	}
+	return raw, nil
+}
+
+func readFile(cli *cli, path string) ([]byte, error) {
+	if path == "-" {
+		return ioutil.ReadAll(cli.stdin)
+	}
+	return ioutil.ReadFile(path)
+}
+
+// readLines calls the handler with each line of the input.
+func readLines(input io.Reader, handle func(line []byte) error) error {
+	scanner := bufio.NewScanner(input)
+	scanner.Buffer(make([]byte, 64<<10), maxLineBytes)
+	for scanner.Scan() {
+		if err := handle(scanner.Bytes()); err != nil {
+			return err
+		}
+	}
+	return scanner.Err()
+}
+
+func subscribeCmd(ctx context.Context, cli *cli, args []string) error {
+	flags := cli.flagSet()
+	maxPending := flags.Int("max-pending", 0, "limit of unread messages, 0 - the server default")
+	overflow := flags.String("overflow", "", "`policy` applied when the queue is full: "+
+		"drop_oldest, drop_newest, unsubscribe or reject")
+	if err := parseArgs(flags, args, 2, 2); err != nil {
+		return err
+	}
+
+	topic, subscriber := flags.Arg(0), flags.Arg(1)
+	opts := client.SubscribeOptions{MaxPending: *maxPending, Overflow: *overflow}
+	if err := cli.client.SubscribeWithOptions(ctx, topic, subscriber, opts); err != nil {
+		return err
+	}
+
+	cli.out.result(map[string]interface{}{"topic": topic, "subscriber": subscriber, "subscribed": true},
+		"subscribed %s to %s", subscriber, topic)
+	return nil
+}
+
+func unsubscribeCmd(ctx context.Context, cli *cli, args []string) error {
+	flags := cli.flagSet()
+	if err := parseArgs(flags, args, 2, 2); err != nil {
+		return err
+	}
+
+	topic, subscriber := flags.Arg(0), flags.Arg(1)
+	if err := cli.client.Unsubscribe(ctx, topic, subscriber); err != nil {
+		return err
+	}
+
+	cli.out.result(map[string]interface{}{"topic": topic, "subscriber": subscriber, "subscribed": false},
+		"unsubscribed %s from %s", subscriber, topic)
+	return nil
+}
+
+func pollCmd(ctx context.Context, cli *cli, args []string) error {
+	flags := cli.flagSet()
+	wait := flags.Duration("wait", 0, "max time of waiting for a new message, limited by the server")
+	count := flags.Int("n", 1, "max count of messages, 0 - all unread messages")
+	if err := parseArgs(flags, args, 2, 2); err != nil {
+		return err
+	}
+
+	received, err := drain(ctx, cli, flags.Arg(0), flags.Arg(1), *wait, *count)
+	if err == nil && received == 0 {
+		cli.out.empty()
+	}
+	return err
+}
+
+func tailCmd(ctx context.Context, cli *cli, args []string) error {
+	flags := cli.flagSet()
+	follow := flags.Bool("f", false, "keep receiving the new messages until interrupted")
+	subscribe := flags.Bool("subscribe", false, "subscribe to the topic before receiving")
+	unsubscribe := flags.Bool("unsubscribe", false, "remove the subscription on exit")
+	if err := parseArgs(flags, args, 2, 2); err != nil {
+		return err
+	}
+
+	topic, subscriber := flags.Arg(0), flags.Arg(1)
+	if *subscribe {
+		if err := cli.client.Subscribe(ctx, topic, subscriber); err != nil {
+			return err
+		}
+	}
+	if *unsubscribe {
+		defer func() {
+			if err := cli.client.Unsubscribe(context.Background(), topic, subscriber); err != nil {
+				cli.out.warning("unable to unsubscribe: %s", err)
+			}
+		}()
+	}
+
+	if !*follow {
+		_, err := drain(ctx, cli, topic, subscriber, 0, 0)
+		return err
+	}
+
+	stream, err := cli.client.Stream(ctx, topic, subscriber)
+	if err != nil {
+		if ctx.Err() != nil {
+			return nil
+		}
+		return err
+	}
+	for msg := range stream.Messages() {
+		cli.out.message(msg)
+	}
+	return stream.Err()
+}
+
+// drain prints up to `count` messages of the subscriber, all unread messages if it is 0.
+func drain(ctx context.Context, cli *cli, topic, subscriber string, wait time.Duration, count int) (int, error) {
+	received := 0
+	for count <= 0 || received < count {
+		msg, err := cli.client.FetchWait(ctx, topic, subscriber, wait)
+		if err != nil {
+			return received, err
+		}
+		if msg.Data == nil {
+			break
+		}
+
+		cli.out.message(msg)
+		received++
+	}
+	return received, nil
+}
+
+func topicsCmd(ctx context.Context, cli *cli, args []string) error {
+	flags := cli.flagSet()
+	if err := parseArgs(flags, args, 0, 1); err != nil {
+		return err
+	}
+
+	if flags.NArg() == 0 {
+		topics, err := cli.client.Topics(ctx)
+		if err != nil {
+			return err
+		}
+		cli.out.topics(topics)
+		return nil
+	}
+
+	info, err := cli.client.Topic(ctx, flags.Arg(0))
+	if err != nil {
+		return err
+	}
+	subscribers, err := cli.client.Subscribers(ctx, flags.Arg(0))
+	if err != nil {
+		return err
+	}
+	cli.out.topic(info, subscribers)
+	return nil
+}
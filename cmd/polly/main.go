@@ -0,0 +1,243 @@
+// Command polly is the command-line client of the Polly server.
+//
+// Usage:
+//
+//	polly [flags] <command> [command flags] [arguments]
+//
+// Run `polly -h` for the list of the commands and `polly <command> -h` for the command flags.
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"os/signal"
+	"syscall"
+
+	"github.com/pkg/errors"
+	"github.com/sheb-gregor/polly-demo/client"
+)
+
+// Exit codes of the command.
+const (
+	exitOK    = 0
+	exitError = 1
+	exitUsage = 2
+)
+
+// usageError is the invalid command line, it is reported with the usage of the command.
+type usageError struct {
+	message string
+}
+
+func (e *usageError) Error() string {
+	return e.message
+}
+
+func newUsageError(format string, args ...interface{}) error {
+	return &usageError{message: fmt.Sprintf(format, args...)}
+}
+
+// command is the subcommand of the CLI.
+type command struct {
+	name string
+	// args is the synopsis of the command arguments.
+	args    string
+	summary string
+	run     func(ctx context.Context, cli *cli, args []string) error
+}
+
+var commands = []command{
+	{
+		name: "publish", args: "[-file path [-lines]] [-text] <topic> [data ...]",
+		summary: "publish the messages from the arguments, the file or the lines of stdin",
+		run:     publishCmd,
+	},
+	{
+		name: "subscribe", args: "[-max-pending n] [-overflow policy] <topic> <subscriber>",
+		summary: "subscribe to the topic or update the subscription options",
+		run:     subscribeCmd,
+	},
+	{
+		name: "unsubscribe", args: "<topic> <subscriber>",
+		summary: "remove the subscription",
+		run:     unsubscribeCmd,
+	},
+	{
+		name: "poll", args: "[-wait duration] [-n count] <topic> <subscriber>",
+		summary: "receive the next unread messages of the subscriber",
+		run:     pollCmd,
+	},
+	{
+		name: "tail", args: "[-f] [-subscribe] [-unsubscribe] <topic> <subscriber>",
+		summary: "print the unread messages, with -f keep receiving the new ones until interrupted",
+		run:     tailCmd,
+	},
+	{
+		name: "topics", args: "[topic]",
+		summary: "list the topics or show the topic and its subscribers",
+		run:     topicsCmd,
+	},
+}
+
+// cli is the state shared by the commands.
+type cli struct {
+	cmd    *command
+	client *client.Client
+	out    *printer
+	stdin  io.Reader
+	stderr io.Writer
+}
+
+func main() {
+	ctx, cancel := context.WithCancel(context.Background())
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, os.Interrupt, syscall.SIGTERM)
+	go func() {
+		<-signals
+		cancel()
+	}()
+
+	code := run(ctx, os.Args[1:], os.Stdin, os.Stdout, os.Stderr)
+	cancel()
+	os.Exit(code)
+}
+
+// run executes the command line and returns the exit code.
+func run(ctx context.Context, args []string, stdin io.Reader, stdout, stderr io.Writer) int {
+	flags := flag.NewFlagSet("polly", flag.ContinueOnError)
+	flags.SetOutput(stderr)
+	flags.Usage = func() {
+		fmt.Fprintln(stderr, "Usage: polly [flags] <command> [command flags] [arguments]")
+		fmt.Fprintln(stderr, "\nCommands:")
+		for _, cmd := range commands {
+			fmt.Fprintf(stderr, "  %-12s %s\n", cmd.name, cmd.summary)
+		}
+		fmt.Fprintln(stderr, "\nFlags:")
+		flags.PrintDefaults()
+	}
+
+	addr := flags.String("addr", envOr("POLLY_ADDR", "http://localhost:3000"),
+		"`url` of the Polly server, env POLLY_ADDR")
+	namespace := flags.String("namespace", os.Getenv("POLLY_NAMESPACE"), "namespace of the topics, env POLLY_NAMESPACE")
+	token := flags.String("token", os.Getenv("POLLY_TOKEN"), "bearer token of the client, env POLLY_TOKEN")
+	hmacKey := flags.String("hmac-key", os.Getenv("POLLY_HMAC_KEY"),
+		"client `name` of the HMAC signature, env POLLY_HMAC_KEY")
+	hmacSecret := flags.String("hmac-secret", os.Getenv("POLLY_HMAC_SECRET"),
+		"secret of the HMAC signature, env POLLY_HMAC_SECRET")
+	caFile := flags.String("ca", "", "`file` with the CA certificates of the server, the system CAs by default")
+	certFile := flags.String("cert", "", "`file` with the client certificate for mutual TLS")
+	keyFile := flags.String("cert-key", "", "`file` with the key of the client certificate")
+	timeout := flags.Duration("timeout", client.DefaultTimeout, "limit of each request duration, 0 - no limit")
+	retries := flags.Int("retries", 0, "count of retries of the failed requests")
+	output := flags.String("output", "pretty", "output `format`: pretty or json")
+
+	if err := flags.Parse(args); err != nil {
+		return exitUsage
+	}
+
+	if flags.NArg() == 0 {
+		flags.Usage()
+		return exitUsage
+	}
+
+	var cmd *command
+	for i := range commands {
+		if commands[i].name == flags.Arg(0) {
+			cmd = &commands[i]
+		}
+	}
+	if cmd == nil {
+		fmt.Fprintf(stderr, "polly: unknown command %q\n", flags.Arg(0))
+		flags.Usage()
+		return exitUsage
+	}
+
+	out, err := newPrinter(stdout, stderr, *output)
+	if err != nil {
+		fmt.Fprintln(stderr, "polly:", err)
+		return exitUsage
+	}
+
+	opts := []client.Option{client.WithTimeout(*timeout), client.WithUserAgent("polly-cli")}
+	if *namespace != "" {
+		opts = append(opts, client.WithNamespace(*namespace))
+	}
+	if *token != "" {
+		opts = append(opts, client.WithToken(*token))
+	}
+	if *hmacKey != "" || *hmacSecret != "" {
+		opts = append(opts, client.WithHMAC(*hmacKey, *hmacSecret))
+	}
+	if *caFile != "" || *certFile != "" || *keyFile != "" {
+		tlsConfig, err := client.LoadTLSConfig(*caFile, *certFile, *keyFile)
+		if err != nil {
+			fmt.Fprintln(stderr, "polly: unable to load TLS configuration:", err)
+			return exitUsage
+		}
+		opts = append(opts, client.WithTLSConfig(tlsConfig))
+	}
+	if *retries > 0 {
+		policy := client.DefaultRetryPolicy
+		policy.MaxAttempts = *retries + 1
+		opts = append(opts, client.WithRetry(policy))
+	}
+
+	pollyClient, err := client.New(*addr, opts...)
+	if err != nil {
+		fmt.Fprintln(stderr, "polly:", err)
+		return exitUsage
+	}
+
+	err = cmd.run(ctx, &cli{cmd: cmd, client: pollyClient, out: out, stdin: stdin, stderr: stderr}, flags.Args()[1:])
+	switch e := errors.Cause(err).(type) {
+	case nil:
+		return exitOK
+	case *usageError:
+		fmt.Fprintf(stderr, "polly %s: %s\n", cmd.name, e.message)
+		fmt.Fprintf(stderr, "Usage: polly %s %s\n", cmd.name, cmd.args)
+		return exitUsage
+	}
+
+	if err == flag.ErrHelp {
+		return exitUsage
+	}
+	fmt.Fprintf(stderr, "polly %s: %s\n", cmd.name, err)
+	return exitError
+}
+
+// flagSet creates the flags of the command, they are parsed by parseArgs.
+func (cli *cli) flagSet() *flag.FlagSet {
+	flags := flag.NewFlagSet(cli.cmd.name, flag.ContinueOnError)
+	flags.SetOutput(cli.stderr)
+	flags.Usage = func() {
+		fmt.Fprintf(cli.stderr, "Usage: polly %s %s\n\n%s.\n", cli.cmd.name, cli.cmd.args, cli.cmd.summary)
+		flags.PrintDefaults()
+	}
+	return flags
+}
+
+// parseArgs parses the command flags and checks the count of the positional arguments.
+func parseArgs(flags *flag.FlagSet, args []string, min, max int) error {
+	if err := flags.Parse(args); err != nil {
+		// the error and the usage are printed by the flag set.
+		return flag.ErrHelp
+	}
+
+	switch {
+	case flags.NArg() < min:
+		return newUsageError("not enough arguments")
+	case max >= 0 && flags.NArg() > max:
+		return newUsageError("too many arguments")
+	}
+	return nil
+}
+
+func envOr(key, fallback string) string {
+	if value := os.Getenv(key); value != "" {
+		return value
+	}
+	return fallback
+}
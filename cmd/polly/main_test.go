@@ -0,0 +1,127 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/sheb-gregor/polly-demo/client/clienttest"
+	"github.com/sheb-gregor/polly-demo/mq"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRun(t *testing.T) {
+	broker := mq.NewBroker()
+	testServer := clienttest.NewServer(broker)
+	defer testServer.Close()
+
+	polly := func(stdin string, args ...string) (int, string, string) {
+		stdout, stderr := bytes.Buffer{}, bytes.Buffer{}
+		args = append([]string{"-addr", testServer.URL}, args...)
+		code := run(context.Background(), args, strings.NewReader(stdin), &stdout, &stderr)
+		return code, stdout.String(), stderr.String()
+	}
+
+	code, out, _ := polly("", "subscribe", "orders", "bob")
+	assert.Equal(t, exitOK, code)
+	assert.Equal(t, "subscribed bob to orders\n", out)
+
+	code, out, _ = polly("", "-output", "json", "publish", "orders", `{"id":1}`, `{"id":2}`)
+	assert.Equal(t, exitOK, code)
+	assert.JSONEq(t, `{"topic":"orders","published":2}`, out)
+
+	code, _, _ = polly("{\"id\":3}\n\n\"four\"\n", "publish", "orders")
+	assert.Equal(t, exitOK, code)
+
+	code, out, _ = polly("", "publish", "-text", "orders", "plain text")
+	assert.Equal(t, exitOK, code)
+	assert.Equal(t, "published 1 message(s) to orders\n", out)
+
+	code, _, errOut := polly("", "publish", "orders", "not json")
+	assert.Equal(t, exitError, code)
+	assert.Contains(t, errOut, "message 1: data is not valid JSON")
+
+	code, out, _ = polly("", "poll", "orders", "bob")
+	assert.Equal(t, exitOK, code)
+	assert.Equal(t, "#1 orders\n{\n  \"id\": 1\n}\n", out)
+
+	code, out, _ = polly("", "-output", "json", "poll", "-n", "0", "orders", "bob")
+	assert.Equal(t, exitOK, code)
+	assert.Equal(t, `{"id":2,"topic":"orders","data":{"id":2}}
+{"id":3,"topic":"orders","data":{"id":3}}
+{"id":4,"topic":"orders","data":"four"}
+{"id":5,"topic":"orders","data":"plain text"}
+`, out)
+
+	code, out, _ = polly("", "tail", "orders", "bob")
+	assert.Equal(t, exitOK, code)
+	assert.Equal(t, "", out)
+
+	code, out, _ = polly("", "-output", "json", "topics")
+	assert.Equal(t, exitOK, code)
+	var topics []map[string]interface{}
+	assert.NoError(t, json.Unmarshal([]byte(out), &topics))
+	assert.Len(t, topics, 1)
+	assert.Equal(t, "orders", topics[0]["name"])
+
+	code, out, _ = polly("", "topics", "orders")
+	assert.Equal(t, exitOK, code)
+	assert.Contains(t, out, "Name:            orders\n")
+	assert.Contains(t, out, "SUBSCRIBER")
+
+	code, _, errOut = polly("", "poll", "orders", "alice")
+	assert.Equal(t, exitError, code)
+	assert.Contains(t, errOut, "not_subscribed")
+
+	code, _, errOut = polly("", "poll", "orders")
+	assert.Equal(t, exitUsage, code)
+	assert.Contains(t, errOut, "Usage: polly poll")
+
+	code, _, _ = polly("", "unknown")
+	assert.Equal(t, exitUsage, code)
+
+	// tail -f receives the messages until the context is canceled.
+	ctx, cancel := context.WithCancel(context.Background())
+	stdout := &syncBuffer{}
+	finished := make(chan int)
+	go func() {
+		finished <- run(ctx, []string{"-addr", testServer.URL, "-output", "json", "tail", "-f", "orders", "bob"},
+			strings.NewReader(""), stdout, &bytes.Buffer{})
+	}()
+
+	time.Sleep(50 * time.Millisecond)
+	code, _, _ = polly("", "publish", "orders", `"live"`)
+	assert.Equal(t, exitOK, code)
+	assert.Eventually(t, func() bool {
+		return stdout.String() == "{\"id\":6,\"topic\":\"orders\",\"data\":\"live\"}\n"
+	}, time.Second, 10*time.Millisecond)
+
+	cancel()
+	assert.Equal(t, exitOK, <-finished)
+
+	code, out, _ = polly("", "unsubscribe", "orders", "bob")
+	assert.Equal(t, exitOK, code)
+	assert.Equal(t, "unsubscribed bob from orders\n", out)
+}
+
+// syncBuffer is the output written by the running command and read by the test.
+type syncBuffer struct {
+	mu  sync.Mutex
+	buf bytes.Buffer
+}
+
+func (b *syncBuffer) Write(p []byte) (int, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.buf.Write(p)
+}
+
+func (b *syncBuffer) String() string {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.buf.String()
+}
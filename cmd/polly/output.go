@@ -0,0 +1,146 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"text/tabwriter"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/sheb-gregor/polly-demo/client"
+)
+
+// printer writes the results of the commands in the human-readable form
+// or as JSON, one value per line.
+type printer struct {
+	out    io.Writer
+	stderr io.Writer
+	json   bool
+}
+
+func newPrinter(out, stderr io.Writer, format string) (*printer, error) {
+	switch format {
+	case "pretty":
+		return &printer{out: out, stderr: stderr}, nil
+	case "json":
+		return &printer{out: out, stderr: stderr, json: true}, nil
+	}
+	return nil, errors.New("unknown output format " + format + ", expected pretty or json")
+}
+
+// result prints the value as JSON or the formatted text.
+func (p *printer) result(value interface{}, format string, args ...interface{}) {
+	if p.json {
+		p.encode(value)
+		return
+	}
+	fmt.Fprintf(p.out, format+"\n", args...)
+}
+
+// empty reports that there are no messages, nothing is printed as JSON.
+func (p *printer) empty() {
+	if !p.json {
+		fmt.Fprintln(p.out, "no messages")
+	}
+}
+
+// warning reports the error which does not fail the command.
+func (p *printer) warning(format string, args ...interface{}) {
+	fmt.Fprintf(p.stderr, "polly: "+format+"\n", args...)
+}
+
+func (p *printer) message(msg client.Message) {
+	if p.json {
+		p.encode(msg)
+		return
+	}
+
+	fmt.Fprintf(p.out, "#%d %s", msg.ID, msg.Topic)
+	if msg.Dropped > 0 {
+		fmt.Fprintf(p.out, " (%d dropped)", msg.Dropped)
+	}
+
+	data := bytes.Buffer{}
+	if err := json.Indent(&data, msg.Data, "", "  "); err != nil {
+		data.Reset()
+		data.Write(msg.Data)
+	}
+	fmt.Fprintf(p.out, "\n%s\n", data.Bytes())
+}
+
+func (p *printer) topics(topics []client.TopicInfo) {
+	if p.json {
+		if topics == nil {
+			topics = []client.TopicInfo{}
+		}
+		p.encode(topics)
+		return
+	}
+
+	table := tabwriter.NewWriter(p.out, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(table, "NAME\tSUBSCRIBERS\tMESSAGES\tBYTES\tLAST ID\tOLDEST PENDING")
+	for _, info := range topics {
+		fmt.Fprintf(table, "%s\t%d\t%d\t%d\t%d\t%s\n", info.Name, info.Subscribers, info.Messages,
+			info.Bytes, info.LastID, age(info.OldestPendingAge))
+	}
+	_ = table.Flush()
+}
+
+func (p *printer) topic(info client.TopicInfo, subscribers []client.SubscriberInfo) {
+	if p.json {
+		if subscribers == nil {
+			subscribers = []client.SubscriberInfo{}
+		}
+		p.encode(map[string]interface{}{"topic": info, "subscribers": subscribers})
+		return
+	}
+
+	table := tabwriter.NewWriter(p.out, 0, 0, 2, ' ', 0)
+	fmt.Fprintf(table, "Name:\t%s\n", info.Name)
+	fmt.Fprintf(table, "Subscribers:\t%d\n", info.Subscribers)
+	fmt.Fprintf(table, "Messages:\t%d\n", info.Messages)
+	fmt.Fprintf(table, "Bytes:\t%d\n", info.Bytes)
+	fmt.Fprintf(table, "Last ID:\t%d\n", info.LastID)
+	fmt.Fprintf(table, "Oldest pending:\t%s\n", age(info.OldestPendingAge))
+	_ = table.Flush()
+
+	if len(subscribers) == 0 {
+		return
+	}
+
+	fmt.Fprintln(p.out)
+	table = tabwriter.NewWriter(p.out, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(table, "SUBSCRIBER\tPENDING\tDROPPED\tMAX PENDING\tOVERFLOW\tOWNER\tLAST POLL")
+	for _, sub := range subscribers {
+		lastPoll := "-"
+		if !sub.LastPoll.IsZero() {
+			lastPoll = sub.LastPoll.Local().Format(time.RFC3339)
+		}
+		fmt.Fprintf(table, "%s\t%d\t%d\t%d\t%s\t%s\t%s\n", sub.Name, sub.Pending, sub.Dropped,
+			sub.MaxPending, dash(sub.Overflow), dash(sub.Owner), lastPoll)
+	}
+	_ = table.Flush()
+}
+
+func (p *printer) encode(value interface{}) {
+	if err := json.NewEncoder(p.out).Encode(value); err != nil {
+		p.warning("unable to encode output: %s", err)
+	}
+}
+
+// age formats the duration for the table, `-` if it is not set.
+func age(d time.Duration) string {
+	if d <= 0 {
+		return "-"
+	}
+	return d.Round(time.Millisecond).String()
+}
+
+func dash(value string) string {
+	if value == "" {
+		return "-"
+	}
+	return value
+}
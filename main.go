@@ -14,7 +14,9 @@ import (
 )
 
 type Config struct {
-	API api.Config `yaml:"api"`
+	API    api.Config    `yaml:"api"`
+	Broker mq.Config     `yaml:"broker"`
+	Server server.Config `yaml:"server"`
 }
 
 func main() {
@@ -29,8 +31,15 @@ func main() {
 	// you can log it with you favorite logger (ex Logrus, Zap, etc)
 	chief.SetEventHandler(chiefEventHandler())
 
-	broker := mq.NewBroker()
-	chief.AddWorker("broker-server", api.NewServer(cfg.API, server.GetServer(broker)))
+	broker := mq.NewBrokerWithConfig(cfg.Broker)
+	broker.SetEventHandler(brokerEventHandler(chiefEventHandler()))
+	chief.AddWorker("broker", brokerWorker{broker: broker})
+
+	apiHandler, adminHandler := server.NewHandlers(broker, cfg.Server)
+	chief.AddWorker("broker-server", server.NewServer(cfg.API, cfg.Server.TLS, apiHandler))
+	if adminHandler != nil {
+		chief.AddWorker("admin-server", api.NewServer(*cfg.Server.Admin.Listener, adminHandler))
+	}
 
 	// init all registered workers and run it all
 	chief.Run()
@@ -49,6 +58,14 @@ func getConfiguration() (cfg Config) {
 	if err != nil {
 		log.Fatal("FATAL: unable to unmarshal configuration; ", err.Error())
 	}
+
+	if err = cfg.Broker.Validate(); err != nil {
+		log.Fatal("FATAL: invalid broker configuration; ", err.Error())
+	}
+
+	if err = cfg.Server.Validate(); err != nil {
+		log.Fatal("FATAL: invalid server configuration; ", err.Error())
+	}
 	return cfg
 }
 
@@ -66,3 +83,31 @@ func chiefEventHandler() func(event uwe.Event) {
 		log.Println(fmt.Sprintf("%s: %s %+v", level, event.Message, event.Fields))
 	}
 }
+
+// brokerWorker runs background jobs of the broker under the `uwe.Chief`.
+type brokerWorker struct {
+	broker *mq.Broker
+}
+
+func (worker brokerWorker) Init() error {
+	return nil
+}
+
+func (worker brokerWorker) Run(ctx uwe.Context) error {
+	return worker.broker.Run(ctx)
+}
+
+func brokerEventHandler(handler func(event uwe.Event)) mq.EventHandler {
+	return func(event mq.Event) {
+		handler(uwe.Event{
+			Level:   uwe.LvlInfo,
+			Worker:  "broker",
+			Message: string(event.Kind),
+			Fields: map[string]interface{}{
+				"namespace":  event.Namespace,
+				"topic":      event.Topic,
+				"subscriber": event.Subscriber,
+			},
+		})
+	}
+}
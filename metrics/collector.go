@@ -0,0 +1,160 @@
+package metrics
+
+import (
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/go-chi/chi"
+	"github.com/go-chi/chi/middleware"
+	"github.com/sheb-gregor/polly-demo/mq"
+)
+
+// Collector holds the metrics of the broker and its HTTP API.
+// Counters are updated by the API handlers, gauges are collected from the broker on each scrape.
+type Collector struct {
+	*Registry
+
+	published    *CounterVec
+	polled       *CounterVec
+	pollDuration *HistogramVec
+	rateLimited  *CounterVec
+	httpRequests *CounterVec
+	httpDuration *HistogramVec
+}
+
+// NewCollector creates the Collector and registers the broker gauges.
+func NewCollector(broker *mq.Broker) *Collector {
+	collector := &Collector{
+		Registry: NewRegistry(),
+		published: NewCounterVec("polly_messages_published_total",
+			"Count of messages accepted for publishing.", "namespace", "topic"),
+		polled: NewCounterVec("polly_messages_polled_total",
+			"Count of messages received by subscribers.", "namespace", "topic"),
+		pollDuration: NewHistogramVec("polly_poll_duration_seconds",
+			"Latency of the poll requests.", DefaultBuckets, "namespace", "topic"),
+		rateLimited: NewCounterVec("polly_rate_limited_total",
+			"Count of requests rejected by the rate limits.", "scope", "operation"),
+		httpRequests: NewCounterVec("polly_http_requests_total",
+			"Count of HTTP requests by route and status code.", "method", "route", "code"),
+		httpDuration: NewHistogramVec("polly_http_request_duration_seconds",
+			"Latency of HTTP requests by route.", DefaultBuckets, "method", "route"),
+	}
+
+	collector.Register(
+		collector.published,
+		collector.polled,
+		collector.pollDuration,
+		collector.rateLimited,
+		collector.httpRequests,
+		collector.httpDuration,
+	)
+	collector.Register(brokerGauges(broker)...)
+	return collector
+}
+
+// Published counts the message accepted by the broker.
+func (collector *Collector) Published(namespace, topic string) {
+	collector.published.Inc(namespace, topic)
+}
+
+// Polled records the latency of the poll and counts the message if it was received.
+func (collector *Collector) Polled(namespace, topic string, received bool, duration time.Duration) {
+	collector.pollDuration.Observe(duration.Seconds(), namespace, topic)
+	if received {
+		collector.polled.Inc(namespace, topic)
+	}
+}
+
+// RateLimited counts the request rejected by the rate limit of the scope (client or topic).
+func (collector *Collector) RateLimited(scope, operation string) {
+	collector.rateLimited.Inc(scope, operation)
+}
+
+// Middleware counts HTTP requests by the route pattern and the response status code.
+func (collector *Collector) Middleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		start := time.Now()
+		ww := middleware.NewWrapResponseWriter(w, r.ProtoMajor)
+		next.ServeHTTP(ww, r)
+
+		route := "unknown"
+		if rctx, ok := r.Context().Value(chi.RouteCtxKey).(*chi.Context); ok && rctx.RoutePattern() != "" {
+			route = rctx.RoutePattern()
+		}
+
+		code := ww.Status()
+		if code == 0 {
+			code = http.StatusOK
+		}
+
+		collector.httpRequests.Inc(r.Method, route, strconv.Itoa(code))
+		collector.httpDuration.Observe(time.Since(start).Seconds(), r.Method, route)
+	})
+}
+
+func brokerGauges(broker *mq.Broker) []Metric {
+	topicGauge := func(value func(info mq.TopicInfo) float64) func() []Sample {
+		return func() []Sample {
+			var samples []Sample
+			for _, ns := range broker.Namespaces() {
+				namespace, _ := broker.LookupNamespace(ns.Name)
+				for _, info := range namespace.Topics() {
+					samples = append(samples, Sample{
+						LabelValues: []string{ns.Name, info.Name},
+						Value:       value(info),
+					})
+				}
+			}
+			return samples
+		}
+	}
+
+	topicLabels := []string{"namespace", "topic"}
+	return []Metric{
+		NewGaugeFunc("polly_topic_subscribers", "Count of subscribers of the topic.", topicLabels,
+			topicGauge(func(info mq.TopicInfo) float64 { return float64(info.Subscribers) })),
+		NewGaugeFunc("polly_topic_messages", "Count of messages stored in the topic.", topicLabels,
+			topicGauge(func(info mq.TopicInfo) float64 { return float64(info.Messages) })),
+		NewGaugeFunc("polly_topic_bytes", "Size of messages stored in the topic.", topicLabels,
+			topicGauge(func(info mq.TopicInfo) float64 { return float64(info.Bytes) })),
+		NewGaugeFunc("polly_topic_oldest_pending_seconds", "Age of the oldest message stored in the topic.",
+			topicLabels,
+			topicGauge(func(info mq.TopicInfo) float64 { return info.OldestPendingAge.Seconds() })),
+
+		NewGaugeFunc("polly_subscription_pending_messages", "Count of unread messages of the subscriber.",
+			[]string{"namespace", "topic", "subscriber"}, func() []Sample {
+				var samples []Sample
+				for _, ns := range broker.Namespaces() {
+					namespace, _ := broker.LookupNamespace(ns.Name)
+					for _, info := range namespace.Topics() {
+						subscribers, _ := namespace.Subscribers(info.Name)
+						for _, sub := range subscribers {
+							samples = append(samples, Sample{
+								LabelValues: []string{ns.Name, info.Name, sub.Name},
+								Value:       float64(sub.Pending),
+							})
+						}
+					}
+				}
+				return samples
+			}),
+
+		NewGaugeFunc("polly_namespace_bytes", "Size of messages stored in the namespace.",
+			[]string{"namespace"}, func() []Sample {
+				var samples []Sample
+				for _, ns := range broker.Namespaces() {
+					samples = append(samples, Sample{LabelValues: []string{ns.Name}, Value: float64(ns.Memory.Used)})
+				}
+				return samples
+			}),
+
+		NewGaugeFunc("polly_memory_bytes", "Size of messages stored in the broker.", nil, func() []Sample {
+			return []Sample{{Value: float64(broker.MemoryUsage().Used)}}
+		}),
+		NewGaugeFunc("polly_memory_limit_bytes", "Limit of the broker memory, 0 means no limit.", nil,
+			func() []Sample {
+				return []Sample{{Value: float64(broker.MemoryUsage().Limit)}}
+			}),
+	}
+}
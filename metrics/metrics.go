@@ -0,0 +1,306 @@
+package metrics
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"math"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// Metric is a family of samples which can be written in the Prometheus text format.
+type Metric interface {
+	WriteText(w io.Writer) error
+}
+
+// Registry is a set of metrics exposed in the Prometheus text format.
+type Registry struct {
+	sync.Mutex
+	metrics []Metric
+}
+
+// NewRegistry creates new empty instance of the Registry.
+func NewRegistry() *Registry {
+	return &Registry{}
+}
+
+// Register adds metrics to the registry.
+func (registry *Registry) Register(metrics ...Metric) {
+	registry.Lock()
+	registry.metrics = append(registry.metrics, metrics...)
+	registry.Unlock()
+}
+
+// WriteText writes all registered metrics in the Prometheus text format.
+func (registry *Registry) WriteText(w io.Writer) error {
+	registry.Lock()
+	metrics := make([]Metric, len(registry.metrics))
+	copy(metrics, registry.metrics)
+	registry.Unlock()
+
+	buf := bufio.NewWriter(w)
+	for _, metric := range metrics {
+		if err := metric.WriteText(buf); err != nil {
+			return err
+		}
+	}
+	return buf.Flush()
+}
+
+// ServeHTTP exposes the metrics for the Prometheus scraper.
+func (registry *Registry) ServeHTTP(w http.ResponseWriter, _ *http.Request) {
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+	_ = registry.WriteText(w)
+}
+
+// Sample is a single value of the metric with the values of its labels.
+type Sample struct {
+	LabelValues []string
+	Value       float64
+}
+
+type desc struct {
+	name   string
+	help   string
+	kind   string
+	labels []string
+}
+
+func (d desc) writeHeader(w io.Writer) error {
+	_, err := fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s %s\n", d.name, escapeHelp(d.help), d.name, d.kind)
+	return err
+}
+
+func (d desc) writeSample(w io.Writer, suffix string, labelValues []string, extra string, value float64) error {
+	pairs := make([]string, 0, len(d.labels)+1)
+	for i, label := range d.labels {
+		var labelValue string
+		if i < len(labelValues) {
+			labelValue = labelValues[i]
+		}
+		pairs = append(pairs, label+`="`+escapeLabel(labelValue)+`"`)
+	}
+	if extra != "" {
+		pairs = append(pairs, extra)
+	}
+
+	var labels string
+	if len(pairs) > 0 {
+		labels = "{" + strings.Join(pairs, ",") + "}"
+	}
+
+	_, err := fmt.Fprintf(w, "%s%s%s %s\n", d.name, suffix, labels, formatFloat(value))
+	return err
+}
+
+// CounterVec is a monotonically increasing value partitioned by labels.
+type CounterVec struct {
+	desc
+	sync.Mutex
+	values map[string]*Sample
+}
+
+// NewCounterVec creates a counter with the provided label names.
+func NewCounterVec(name, help string, labels ...string) *CounterVec {
+	return &CounterVec{
+		desc:   desc{name: name, help: help, kind: "counter", labels: labels},
+		values: map[string]*Sample{},
+	}
+}
+
+// Inc increases the counter by 1.
+func (counter *CounterVec) Inc(labelValues ...string) {
+	counter.Add(1, labelValues...)
+}
+
+// Add increases the counter by the provided value.
+func (counter *CounterVec) Add(value float64, labelValues ...string) {
+	key := labelsKey(labelValues)
+
+	counter.Lock()
+	sample, ok := counter.values[key]
+	if !ok {
+		sample = &Sample{LabelValues: labelValues}
+		counter.values[key] = sample
+	}
+	sample.Value += value
+	counter.Unlock()
+}
+
+// Value returns the current value of the counter.
+func (counter *CounterVec) Value(labelValues ...string) float64 {
+	counter.Lock()
+	defer counter.Unlock()
+
+	if sample, ok := counter.values[labelsKey(labelValues)]; ok {
+		return sample.Value
+	}
+	return 0
+}
+
+func (counter *CounterVec) WriteText(w io.Writer) error {
+	counter.Lock()
+	samples := make([]Sample, 0, len(counter.values))
+	for _, sample := range counter.values {
+		samples = append(samples, *sample)
+	}
+	counter.Unlock()
+
+	return writeSamples(w, counter.desc, samples)
+}
+
+// GaugeFunc is a value partitioned by labels which is collected at the time of scraping.
+type GaugeFunc struct {
+	desc
+	collect func() []Sample
+}
+
+// NewGaugeFunc creates a gauge with the provided label names and collect function.
+func NewGaugeFunc(name, help string, labels []string, collect func() []Sample) *GaugeFunc {
+	return &GaugeFunc{
+		desc:    desc{name: name, help: help, kind: "gauge", labels: labels},
+		collect: collect,
+	}
+}
+
+func (gauge *GaugeFunc) WriteText(w io.Writer) error {
+	return writeSamples(w, gauge.desc, gauge.collect())
+}
+
+// DefaultBuckets are the upper bounds of the histogram buckets in seconds.
+var DefaultBuckets = []float64{0.0005, 0.001, 0.0025, 0.005, 0.01, 0.025, 0.05, 0.1, 0.25, 0.5, 1, 2.5, 5, 10}
+
+// HistogramVec counts the observed values in the configurable buckets partitioned by labels.
+type HistogramVec struct {
+	desc
+	sync.Mutex
+	buckets []float64
+	values  map[string]*histogram
+}
+
+type histogram struct {
+	labelValues []string
+	counts      []uint64
+	count       uint64
+	sum         float64
+}
+
+// NewHistogramVec creates a histogram with the provided buckets and label names.
+func NewHistogramVec(name, help string, buckets []float64, labels ...string) *HistogramVec {
+	sorted := make([]float64, len(buckets))
+	copy(sorted, buckets)
+	sort.Float64s(sorted)
+
+	return &HistogramVec{
+		desc:    desc{name: name, help: help, kind: "histogram", labels: labels},
+		buckets: sorted,
+		values:  map[string]*histogram{},
+	}
+}
+
+// Observe adds the value to the histogram.
+func (hist *HistogramVec) Observe(value float64, labelValues ...string) {
+	key := labelsKey(labelValues)
+
+	hist.Lock()
+	defer hist.Unlock()
+
+	h, ok := hist.values[key]
+	if !ok {
+		h = &histogram{labelValues: labelValues, counts: make([]uint64, len(hist.buckets))}
+		hist.values[key] = h
+	}
+
+	for i, bound := range hist.buckets {
+		if value <= bound {
+			h.counts[i] += 1
+		}
+	}
+	h.count += 1
+	h.sum += value
+}
+
+func (hist *HistogramVec) WriteText(w io.Writer) error {
+	if err := hist.writeHeader(w); err != nil {
+		return err
+	}
+
+	hist.Lock()
+	defer hist.Unlock()
+
+	keys := make([]string, 0, len(hist.values))
+	for key := range hist.values {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	for _, key := range keys {
+		h := hist.values[key]
+		for i, bound := range hist.buckets {
+			le := `le="` + formatFloat(bound) + `"`
+			if err := hist.writeSample(w, "_bucket", h.labelValues, le, float64(h.counts[i])); err != nil {
+				return err
+			}
+		}
+
+		if err := hist.writeSample(w, "_bucket", h.labelValues, `le="+Inf"`, float64(h.count)); err != nil {
+			return err
+		}
+		if err := hist.writeSample(w, "_sum", h.labelValues, "", h.sum); err != nil {
+			return err
+		}
+		if err := hist.writeSample(w, "_count", h.labelValues, "", float64(h.count)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func writeSamples(w io.Writer, d desc, samples []Sample) error {
+	if err := d.writeHeader(w); err != nil {
+		return err
+	}
+
+	sort.Slice(samples, func(i, j int) bool {
+		return labelsKey(samples[i].LabelValues) < labelsKey(samples[j].LabelValues)
+	})
+
+	for _, sample := range samples {
+		if err := d.writeSample(w, "", sample.LabelValues, "", sample.Value); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func labelsKey(labelValues []string) string {
+	return strings.Join(labelValues, "\xff")
+}
+
+func formatFloat(value float64) string {
+	switch {
+	case math.IsInf(value, 1):
+		return "+Inf"
+	case math.IsInf(value, -1):
+		return "-Inf"
+	default:
+		return strconv.FormatFloat(value, 'g', -1, 64)
+	}
+}
+
+var (
+	helpReplacer  = strings.NewReplacer(`\`, `\\`, "\n", `\n`)
+	labelReplacer = strings.NewReplacer(`\`, `\\`, "\n", `\n`, `"`, `\"`)
+)
+
+func escapeHelp(help string) string {
+	return helpReplacer.Replace(help)
+}
+
+func escapeLabel(value string) string {
+	return labelReplacer.Replace(value)
+}
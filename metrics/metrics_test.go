@@ -0,0 +1,48 @@
+package metrics
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRegistry_WriteText(t *testing.T) {
+	registry := NewRegistry()
+
+	counter := NewCounterVec("test_total", "Test counter.", "topic")
+	counter.Inc("b")
+	counter.Add(2, "a\"\n")
+
+	hist := NewHistogramVec("test_seconds", "Test histogram.", []float64{1, 0.1}, "topic")
+	hist.Observe(0.05, "a")
+	hist.Observe(0.5, "a")
+	hist.Observe(5, "a")
+
+	gauge := NewGaugeFunc("test_bytes", "Test gauge.", nil, func() []Sample {
+		return []Sample{{Value: 42}}
+	})
+
+	registry.Register(counter, hist, gauge)
+
+	buf := bytes.NewBuffer(nil)
+	assert.NoError(t, registry.WriteText(buf))
+	assert.Equal(t, `# HELP test_total Test counter.
+# TYPE test_total counter
+test_total{topic="a\"\n"} 2
+test_total{topic="b"} 1
+# HELP test_seconds Test histogram.
+# TYPE test_seconds histogram
+test_seconds_bucket{topic="a",le="0.1"} 1
+test_seconds_bucket{topic="a",le="1"} 2
+test_seconds_bucket{topic="a",le="+Inf"} 3
+test_seconds_sum{topic="a"} 5.55
+test_seconds_count{topic="a"} 3
+# HELP test_bytes Test gauge.
+# TYPE test_bytes gauge
+test_bytes 42
+`, buf.String())
+
+	assert.Equal(t, float64(1), counter.Value("b"))
+	assert.Equal(t, float64(0), counter.Value("c"))
+}
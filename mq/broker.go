@@ -1,72 +1,183 @@
 package mq
 
 import (
-	"encoding/json"
+	"context"
+	"sort"
 	"sync"
+	"time"
 )
 
+// Broker is a set of namespaces with topics. The methods of the embedded Namespace
+// work with the DefaultNamespace.
 type Broker struct {
-	topics sync.Map // topics is a map[string]Topic
+	*Namespace
+
+	namespaces sync.Map // namespaces is a map[string]*Namespace
+
+	config Config
+	// memory is the global budget of bytes held by all topics.
+	memory *budget
+
+	eventHandler EventHandler
 }
 
 // NewBroker creates new instance of Message Broker.
 func NewBroker() *Broker {
-	return &Broker{topics: sync.Map{}}
+	return NewBrokerWithConfig(Config{})
 }
 
-// HandleNewMessage puts the message to the topic if it exist.
-func (broker *Broker) HandleNewMessage(topic string, data json.RawMessage) {
-	raw, present := broker.topics.Load(topic)
-	tReg, ok := raw.(*Topic)
-	if !present || !ok {
-		return
+// NewBrokerWithConfig creates new instance of Message Broker with the provided limits.
+func NewBrokerWithConfig(cfg Config) *Broker {
+	broker := &Broker{
+		namespaces: sync.Map{},
+		config:     cfg,
+		memory:     newBudget(cfg.Memory.MaxBytes),
+	}
+
+	broker.Namespace = newNamespace(broker, DefaultNamespace)
+	broker.namespaces.Store(DefaultNamespace, broker.Namespace)
+	return broker
+}
+
+// GetNamespace returns the namespace with the provided name, it will be created if it does not already exist.
+// The namespace which is not listed in the Config is deleted with its last topic. The empty name means
+// the DefaultNamespace.
+func (broker *Broker) GetNamespace(name string) *Namespace {
+	if name == "" {
+		name = DefaultNamespace
+	}
+
+	if raw, ok := broker.namespaces.Load(name); ok {
+		return raw.(*Namespace)
 	}
 
-	tReg.PutMessage(data)
-	broker.topics.Store(topic, tReg)
+	raw, _ := broker.namespaces.LoadOrStore(name, newNamespace(broker, name))
+	return raw.(*Namespace)
 }
 
-// Subscribe adds the subscriber to the provided topic.
-// The topic will be created if it does not already exist.
-func (broker *Broker) Subscribe(topic, subscriber string) {
-	raw, present := broker.topics.Load(topic)
-	tReg, ok := raw.(*Topic)
-	if !present || !ok {
-		tReg = NewTopic()
+// LookupNamespace returns the namespace with the provided name without creating it.
+// If it does not exist, an empty namespace which is not added to the broker is returned with `false`,
+// so the reads find no topics and the messages are not stored. The empty name means the DefaultNamespace.
+func (broker *Broker) LookupNamespace(name string) (*Namespace, bool) {
+	if name == "" {
+		name = DefaultNamespace
 	}
 
-	tReg.Subscribe(subscriber)
-	broker.topics.Store(topic, tReg)
+	if raw, ok := broker.namespaces.Load(name); ok {
+		return raw.(*Namespace), true
+	}
+	return newNamespace(broker, name), false
 }
 
-// Unsubscribe removes the subscriber from the provided topic and
-// deletes the topic if there are no subscribers.
-func (broker *Broker) Unsubscribe(topic, subscriber string) {
-	raw, present := broker.topics.Load(topic)
-	tReg, ok := raw.(*Topic)
-	if !present || !ok {
+// deleteNamespace removes the namespace without topics from the broker, unless it is the DefaultNamespace
+// or is listed in the Config. The check and the removal are done under the namespace lock, and the namespace
+// is closed, so the concurrent subscription is not added to the removed namespace, but retried with a new one.
+func (broker *Broker) deleteNamespace(ns *Namespace) {
+	if ns.name == DefaultNamespace {
+		return
+	}
+	if _, ok := broker.config.Namespaces[ns.name]; ok {
+		return
+	}
+
+	ns.mu.Lock()
+	defer ns.mu.Unlock()
+
+	if ns.closed || !ns.empty() {
 		return
 	}
+	// the namespace returned by LookupNamespace is not added to the broker.
+	if raw, ok := broker.namespaces.Load(ns.name); !ok || raw.(*Namespace) != ns {
+		return
+	}
+
+	ns.closed = true
+	broker.namespaces.Delete(ns.name)
+}
+
+// Namespaces returns the statistics of all namespaces sorted by name.
+func (broker *Broker) Namespaces() []NamespaceInfo {
+	var namespaces []NamespaceInfo
+	broker.namespaces.Range(func(_, value interface{}) bool {
+		namespaces = append(namespaces, value.(*Namespace).Info())
+		return true
+	})
+
+	sort.Slice(namespaces, func(i, j int) bool { return namespaces[i].Name < namespaces[j].Name })
+	return namespaces
+}
+
+// SetEventHandler sets the receiver of the broker events. It should be called before Run.
+func (broker *Broker) SetEventHandler(handler EventHandler) {
+	broker.eventHandler = handler
+}
+
+// Run performs background jobs of the broker, like the expiry of idle subscriptions,
+// until the context is done.
+func (broker *Broker) Run(ctx context.Context) error {
+	if broker.config.IdleTTL <= 0 {
+		<-ctx.Done()
+		return nil
+	}
+
+	ticker := time.NewTicker(broker.config.expiryInterval())
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return nil
+		case now := <-ticker.C:
+			broker.ExpireIdle(now)
+		}
+	}
+}
 
-	tReg.Unsubscribe(subscriber)
-	if tReg.subCount <= 0 {
-		broker.topics.Delete(topic)
+// ExpireIdle removes subscriptions that have not polled longer than `IdleTTL`
+// and deletes topics without subscribers in all namespaces.
+func (broker *Broker) ExpireIdle(now time.Time) {
+	if broker.config.IdleTTL <= 0 {
 		return
 	}
 
-	broker.topics.Store(topic, tReg)
+	broker.namespaces.Range(func(_, value interface{}) bool {
+		value.(*Namespace).expireIdle(now, broker.config.IdleTTL)
+		return true
+	})
+}
+
+func (broker *Broker) emit(event Event) {
+	if broker.eventHandler != nil {
+		broker.eventHandler(event)
+	}
+}
+
+// Ready returns an error if the broker is not able to accept new messages,
+// e.g. the global memory budget is exhausted and old messages are not dropped.
+func (broker *Broker) Ready() error {
+	if broker.config.Memory.policy() == MemoryDropOldest {
+		return nil
+	}
+
+	stats := broker.memory.stats()
+	if stats.Limit > 0 && stats.Used >= stats.Limit {
+		return ErrMemoryLimit
+	}
+	return nil
 }
 
-// Poll fetch the next unseen message or no message if everything is seen,
-// or `false` if the subscription is not found.
-func (broker *Broker) Poll(topic, subscriber string) (json.RawMessage, bool) {
-	raw, present := broker.topics.Load(topic)
-	tReg, ok := raw.(*Topic)
-	if !present || !ok {
-		return nil, false
+// MemoryUsage returns the current memory usage of the broker and each namespace.
+func (broker *Broker) MemoryUsage() BrokerMemoryStats {
+	stats := BrokerMemoryStats{
+		MemoryStats: broker.memory.stats(),
+		Policy:      broker.config.Memory.policy(),
+		Namespaces:  map[string]NamespaceMemoryStats{},
 	}
 
-	msg, subscribed := tReg.Poll(subscriber)
-	broker.topics.Store(topic, tReg)
-	return msg, subscribed
+	broker.namespaces.Range(func(key, value interface{}) bool {
+		name, _ := key.(string)
+		stats.Namespaces[name] = value.(*Namespace).MemoryUsage()
+		return true
+	})
+	return stats
 }
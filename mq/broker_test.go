@@ -3,6 +3,7 @@ package mq
 import (
 	"encoding/json"
 	"testing"
+	"time"
 
 	"github.com/stretchr/testify/assert"
 )
@@ -122,3 +123,144 @@ func TestBroker_Poll(t *testing.T) {
 		assert.Equal(t, 0, len(tp.unreadCount))
 	}
 }
+
+func TestBroker_MemoryUsage(t *testing.T) {
+	broker := NewBrokerWithConfig(Config{Memory: MemoryConfig{MaxBytes: 12}})
+	name := "bob"
+	message := json.RawMessage("test_message")
+
+	broker.Subscribe("test_1", name)
+	broker.Subscribe("test_2", name)
+
+	assert.NoError(t, broker.HandleNewMessage("test_1", message))
+	assert.Equal(t, ErrMemoryLimit, broker.HandleNewMessage("test_2", message))
+
+	stats := broker.MemoryUsage()
+	assert.Equal(t, int64(12), stats.Used)
+	assert.Equal(t, int64(12), stats.Limit)
+	assert.Equal(t, MemoryReject, stats.Policy)
+	assert.Equal(t, int64(12), stats.Namespaces[DefaultNamespace].Topics["test_1"].Used)
+	assert.Equal(t, int64(0), stats.Namespaces[DefaultNamespace].Topics["test_2"].Used)
+
+	broker.Unsubscribe("test_1", name)
+	assert.NoError(t, broker.HandleNewMessage("test_2", message))
+	assert.Equal(t, int64(12), broker.MemoryUsage().Used)
+}
+
+func TestBroker_ExpireIdle(t *testing.T) {
+	broker := NewBrokerWithConfig(Config{IdleTTL: time.Minute})
+	var events []Event
+	broker.SetEventHandler(func(event Event) { events = append(events, event) })
+
+	broker.Subscribe("test_1", "alice")
+	broker.Subscribe("test_1", "bob")
+	broker.Subscribe("test_2", "bob")
+
+	broker.ExpireIdle(time.Now())
+	assert.Empty(t, events)
+
+	broker.ExpireIdle(time.Now().Add(time.Minute + time.Second))
+	assert.Equal(t, 3, len(events))
+
+	_, ok := broker.topics.Load("test_1")
+	assert.False(t, ok)
+	_, ok = broker.topics.Load("test_2")
+	assert.False(t, ok)
+	assert.Contains(t, events, Event{Kind: EventSubscriptionExpired, Namespace: DefaultNamespace, Topic: "test_2", Subscriber: "bob"})
+}
+
+func TestBroker_OverflowUnsubscribe(t *testing.T) {
+	broker := NewBroker()
+	var events []Event
+	broker.SetEventHandler(func(event Event) { events = append(events, event) })
+
+	opts := SubscribeOptions{MaxPending: 1, Overflow: OverflowUnsubscribe}
+	broker.SubscribeWithOptions("test_1", "alice", opts)
+	assert.NoError(t, broker.HandleNewMessage("test_1", json.RawMessage(`1`)))
+	assert.NoError(t, broker.HandleNewMessage("test_1", json.RawMessage(`2`)))
+
+	// the topic left without subscribers is deleted.
+	assert.Equal(t, []Event{{Kind: EventSubscriptionOverflowed, Namespace: DefaultNamespace, Topic: "test_1",
+		Subscriber: "alice"}}, events)
+	assert.False(t, broker.HasTopic("test_1"))
+	assert.Zero(t, broker.MemoryUsage().Used)
+
+	// the same is done when the nacked message overflows the queue.
+	broker.SubscribeWithOptions("test_2", "bob", opts)
+	assert.NoError(t, broker.HandleNewMessage("test_2", json.RawMessage(`1`)))
+	delivery, _ := broker.Fetch("test_2", "bob")
+	assert.NoError(t, broker.HandleNewMessage("test_2", json.RawMessage(`2`)))
+
+	subscribed, err := broker.Requeue("test_2", "bob", delivery.ID)
+	assert.False(t, subscribed)
+	assert.NoError(t, err)
+	assert.Len(t, events, 2)
+	assert.Equal(t, EventSubscriptionOverflowed, events[1].Kind)
+	assert.False(t, broker.HasTopic("test_2"))
+}
+
+func TestBroker_Topics(t *testing.T) {
+	broker := NewBroker()
+	assert.Empty(t, broker.Topics())
+
+	broker.Subscribe("test_2", "alice")
+	broker.Subscribe("test_1", "bob")
+	assert.NoError(t, broker.HandleNewMessage("test_1", json.RawMessage("test_message")))
+
+	topics := broker.Topics()
+	assert.Equal(t, 2, len(topics))
+	assert.Equal(t, "test_1", topics[0].Name)
+	assert.Equal(t, 1, topics[0].Messages)
+	assert.Equal(t, "test_2", topics[1].Name)
+
+	info, ok := broker.TopicInfo("test_1")
+	assert.True(t, ok)
+	assert.Equal(t, int64(1), info.LastID)
+	assert.Equal(t, int64(12), info.Bytes)
+
+	subscribers, ok := broker.Subscribers("test_1")
+	assert.True(t, ok)
+	assert.Equal(t, []string{"bob"}, []string{subscribers[0].Name})
+	assert.Equal(t, 1, subscribers[0].Pending)
+
+	_, ok = broker.TopicInfo("test_3")
+	assert.False(t, ok)
+	_, ok = broker.Subscribers("test_3")
+	assert.False(t, ok)
+}
+
+func TestBroker_DeleteTopic(t *testing.T) {
+	broker := NewBroker()
+	broker.Subscribe("test_1", "alice")
+	assert.NoError(t, broker.HandleNewMessage("test_1", json.RawMessage("test_message")))
+
+	assert.True(t, broker.Purge("test_1"))
+	assert.True(t, broker.ResetSubscriber("test_1", "alice"))
+	assert.False(t, broker.ResetSubscriber("test_1", "bob"))
+
+	assert.NoError(t, broker.HandleNewMessage("test_1", json.RawMessage("test_message")))
+	assert.True(t, broker.DeleteTopic("test_1"))
+	assert.Equal(t, int64(0), broker.MemoryUsage().Used)
+
+	_, subscribed := broker.Poll("test_1", "alice")
+	assert.False(t, subscribed)
+	assert.False(t, broker.DeleteTopic("test_1"))
+	assert.False(t, broker.Purge("test_1"))
+}
+
+func TestBroker_Ready(t *testing.T) {
+	broker := NewBrokerWithConfig(Config{Memory: MemoryConfig{MaxBytes: 12}})
+	broker.Subscribe("test_1", "bob")
+	assert.NoError(t, broker.Ready())
+
+	assert.NoError(t, broker.HandleNewMessage("test_1", json.RawMessage("test_message")))
+	assert.Equal(t, ErrMemoryLimit, broker.Ready())
+
+	broker.Poll("test_1", "bob")
+	assert.NoError(t, broker.Ready())
+
+	broker = NewBrokerWithConfig(Config{Memory: MemoryConfig{MaxBytes: 12, Policy: MemoryDropOldest}})
+	broker.Subscribe("test_1", "bob")
+	assert.NoError(t, broker.HandleNewMessage("test_1", json.RawMessage("test_message")))
+	assert.NoError(t, broker.Ready())
+}
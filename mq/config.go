@@ -0,0 +1,112 @@
+package mq
+
+import (
+	"errors"
+	"time"
+)
+
+var (
+	// ErrMemoryLimit is returned when the global memory budget of the broker is exhausted.
+	ErrMemoryLimit = errors.New("broker memory limit exceeded")
+	// ErrNamespaceMemoryLimit is returned when the memory budget of the namespace is exhausted.
+	ErrNamespaceMemoryLimit = errors.New("namespace memory limit exceeded")
+	// ErrTopicMemoryLimit is returned when the memory budget of the topic is exhausted.
+	ErrTopicMemoryLimit = errors.New("topic memory limit exceeded")
+)
+
+// MemoryPolicy defines the behaviour of the publishing when the memory budget is exceeded.
+type MemoryPolicy string
+
+const (
+	// MemoryReject rejects the new message with an error.
+	MemoryReject MemoryPolicy = "reject"
+	// MemoryDropOldest removes the oldest messages of the topic until the new one fits.
+	MemoryDropOldest MemoryPolicy = "drop_oldest"
+	// MemoryBlock waits until subscribers release enough memory or `BlockTimeout` expires.
+	MemoryBlock MemoryPolicy = "block"
+)
+
+// DefaultBlockTimeout is used by the MemoryBlock policy when `BlockTimeout` is not set.
+const DefaultBlockTimeout = 5 * time.Second
+
+// MinExpiryInterval is the lowest period of checking for idle subscriptions.
+const MinExpiryInterval = time.Second
+
+// Config is a parameters of the Broker.
+type Config struct {
+	Memory MemoryConfig `json:"memory" yaml:"memory"`
+	// Subscription is the default options for subscriptions created without them.
+	Subscription SubscribeOptions `json:"subscription" yaml:"subscription"`
+	// IdleTTL is a time after which the subscription without polls will be removed, 0 means never.
+	IdleTTL time.Duration `json:"idle_ttl" yaml:"idle_ttl"`
+	// Namespaces is the quotas of namespaces, the ones not listed are limited only by the global budget.
+	Namespaces map[string]NamespaceConfig `json:"namespaces" yaml:"namespaces"`
+}
+
+// NamespaceConfig is the quotas of the namespace.
+type NamespaceConfig struct {
+	// MaxBytes is a limit of bytes held by all topics of the namespace, 0 means no limit.
+	MaxBytes int64 `json:"max_bytes" yaml:"max_bytes"`
+	// TopicMaxBytes overrides the limit of bytes held by each topic of the namespace, if it is set.
+	TopicMaxBytes int64 `json:"topic_max_bytes" yaml:"topic_max_bytes"`
+}
+
+// MemoryConfig is a memory budgets of the Broker.
+// Zero value of the limit means that it is disabled.
+type MemoryConfig struct {
+	// MaxBytes is a limit of bytes held by all topics.
+	MaxBytes int64 `json:"max_bytes" yaml:"max_bytes"`
+	// TopicMaxBytes is a limit of bytes held by each topic.
+	TopicMaxBytes int64 `json:"topic_max_bytes" yaml:"topic_max_bytes"`
+	// Policy is applied when a new message does not fit into the budget, default is MemoryReject.
+	Policy MemoryPolicy `json:"policy" yaml:"policy"`
+	// BlockTimeout is a max time of waiting for the MemoryBlock policy.
+	BlockTimeout time.Duration `json:"block_timeout" yaml:"block_timeout"`
+}
+
+func (cfg MemoryConfig) policy() MemoryPolicy {
+	if cfg.Policy == "" {
+		return MemoryReject
+	}
+	return cfg.Policy
+}
+
+func (cfg MemoryConfig) blockTimeout() time.Duration {
+	if cfg.BlockTimeout <= 0 {
+		return DefaultBlockTimeout
+	}
+	return cfg.BlockTimeout
+}
+
+// Validate checks the correctness of the configuration.
+func (cfg Config) Validate() error {
+	switch cfg.Memory.policy() {
+	case MemoryReject, MemoryDropOldest, MemoryBlock:
+	default:
+		return errors.New("unknown memory policy: " + string(cfg.Memory.Policy))
+	}
+
+	if cfg.Memory.MaxBytes < 0 || cfg.Memory.TopicMaxBytes < 0 {
+		return errors.New("memory limits should not be negative")
+	}
+
+	if cfg.IdleTTL < 0 {
+		return errors.New("idle ttl should not be negative")
+	}
+
+	for name, ns := range cfg.Namespaces {
+		if ns.MaxBytes < 0 || ns.TopicMaxBytes < 0 {
+			return errors.New("memory limits of the namespace " + name + " should not be negative")
+		}
+	}
+	return cfg.Subscription.Validate()
+}
+
+// expiryInterval returns the period of checking for idle subscriptions.
+func (cfg Config) expiryInterval() time.Duration {
+	interval := cfg.IdleTTL / 2
+	if interval < MinExpiryInterval {
+		return MinExpiryInterval
+	}
+	return interval
+}
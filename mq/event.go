@@ -0,0 +1,23 @@
+package mq
+
+// EventKind is a type of the broker event.
+type EventKind string
+
+const (
+	// EventSubscriptionExpired is emitted when the idle subscription was removed.
+	EventSubscriptionExpired EventKind = "subscription_expired"
+	// EventSubscriptionOverflowed is emitted when the subscription was removed
+	// because its queue is full and the overflow policy is OverflowUnsubscribe.
+	EventSubscriptionOverflowed EventKind = "subscription_overflowed"
+)
+
+// Event describes a change in the broker state that was not initiated by the client.
+type Event struct {
+	Kind       EventKind
+	Namespace  string
+	Topic      string
+	Subscriber string
+}
+
+// EventHandler receives events of the broker, it should not block.
+type EventHandler func(event Event)
@@ -0,0 +1,41 @@
+package mq
+
+import "time"
+
+// NamespaceInfo is a snapshot of the namespace state.
+type NamespaceInfo struct {
+	Name        string      `json:"name"`
+	Topics      int         `json:"topics"`
+	Subscribers int64       `json:"subscribers"`
+	Messages    int         `json:"messages"`
+	Memory      MemoryStats `json:"memory"`
+}
+
+// TopicInfo is a snapshot of the topic state.
+type TopicInfo struct {
+	Name string `json:"name"`
+	// Subscribers is the count of subscribers of the topic.
+	Subscribers int64 `json:"subscribers"`
+	// Messages is the count of stored messages which are not yet received by all subscribers.
+	Messages int `json:"messages"`
+	// Bytes is the size of stored messages.
+	Bytes int64 `json:"bytes"`
+	// LastID is the identifier of the last published message.
+	LastID int64 `json:"last_id"`
+	// OldestPendingAge is the time since the publishing of the oldest stored message,
+	// in nanoseconds when encoded to JSON.
+	OldestPendingAge time.Duration `json:"oldest_pending_age"`
+}
+
+// SubscriberInfo is a snapshot of the subscription state.
+type SubscriberInfo struct {
+	Name string `json:"name"`
+	// Pending is the count of unread messages in the queue.
+	Pending int `json:"pending"`
+	// Dropped is the count of messages lost since the last poll.
+	Dropped    int64          `json:"dropped"`
+	MaxPending int            `json:"max_pending"`
+	Overflow   OverflowPolicy `json:"overflow"`
+	Owner      string         `json:"owner,omitempty"`
+	LastPoll   time.Time      `json:"last_poll"`
+}
@@ -0,0 +1,112 @@
+package mq
+
+import "sync"
+
+// MemoryStats shows how many bytes of messages are held and the configured limit, 0 means no limit.
+type MemoryStats struct {
+	Used  int64 `json:"used"`
+	Limit int64 `json:"limit"`
+}
+
+// BrokerMemoryStats is a snapshot of memory usage of the broker and each of its namespaces.
+type BrokerMemoryStats struct {
+	MemoryStats
+	Policy     MemoryPolicy                    `json:"policy"`
+	Namespaces map[string]NamespaceMemoryStats `json:"namespaces"`
+}
+
+// NamespaceMemoryStats is a snapshot of memory usage of the namespace and each of its topics.
+type NamespaceMemoryStats struct {
+	MemoryStats
+	Topics map[string]MemoryStats `json:"topics"`
+}
+
+// budget is a shared counter of the bytes held by messages.
+// The bytes taken from the budget are also taken from its parent.
+type budget struct {
+	sync.Mutex
+
+	parent *budget
+	// err is returned when the limit is exceeded.
+	err   error
+	limit int64
+	used  int64
+	// freed is closed and replaced each time the memory is released,
+	// so the blocked publishers can wait for it.
+	freed chan struct{}
+}
+
+func newBudget(limit int64) *budget {
+	return &budget{limit: limit, err: ErrMemoryLimit, freed: make(chan struct{})}
+}
+
+// newChildBudget creates the budget which is a part of the parent one.
+func newChildBudget(parent *budget, limit int64, err error) *budget {
+	return &budget{parent: parent, limit: limit, err: err, freed: make(chan struct{})}
+}
+
+// fits checks if `size` bytes can ever fit into the limits of the budget and its parents.
+func (b *budget) fits(size int64) error {
+	for ; b != nil; b = b.parent {
+		if b.limit > 0 && size > b.limit {
+			return b.err
+		}
+	}
+	return nil
+}
+
+// reserve takes `size` bytes from the budget and its parents if they fit into the limits.
+func (b *budget) reserve(size int64) error {
+	b.Lock()
+	if b.limit > 0 && b.used+size > b.limit {
+		b.Unlock()
+		return b.err
+	}
+	b.used += size
+	b.Unlock()
+
+	if b.parent == nil {
+		return nil
+	}
+
+	if err := b.parent.reserve(size); err != nil {
+		b.Lock()
+		b.used -= size
+		b.Unlock()
+		return err
+	}
+	return nil
+}
+
+// release returns `size` bytes to the budget and its parents and wakes up all waiters.
+func (b *budget) release(size int64) {
+	if size == 0 {
+		return
+	}
+
+	for ; b != nil; b = b.parent {
+		b.Lock()
+		b.used -= size
+		close(b.freed)
+		b.freed = make(chan struct{})
+		b.Unlock()
+	}
+}
+
+// wait returns a channel which will be closed on the next release of the root budget,
+// so the waiters are notified when the memory is released anywhere in the broker.
+func (b *budget) wait() <-chan struct{} {
+	for b.parent != nil {
+		b = b.parent
+	}
+
+	b.Lock()
+	defer b.Unlock()
+	return b.freed
+}
+
+func (b *budget) stats() MemoryStats {
+	b.Lock()
+	defer b.Unlock()
+	return MemoryStats{Used: b.used, Limit: b.limit}
+}
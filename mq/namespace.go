@@ -0,0 +1,362 @@
+package mq
+
+import (
+	"encoding/json"
+	"sort"
+	"sync"
+	"time"
+)
+
+// DefaultNamespace is the name of the namespace used by the methods of the Broker itself.
+const DefaultNamespace = "default"
+
+// Namespace is an isolated set of topics with its own memory quota.
+type Namespace struct {
+	name   string
+	topics sync.Map // topics is a map[string]Topic
+
+	broker *Broker
+	// memory is the limits of the topics memory usage.
+	memory MemoryConfig
+	// budget is the memory budget of the namespace which is a part of the global one.
+	budget *budget
+
+	// mu guards the creation of topics against the deletion of the namespace.
+	mu sync.RWMutex
+	// closed is set when the namespace is deleted from the broker.
+	closed bool
+}
+
+func newNamespace(broker *Broker, name string) *Namespace {
+	memory := broker.config.Memory
+	quota := broker.config.Namespaces[name]
+	if quota.TopicMaxBytes > 0 {
+		memory.TopicMaxBytes = quota.TopicMaxBytes
+	}
+
+	return &Namespace{
+		name:   name,
+		topics: sync.Map{},
+		broker: broker,
+		memory: memory,
+		budget: newChildBudget(broker.memory, quota.MaxBytes, ErrNamespaceMemoryLimit),
+	}
+}
+
+// Name returns the name of the namespace.
+func (ns *Namespace) Name() string {
+	return ns.name
+}
+
+// HandleNewMessage puts the message to the topic if it exist.
+// It returns an error if the message does not fit into the memory budget
+// or is rejected by the overflow policy of a subscriber.
+func (ns *Namespace) HandleNewMessage(topic string, data json.RawMessage) error {
+	raw, present := ns.topics.Load(topic)
+	tReg, ok := raw.(*Topic)
+	if !present || !ok {
+		return nil
+	}
+
+	unsubscribed, err := tReg.putMessage(data)
+	ns.overflowed(topic, tReg, unsubscribed)
+	return err
+}
+
+// Subscribe adds the subscriber to the provided topic with the default options.
+// The topic will be created if it does not already exist.
+func (ns *Namespace) Subscribe(topic, subscriber string) {
+	ns.SubscribeWithOptions(topic, subscriber, ns.broker.config.Subscription)
+}
+
+// SubscribeWithOptions adds the subscriber with the queue limits to the provided topic.
+// The topic will be created if it does not already exist.
+func (ns *Namespace) SubscribeWithOptions(topic, subscriber string, opts SubscribeOptions) {
+	ns.mu.RLock()
+	if ns.closed {
+		ns.mu.RUnlock()
+		// the namespace deleted concurrently is closed, then the subscription is retried with a new one.
+		ns.broker.GetNamespace(ns.name).SubscribeWithOptions(topic, subscriber, opts)
+		return
+	}
+	defer ns.mu.RUnlock()
+
+	for {
+		raw, present := ns.topics.Load(topic)
+		if !present {
+			raw, _ = ns.topics.LoadOrStore(topic, newTopic(ns.memory, ns.budget))
+		}
+
+		// the topic deleted concurrently is closed, then the subscription is retried with a new one.
+		if raw.(*Topic).subscribe(subscriber, opts) {
+			return
+		}
+	}
+}
+
+// SubscriptionDefaults returns the options used by Subscribe.
+func (ns *Namespace) SubscriptionDefaults() SubscribeOptions {
+	return ns.broker.config.Subscription
+}
+
+// Owner returns the owner of the subscription or `false` if the subscription is not found.
+func (ns *Namespace) Owner(topic, subscriber string) (string, bool) {
+	raw, present := ns.topics.Load(topic)
+	tReg, ok := raw.(*Topic)
+	if !present || !ok {
+		return "", false
+	}
+
+	return tReg.Owner(subscriber)
+}
+
+// Unsubscribe removes the subscriber from the provided topic and
+// deletes the topic if there are no subscribers.
+func (ns *Namespace) Unsubscribe(topic, subscriber string) {
+	raw, present := ns.topics.Load(topic)
+	tReg, ok := raw.(*Topic)
+	if !present || !ok {
+		return
+	}
+
+	tReg.Unsubscribe(subscriber)
+	ns.deleteTopic(topic, tReg, true)
+}
+
+// Poll fetch the next unseen message or no message if everything is seen,
+// or `false` if the subscription is not found.
+func (ns *Namespace) Poll(topic, subscriber string) (json.RawMessage, bool) {
+	raw, present := ns.topics.Load(topic)
+	tReg, ok := raw.(*Topic)
+	if !present || !ok {
+		return nil, false
+	}
+
+	return tReg.Poll(subscriber)
+}
+
+// Fetch works like Poll, but also returns the message identifier and
+// the count of messages dropped for the subscriber since the previous poll.
+func (ns *Namespace) Fetch(topic, subscriber string) (Delivery, bool) {
+	raw, present := ns.topics.Load(topic)
+	tReg, ok := raw.(*Topic)
+	if !present || !ok {
+		return Delivery{}, false
+	}
+
+	return tReg.Fetch(subscriber)
+}
+
+// FetchWait works like Fetch, but if there are no unread messages it waits
+// for a new one until the `done` channel is closed.
+func (ns *Namespace) FetchWait(topic, subscriber string, done <-chan struct{}) (Delivery, bool) {
+	raw, present := ns.topics.Load(topic)
+	tReg, ok := raw.(*Topic)
+	if !present || !ok {
+		return Delivery{}, false
+	}
+
+	return tReg.FetchWait(subscriber, done)
+}
+
+// Requeue returns the delivered message with the identifier to the front of the subscriber queue,
+// or `false` if the subscription is not found.
+func (ns *Namespace) Requeue(topic, subscriber string, id int64) (bool, error) {
+	raw, present := ns.topics.Load(topic)
+	tReg, ok := raw.(*Topic)
+	if !present || !ok {
+		return false, nil
+	}
+
+	subscribed, unsubscribed, err := tReg.requeue(subscriber, id)
+	ns.overflowed(topic, tReg, unsubscribed)
+	return subscribed, err
+}
+
+// overflowed emits the events of the subscriptions removed by the OverflowUnsubscribe policy
+// and deletes the topic left without subscribers.
+func (ns *Namespace) overflowed(topic string, tReg *Topic, subscribers []string) {
+	if len(subscribers) == 0 {
+		return
+	}
+
+	for _, subscriber := range subscribers {
+		ns.broker.emit(Event{
+			Kind:       EventSubscriptionOverflowed,
+			Namespace:  ns.name,
+			Topic:      topic,
+			Subscriber: subscriber,
+		})
+	}
+	ns.deleteTopic(topic, tReg, true)
+}
+
+// Topics returns the statistics of all topics sorted by name.
+func (ns *Namespace) Topics() []TopicInfo {
+	now := time.Now()
+	topics := []TopicInfo{}
+	ns.topics.Range(func(key, value interface{}) bool {
+		if tReg, ok := value.(*Topic); ok {
+			info := tReg.Info(now)
+			info.Name, _ = key.(string)
+			topics = append(topics, info)
+		}
+		return true
+	})
+
+	sort.Slice(topics, func(i, j int) bool { return topics[i].Name < topics[j].Name })
+	return topics
+}
+
+// HasTopic checks whether the topic exists, i.e. it has subscribers.
+func (ns *Namespace) HasTopic(topic string) bool {
+	_, present := ns.topics.Load(topic)
+	return present
+}
+
+// TopicInfo returns the statistics of the topic or `false` if the topic is not found.
+func (ns *Namespace) TopicInfo(topic string) (TopicInfo, bool) {
+	raw, present := ns.topics.Load(topic)
+	tReg, ok := raw.(*Topic)
+	if !present || !ok {
+		return TopicInfo{}, false
+	}
+
+	info := tReg.Info(time.Now())
+	info.Name = topic
+	return info, true
+}
+
+// Subscribers returns the statistics of the topic subscribers or `false` if the topic is not found.
+func (ns *Namespace) Subscribers(topic string) ([]SubscriberInfo, bool) {
+	raw, present := ns.topics.Load(topic)
+	tReg, ok := raw.(*Topic)
+	if !present || !ok {
+		return nil, false
+	}
+
+	return tReg.SubscribersInfo(), true
+}
+
+// Purge deletes all messages of the topic, the subscriptions are kept.
+// It returns `false` if the topic is not found.
+func (ns *Namespace) Purge(topic string) bool {
+	raw, present := ns.topics.Load(topic)
+	tReg, ok := raw.(*Topic)
+	if !present || !ok {
+		return false
+	}
+
+	tReg.Purge()
+	return true
+}
+
+// DeleteTopic forcibly deletes the topic with all its messages and subscriptions.
+// It returns `false` if the topic is not found.
+func (ns *Namespace) DeleteTopic(topic string) bool {
+	raw, present := ns.topics.Load(topic)
+	tReg, ok := raw.(*Topic)
+	if !present || !ok {
+		return false
+	}
+
+	if !ns.deleteTopic(topic, tReg, false) {
+		return false
+	}
+
+	tReg.close()
+	return true
+}
+
+// ResetSubscriber clears the queue of unread messages of the subscriber.
+// It returns `false` if the subscription is not found.
+func (ns *Namespace) ResetSubscriber(topic, subscriber string) bool {
+	raw, present := ns.topics.Load(topic)
+	tReg, ok := raw.(*Topic)
+	if !present || !ok {
+		return false
+	}
+
+	return tReg.ResetSubscriber(subscriber)
+}
+
+// Info returns the statistics of the namespace.
+func (ns *Namespace) Info() NamespaceInfo {
+	info := NamespaceInfo{Name: ns.name, Memory: ns.budget.stats()}
+	for _, topic := range ns.Topics() {
+		info.Topics += 1
+		info.Subscribers += topic.Subscribers
+		info.Messages += topic.Messages
+	}
+	return info
+}
+
+// MemoryUsage returns the current memory usage of the namespace and each topic.
+func (ns *Namespace) MemoryUsage() NamespaceMemoryStats {
+	stats := NamespaceMemoryStats{
+		MemoryStats: ns.budget.stats(),
+		Topics:      map[string]MemoryStats{},
+	}
+
+	ns.topics.Range(func(key, value interface{}) bool {
+		name, _ := key.(string)
+		if tReg, ok := value.(*Topic); ok {
+			stats.Topics[name] = tReg.MemoryUsage()
+		}
+		return true
+	})
+	return stats
+}
+
+// expireIdle removes subscriptions that have not polled longer than `ttl`
+// and deletes topics without subscribers.
+func (ns *Namespace) expireIdle(now time.Time, ttl time.Duration) {
+	ns.topics.Range(func(key, value interface{}) bool {
+		topic, _ := key.(string)
+		tReg, ok := value.(*Topic)
+		if !ok {
+			return true
+		}
+
+		for _, subscriber := range tReg.ExpireIdle(now, ttl) {
+			ns.broker.emit(Event{
+				Kind:       EventSubscriptionExpired,
+				Namespace:  ns.name,
+				Topic:      topic,
+				Subscriber: subscriber,
+			})
+		}
+
+		ns.deleteTopic(topic, tReg, true)
+		return true
+	})
+}
+
+// deleteTopic removes the topic from the namespace, if `onlyEmpty` is set it is removed only without subscribers.
+// The check and the removal are done under the topic lock, and the topic is closed, so the concurrent
+// subscription is not added to the removed topic, but retried with a new one.
+// The namespace left without topics is deleted from the broker.
+func (ns *Namespace) deleteTopic(name string, tReg *Topic, onlyEmpty bool) bool {
+	tReg.Lock()
+	if tReg.closed || onlyEmpty && tReg.subCount > 0 {
+		tReg.Unlock()
+		return false
+	}
+
+	tReg.closed = true
+	ns.topics.Delete(name)
+	tReg.Unlock()
+
+	ns.broker.deleteNamespace(ns)
+	return true
+}
+
+// empty checks whether the namespace has no topics.
+func (ns *Namespace) empty() bool {
+	empty := true
+	ns.topics.Range(func(_, _ interface{}) bool {
+		empty = false
+		return false
+	})
+	return empty
+}
@@ -0,0 +1,161 @@
+package mq
+
+import (
+	"encoding/json"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestNamespace_Isolation(t *testing.T) {
+	broker := NewBroker()
+	teamA := broker.GetNamespace("team-a")
+	teamB := broker.GetNamespace("team-b")
+	assert.Equal(t, teamA, broker.GetNamespace("team-a"))
+	assert.Equal(t, broker.Namespace, broker.GetNamespace(""))
+
+	teamA.Subscribe("orders", "alice")
+	teamB.Subscribe("orders", "alice")
+
+	assert.NoError(t, teamA.HandleNewMessage("orders", json.RawMessage(`"a"`)))
+
+	data, ok := teamA.Poll("orders", "alice")
+	assert.True(t, ok)
+	assert.Equal(t, json.RawMessage(`"a"`), data)
+
+	data, ok = teamB.Poll("orders", "alice")
+	assert.True(t, ok)
+	assert.Nil(t, data)
+
+	_, ok = broker.Poll("orders", "alice")
+	assert.False(t, ok)
+
+	names := []string{}
+	for _, info := range broker.Namespaces() {
+		names = append(names, info.Name)
+	}
+	assert.Equal(t, []string{DefaultNamespace, "team-a", "team-b"}, names)
+}
+
+func TestBroker_LookupNamespace(t *testing.T) {
+	broker := NewBroker()
+	teamA := broker.GetNamespace("team-a")
+
+	ns, ok := broker.LookupNamespace("team-a")
+	assert.True(t, ok)
+	assert.Equal(t, teamA, ns)
+	ns, ok = broker.LookupNamespace("")
+	assert.True(t, ok)
+	assert.Equal(t, broker.Namespace, ns)
+
+	// the missing namespace is empty and is not added to the broker.
+	ns, ok = broker.LookupNamespace("team-b")
+	assert.False(t, ok)
+	assert.Equal(t, "team-b", ns.Name())
+	assert.Empty(t, ns.Topics())
+	assert.NoError(t, ns.HandleNewMessage("orders", json.RawMessage(`"b"`)))
+	assert.Len(t, broker.Namespaces(), 2)
+}
+
+func TestNamespace_MemoryLimit(t *testing.T) {
+	broker := NewBrokerWithConfig(Config{
+		Memory: MemoryConfig{MaxBytes: 100},
+		Namespaces: map[string]NamespaceConfig{
+			"small": {MaxBytes: 6},
+		},
+	})
+
+	small := broker.GetNamespace("small")
+	small.Subscribe("test", "bob")
+	assert.NoError(t, small.HandleNewMessage("test", json.RawMessage(`"abc"`)))
+	assert.Equal(t, ErrNamespaceMemoryLimit, small.HandleNewMessage("test", json.RawMessage(`"abcd"`)))
+	assert.Equal(t, ErrNamespaceMemoryLimit, small.HandleNewMessage("test", json.RawMessage(`"abcdefgh"`)))
+
+	broker.Subscribe("test", "bob")
+	assert.NoError(t, broker.HandleNewMessage("test", json.RawMessage(`"abcdefgh"`)))
+
+	stats := broker.MemoryUsage()
+	assert.Equal(t, int64(15), stats.Used)
+	assert.Equal(t, MemoryStats{Used: 5, Limit: 6}, stats.Namespaces["small"].MemoryStats)
+	assert.Equal(t, int64(10), stats.Namespaces[DefaultNamespace].Used)
+}
+
+func TestNamespace_DeleteEmptyTopic(t *testing.T) {
+	broker := NewBroker()
+
+	// the topic emptied by one subscriber is not deleted with the concurrent subscription of another,
+	// as well as the namespace left without topics.
+	wg := sync.WaitGroup{}
+	for _, subscriber := range []string{"alice", "bob", "carol", "dave"} {
+		wg.Add(1)
+		go func(subscriber string) {
+			defer wg.Done()
+			for i := 0; i < 5000; i++ {
+				broker.GetNamespace("team-a").Subscribe("orders", subscriber)
+				ns, _ := broker.LookupNamespace("team-a")
+				if _, ok := ns.Owner("orders", subscriber); !ok {
+					t.Error("subscription is lost")
+					return
+				}
+				ns.Unsubscribe("orders", subscriber)
+			}
+		}(subscriber)
+	}
+	wg.Wait()
+	_, ok := broker.LookupNamespace("team-a")
+	assert.False(t, ok)
+
+	ns := broker.GetNamespace("team-a")
+	ns.Subscribe("orders", "alice")
+	ns.Unsubscribe("orders", "bob")
+	assert.Len(t, ns.Topics(), 1)
+	assert.True(t, ns.DeleteTopic("orders"))
+	assert.False(t, ns.DeleteTopic("orders"))
+	ns.Subscribe("orders", "alice")
+	_, ok = broker.GetNamespace("team-a").Owner("orders", "alice")
+	assert.True(t, ok)
+}
+
+func TestBroker_DeleteNamespace(t *testing.T) {
+	broker := NewBrokerWithConfig(Config{
+		IdleTTL:    time.Minute,
+		Namespaces: map[string]NamespaceConfig{"team-a": {MaxBytes: 100}},
+	})
+
+	teamA := broker.GetNamespace("team-a")
+	teamA.Subscribe("orders", "alice")
+	teamA.Unsubscribe("orders", "alice")
+	broker.Subscribe("orders", "alice")
+	broker.Unsubscribe("orders", "alice")
+
+	teamB := broker.GetNamespace("team-b")
+	teamB.Subscribe("orders", "alice")
+	teamB.Subscribe("payments", "alice")
+	teamB.Unsubscribe("orders", "alice")
+	assert.Len(t, broker.Namespaces(), 3)
+
+	// the namespace which is not configured is deleted with its last topic.
+	assert.True(t, teamB.DeleteTopic("payments"))
+	names := []string{}
+	for _, info := range broker.Namespaces() {
+		names = append(names, info.Name)
+	}
+	assert.Equal(t, []string{DefaultNamespace, "team-a"}, names)
+	_, ok := broker.LookupNamespace("team-b")
+	assert.False(t, ok)
+
+	// the subscription to the deleted namespace is added to a new one.
+	teamB.Subscribe("orders", "bob")
+	ns, ok := broker.LookupNamespace("team-b")
+	assert.True(t, ok)
+	assert.True(t, teamB != ns)
+	_, ok = ns.Owner("orders", "bob")
+	assert.True(t, ok)
+
+	// the idle subscriptions are expired with the namespace.
+	broker.ExpireIdle(time.Now().Add(time.Hour))
+	_, ok = broker.LookupNamespace("team-b")
+	assert.False(t, ok)
+}
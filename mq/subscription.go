@@ -0,0 +1,178 @@
+package mq
+
+import (
+	"container/list"
+	"encoding/json"
+	"errors"
+	"time"
+)
+
+// ErrQueueFull is returned when the message is rejected because
+// the queue of a subscriber with the OverflowReject policy is full.
+var ErrQueueFull = errors.New("subscriber queue is full")
+
+// ErrUnknownMessage is returned when the requeued message is not delivered to the subscriber.
+var ErrUnknownMessage = errors.New("message is not delivered to the subscriber")
+
+// InFlightLimit is the count of the last delivered messages of each subscriber which can be requeued.
+const InFlightLimit = 100
+
+// OverflowPolicy defines the behaviour when the queue of the subscriber reaches the `MaxPending` limit.
+type OverflowPolicy string
+
+const (
+	// OverflowDropOldest removes the oldest unread message from the queue.
+	OverflowDropOldest OverflowPolicy = "drop_oldest"
+	// OverflowDropNewest skips the new message for this subscriber.
+	OverflowDropNewest OverflowPolicy = "drop_newest"
+	// OverflowUnsubscribe removes the subscription from the topic.
+	OverflowUnsubscribe OverflowPolicy = "unsubscribe"
+	// OverflowReject rejects the publishing of the new message to the topic.
+	OverflowReject OverflowPolicy = "reject"
+)
+
+// SubscribeOptions is a parameters of the subscription.
+type SubscribeOptions struct {
+	// MaxPending is a limit of unread messages in the queue, 0 means no limit.
+	MaxPending int `json:"max_pending" yaml:"max_pending"`
+	// Overflow is applied when the queue is full, default is OverflowDropOldest.
+	Overflow OverflowPolicy `json:"overflow" yaml:"overflow"`
+	// Owner is the identity of the client which created the subscription.
+	Owner string `json:"owner,omitempty" yaml:"-"`
+}
+
+// Validate checks the correctness of the options.
+func (opts SubscribeOptions) Validate() error {
+	switch opts.overflow() {
+	case OverflowDropOldest, OverflowDropNewest, OverflowUnsubscribe, OverflowReject:
+	default:
+		return errors.New("unknown overflow policy: " + string(opts.Overflow))
+	}
+
+	if opts.MaxPending < 0 {
+		return errors.New("max pending should not be negative")
+	}
+	return nil
+}
+
+func (opts SubscribeOptions) overflow() OverflowPolicy {
+	if opts.Overflow == "" {
+		return OverflowDropOldest
+	}
+	return opts.Overflow
+}
+
+// Delivery is a message retrieved by the subscriber.
+type Delivery struct {
+	ID   int64
+	Data json.RawMessage
+	// Dropped is the count of messages lost by the subscriber because
+	// of the queue overflow since the previous poll.
+	Dropped int64
+}
+
+// subscription is the Unread Message Queue (FIFO) of the subscriber,
+// the value of the list item is the message identifier.
+type subscription struct {
+	queue *list.List
+	// elements indexes the queue items by the message identifier,
+	// so the message dropped by the memory policy is removed from the queue.
+	elements map[int64]*list.Element
+	options  SubscribeOptions
+	// dropped is the count of messages lost since the last poll.
+	dropped int64
+	// lastPoll is the time of the last poll or subscribe call.
+	lastPoll time.Time
+	// inFlight is the delivered messages which can be requeued by the identifier,
+	// the value of the list item in the delivery order is the Delivery.
+	inFlight      map[int64]*list.Element
+	inFlightOrder *list.List
+}
+
+func newSubscription(opts SubscribeOptions, now time.Time) *subscription {
+	return &subscription{
+		queue:         list.New(),
+		elements:      map[int64]*list.Element{},
+		options:       opts,
+		lastPoll:      now,
+		inFlight:      map[int64]*list.Element{},
+		inFlightOrder: list.New(),
+	}
+}
+
+// full checks whether the queue reached the MaxPending limit.
+func (sub *subscription) full() bool {
+	return sub.options.MaxPending > 0 && sub.queue.Len() >= sub.options.MaxPending
+}
+
+// push adds the message identifier to the end of the queue.
+func (sub *subscription) push(id int64) {
+	sub.elements[id] = sub.queue.PushBack(id)
+}
+
+// pushFront adds the message identifier to the front of the queue.
+func (sub *subscription) pushFront(id int64) {
+	sub.elements[id] = sub.queue.PushFront(id)
+}
+
+// pop removes the first message identifier from the queue, it returns `false` if the queue is empty.
+func (sub *subscription) pop() (int64, bool) {
+	el := sub.queue.Front()
+	if el == nil {
+		return 0, false
+	}
+
+	id := el.Value.(int64)
+	sub.queue.Remove(el)
+	delete(sub.elements, id)
+	return id, true
+}
+
+// remove deletes the message identifier from the queue, it returns `false` if it is not queued.
+func (sub *subscription) remove(id int64) bool {
+	el, ok := sub.elements[id]
+	if !ok {
+		return false
+	}
+
+	sub.queue.Remove(el)
+	delete(sub.elements, id)
+	return true
+}
+
+// clear removes all message identifiers from the queue.
+func (sub *subscription) clear() {
+	sub.queue.Init()
+	sub.elements = map[int64]*list.Element{}
+}
+
+// track keeps the delivered message, so it can be requeued. Above the InFlightLimit the oldest one is forgotten.
+func (sub *subscription) track(id int64, data json.RawMessage) {
+	sub.untrack(id)
+	if sub.inFlightOrder.Len() >= InFlightLimit {
+		oldest := sub.inFlightOrder.Front()
+		sub.untrack(oldest.Value.(Delivery).ID)
+	}
+	sub.inFlight[id] = sub.inFlightOrder.PushBack(Delivery{ID: id, Data: data})
+}
+
+// delivered returns the data of the delivered message or `false` if it is not tracked.
+func (sub *subscription) delivered(id int64) (json.RawMessage, bool) {
+	el, ok := sub.inFlight[id]
+	if !ok {
+		return nil, false
+	}
+	return el.Value.(Delivery).Data, true
+}
+
+func (sub *subscription) untrack(id int64) {
+	if el, ok := sub.inFlight[id]; ok {
+		sub.inFlightOrder.Remove(el)
+		delete(sub.inFlight, id)
+	}
+}
+
+func (sub *subscription) resetInFlight() {
+	sub.inFlight = map[int64]*list.Element{}
+	sub.inFlightOrder.Init()
+}
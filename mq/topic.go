@@ -1,9 +1,10 @@
 package mq
 
 import (
-	"container/list"
 	"encoding/json"
+	"sort"
 	"sync"
+	"time"
 )
 
 type Topic struct {
@@ -11,116 +12,550 @@ type Topic struct {
 
 	subCount int64
 	lastID   int64
+	// firstID is the lowest identifier of the message that may still be stored.
+	firstID int64
+	// size is the count of bytes held by messages of this topic.
+	size int64
+
+	// memory is the limits and policy of the topic memory usage.
+	memory MemoryConfig
+	// budget is the memory budget shared between all topics of the namespace.
+	budget *budget
 
 	// subscribers - this map contains Unread Message Queues (FIFOs) for each user,
 	// the value of the list item is the message identifier.
-	subscribers map[string]*list.List
+	subscribers map[string]*subscription
 	// unreadCount map contains counters that show how many subscribers have not yet received each message.
 	unreadCount map[int64]int64
 	// messages map with messages, key is unique ID of message
-	messages map[int64]json.RawMessage
+	messages map[int64]message
+	// changed is closed and replaced each time the messages are put into the queues
+	// or the subscribers are removed, so the waiting polls are notified.
+	changed chan struct{}
+	// closed is set when the topic is removed from the namespace, it does not accept new subscribers.
+	closed bool
+}
+
+// message is the stored message payload with the time of publishing.
+type message struct {
+	data      json.RawMessage
+	createdAt time.Time
 }
 
 // NewTopic creates and initialize new topic instance.
 func NewTopic() *Topic {
+	return newTopic(MemoryConfig{}, newBudget(0))
+}
+
+func newTopic(memory MemoryConfig, global *budget) *Topic {
 	return &Topic{
-		subscribers: map[string]*list.List{},
+		firstID:     1,
+		memory:      memory,
+		budget:      global,
+		subscribers: map[string]*subscription{},
 		unreadCount: map[int64]int64{},
-		messages:    map[int64]json.RawMessage{},
+		messages:    map[int64]message{},
+		changed:     make(chan struct{}),
 	}
 }
 
 // Poll checks if the subscriber exists and retrieves the last unread message from the queue.
 func (topic *Topic) Poll(subscriber string) (json.RawMessage, bool) {
+	delivery, subscribed := topic.Fetch(subscriber)
+	return delivery.Data, subscribed
+}
+
+// Fetch checks if the subscriber exists and retrieves the last unread message from the queue
+// together with its identifier and the count of messages dropped since the previous fetch.
+func (topic *Topic) Fetch(subscriber string) (Delivery, bool) {
 	topic.Lock()
 	defer topic.Unlock()
 
-	queue, ok := topic.subscribers[subscriber]
+	sub, ok := topic.subscribers[subscriber]
 	if !ok {
-		return nil, false
+		return Delivery{}, false
 	}
 
-	if queue.Len() == 0 {
-		return nil, true
+	delivery := Delivery{Dropped: sub.dropped}
+	sub.dropped = 0
+	sub.lastPoll = time.Now()
+
+	for {
+		id, ok := sub.pop()
+		if !ok {
+			break
+		}
+
+		if data, found := topic.fetchMessage(id); found {
+			delivery.ID = id
+			delivery.Data = data
+			sub.track(id, data)
+			break
+		}
 	}
 
-	el := queue.Front()
-	queue.Remove(el)
+	return delivery, true
+}
+
+// FetchWait works like Fetch, but if there are no unread messages it waits
+// for a new one until the `done` channel is closed.
+func (topic *Topic) FetchWait(subscriber string, done <-chan struct{}) (Delivery, bool) {
+	var dropped int64
+	for {
+		// the channel is taken before the fetch to not miss the notification.
+		changed := topic.wait()
+
+		delivery, subscribed := topic.Fetch(subscriber)
+		dropped += delivery.Dropped
+		delivery.Dropped = dropped
+		if !subscribed || delivery.Data != nil {
+			return delivery, subscribed
+		}
+
+		select {
+		case <-changed:
+		case <-done:
+			return delivery, true
+		}
+	}
+}
 
-	return topic.fetchMessage(el), true
+// Requeue returns the delivered message to the front of the subscriber queue,
+// so it will be fetched again by the next poll. It returns `false` if the subscriber is not found.
+// Only the last InFlightLimit messages delivered to the subscriber can be requeued, the message
+// is stored again if it is already received by all subscribers. The full queue is handled
+// according to the overflow policy of the subscriber the same way as for a new message.
+func (topic *Topic) Requeue(subscriber string, id int64) (bool, error) {
+	subscribed, _, err := topic.requeue(subscriber, id)
+	return subscribed, err
 }
 
-// PutMessage adds a new message to this topic, increases the message lastID
-// and sets this message as unread for all subscribers.
-func (topic *Topic) PutMessage(data json.RawMessage) {
+// requeue works like Requeue and returns the subscribers removed by the OverflowUnsubscribe policy.
+func (topic *Topic) requeue(subscriber string, id int64) (bool, []string, error) {
 	topic.Lock()
+	defer topic.Unlock()
 
-	topic.lastID += 1
-	topic.messages[topic.lastID] = data
-	topic.unreadCount[topic.lastID] = topic.subCount
+	sub, ok := topic.subscribers[subscriber]
+	if !ok {
+		return false, nil, nil
+	}
 
-	for name := range topic.subscribers {
-		topic.subscribers[name].PushBack(topic.lastID)
+	data, ok := sub.delivered(id)
+	if !ok {
+		return true, nil, ErrUnknownMessage
 	}
 
-	topic.Unlock()
+	if sub.full() {
+		switch sub.options.overflow() {
+		case OverflowReject:
+			return true, nil, ErrQueueFull
+		case OverflowDropNewest:
+			sub.untrack(id)
+			sub.dropped += 1
+			return true, nil, nil
+		case OverflowUnsubscribe:
+			topic.unsubscribe(subscriber)
+			return false, []string{subscriber}, nil
+		}
+	}
+
+	if _, found := topic.messages[id]; !found {
+		if err := topic.tryReserve(int64(len(data))); err != nil {
+			return true, nil, err
+		}
+
+		topic.messages[id] = message{data: data, createdAt: time.Now()}
+		if id < topic.firstID {
+			topic.firstID = id
+		}
+	}
+
+	if sub.full() {
+		oldest, _ := sub.pop()
+		if _, found := topic.fetchMessage(oldest); found {
+			sub.dropped += 1
+		}
+	}
+
+	sub.untrack(id)
+	topic.unreadCount[id] += 1
+	sub.pushFront(id)
+	topic.notify()
+	return true, nil, nil
+}
+
+// PutMessage adds a new message to this topic, increases the message lastID
+// and sets this message as unread for all subscribers.
+// It returns an error if the message does not fit into the memory budget
+// or the queue of the subscriber with the OverflowReject policy is full.
+func (topic *Topic) PutMessage(data json.RawMessage) error {
+	_, err := topic.putMessage(data)
+	return err
+}
+
+// putMessage works like PutMessage and returns the subscribers removed by the OverflowUnsubscribe policy.
+func (topic *Topic) putMessage(data json.RawMessage) ([]string, error) {
+	size := int64(len(data))
+	if topic.memory.TopicMaxBytes > 0 && size > topic.memory.TopicMaxBytes {
+		return nil, ErrTopicMemoryLimit
+	}
+	if err := topic.budget.fits(size); err != nil {
+		return nil, err
+	}
+
+	var deadline <-chan time.Time
+	for {
+		// the channel is taken before the reservation attempt to not miss the release.
+		freed := topic.budget.wait()
+
+		var unsubscribed []string
+		topic.Lock()
+		err := topic.checkQueues()
+		if err == nil {
+			err = topic.reserve(size)
+		}
+		if err == nil {
+			unsubscribed = topic.store(data)
+		}
+		topic.Unlock()
+
+		if err == nil || err == ErrQueueFull || topic.memory.policy() != MemoryBlock {
+			return unsubscribed, err
+		}
+
+		if deadline == nil {
+			timer := time.NewTimer(topic.memory.blockTimeout())
+			defer timer.Stop()
+			deadline = timer.C
+		}
+
+		select {
+		case <-freed:
+		case <-deadline:
+			return nil, err
+		}
+	}
 }
 
 // Poll adds a new subscriber to this topic, increases the counter of the total number of subscribers.
 func (topic *Topic) Subscribe(subscriber string) {
+	topic.SubscribeWithOptions(subscriber, SubscribeOptions{})
+}
+
+// SubscribeWithOptions adds a new subscriber with the queue limits to this topic,
+// or updates the options if the subscriber already exists.
+func (topic *Topic) SubscribeWithOptions(subscriber string, opts SubscribeOptions) {
+	topic.subscribe(subscriber, opts)
+}
+
+// subscribe adds or updates the subscriber, it returns `false` if the topic is closed.
+func (topic *Topic) subscribe(subscriber string, opts SubscribeOptions) bool {
 	topic.Lock()
-	if _, ok := topic.subscribers[subscriber]; !ok {
-		topic.subscribers[subscriber] = list.New()
+	defer topic.Unlock()
+
+	if topic.closed {
+		return false
 	}
+
+	if sub, ok := topic.subscribers[subscriber]; ok {
+		sub.options = opts
+		sub.lastPoll = time.Now()
+		return true
+	}
+
+	topic.subscribers[subscriber] = newSubscription(opts, time.Now())
 	topic.subCount += 1
-	topic.Unlock()
+	return true
 }
 
 // Poll removes a subscriber from this topic, decreases the counter of the total number of subscribers.
 // Also deletes all messages for which this subscriber was the last who did not receive.
 func (topic *Topic) Unsubscribe(subscriber string) {
 	topic.Lock()
-	defer topic.Unlock()
+	topic.unsubscribe(subscriber)
+	topic.Unlock()
+}
 
-	queue, ok := topic.subscribers[subscriber]
+func (topic *Topic) unsubscribe(subscriber string) {
+	sub, ok := topic.subscribers[subscriber]
 	if !ok {
 		return
 	}
 
+	topic.clearQueue(sub)
+	delete(topic.subscribers, subscriber)
+	topic.subCount -= 1
+	topic.notify()
+}
+
+// Owner returns the owner of the subscription or `false` if the subscriber is not found.
+func (topic *Topic) Owner(subscriber string) (string, bool) {
+	topic.Lock()
+	defer topic.Unlock()
+
+	sub, ok := topic.subscribers[subscriber]
+	if !ok {
+		return "", false
+	}
+	return sub.options.Owner, true
+}
+
+// ResetSubscriber removes all unread messages from the subscriber queue
+// and deletes messages for which this subscriber was the last who did not receive.
+// It returns `false` if the subscriber is not found.
+func (topic *Topic) ResetSubscriber(subscriber string) bool {
+	topic.Lock()
+	defer topic.Unlock()
+
+	sub, ok := topic.subscribers[subscriber]
+	if !ok {
+		return false
+	}
+
+	topic.clearQueue(sub)
+	sub.resetInFlight()
+	sub.dropped = 0
+	return true
+}
+
+// Purge deletes all stored messages and clears the queues of all subscribers.
+func (topic *Topic) Purge() {
+	topic.Lock()
+	topic.purge()
+	topic.Unlock()
+}
+
+func (topic *Topic) purge() {
+	for _, sub := range topic.subscribers {
+		sub.clear()
+		sub.resetInFlight()
+	}
+
+	topic.budget.release(topic.size)
+	topic.size = 0
+	topic.messages = map[int64]message{}
+	topic.unreadCount = map[int64]int64{}
+	topic.firstID = topic.lastID + 1
+}
+
+// close purges the topic and removes all subscribers, so new messages will not be stored.
+func (topic *Topic) close() {
+	topic.Lock()
+	topic.purge()
+	topic.subscribers = map[string]*subscription{}
+	topic.subCount = 0
+	topic.notify()
+	topic.Unlock()
+}
+
+func (topic *Topic) clearQueue(sub *subscription) {
 	for {
-		if queue.Len() == 0 {
+		id, ok := sub.pop()
+		if !ok {
 			break
 		}
-		el := queue.Front()
-		if el == nil {
-			break
+
+		topic.fetchMessage(id)
+	}
+}
+
+// ExpireIdle removes subscribers that have not polled since `now - ttl`
+// the same way as Unsubscribe does, and returns their names.
+func (topic *Topic) ExpireIdle(now time.Time, ttl time.Duration) []string {
+	topic.Lock()
+	defer topic.Unlock()
+
+	var expired []string
+	for name, sub := range topic.subscribers {
+		if now.Sub(sub.lastPoll) > ttl {
+			topic.unsubscribe(name)
+			expired = append(expired, name)
 		}
+	}
+	return expired
+}
 
-		queue.Remove(el)
+// Info returns the statistics of this topic at the moment `now`.
+func (topic *Topic) Info(now time.Time) TopicInfo {
+	topic.Lock()
+	defer topic.Unlock()
 
-		topic.fetchMessage(el)
+	info := TopicInfo{
+		Subscribers: topic.subCount,
+		Messages:    len(topic.messages),
+		Bytes:       topic.size,
+		LastID:      topic.lastID,
 	}
 
-	delete(topic.subscribers, subscriber)
-	topic.subCount -= 1
+	if id, ok := topic.oldestID(); ok {
+		info.OldestPendingAge = now.Sub(topic.messages[id].createdAt)
+	}
+	return info
 }
 
-func (topic *Topic) fetchMessage(el *list.Element) json.RawMessage {
-	id, ok := el.Value.(int64)
-	if !ok {
-		return nil
+// SubscribersInfo returns the statistics of each subscriber sorted by name.
+func (topic *Topic) SubscribersInfo() []SubscriberInfo {
+	topic.Lock()
+	defer topic.Unlock()
+
+	info := make([]SubscriberInfo, 0, len(topic.subscribers))
+	for name, sub := range topic.subscribers {
+		info = append(info, SubscriberInfo{
+			Name:       name,
+			Pending:    sub.queue.Len(),
+			Dropped:    sub.dropped,
+			MaxPending: sub.options.MaxPending,
+			Overflow:   sub.options.overflow(),
+			Owner:      sub.options.Owner,
+			LastPoll:   sub.lastPoll,
+		})
 	}
 
-	data, found := topic.messages[id]
+	sort.Slice(info, func(i, j int) bool { return info[i].Name < info[j].Name })
+	return info
+}
+
+// MemoryUsage returns the count of bytes held by messages of this topic.
+func (topic *Topic) MemoryUsage() MemoryStats {
+	topic.Lock()
+	defer topic.Unlock()
+	return MemoryStats{Used: topic.size, Limit: topic.memory.TopicMaxBytes}
+}
+
+// checkQueues returns an error if any subscriber with the OverflowReject policy has a full queue.
+func (topic *Topic) checkQueues() error {
+	for _, sub := range topic.subscribers {
+		if sub.full() && sub.options.overflow() == OverflowReject {
+			return ErrQueueFull
+		}
+	}
+	return nil
+}
+
+// store saves the message and puts it into the queues of subscribers
+// applying their overflow policies. The message is not kept if there are no recipients.
+// It returns the subscribers removed by the OverflowUnsubscribe policy.
+func (topic *Topic) store(data json.RawMessage) []string {
+	topic.lastID += 1
+	id := topic.lastID
+	topic.messages[id] = message{data: data, createdAt: time.Now()}
+
+	var recipients int64
+	var unsubscribed []string
+	for name, sub := range topic.subscribers {
+		if sub.full() {
+			switch sub.options.overflow() {
+			case OverflowDropNewest:
+				sub.dropped += 1
+				continue
+			case OverflowUnsubscribe:
+				topic.unsubscribe(name)
+				unsubscribed = append(unsubscribed, name)
+				continue
+			default:
+				oldest, _ := sub.pop()
+				if _, found := topic.fetchMessage(oldest); found {
+					sub.dropped += 1
+				}
+			}
+		}
+
+		sub.push(id)
+		recipients += 1
+	}
+
+	topic.unreadCount[id] = recipients
+	if recipients == 0 {
+		topic.deleteMessage(id)
+		return unsubscribed
+	}
+	topic.notify()
+	return unsubscribed
+}
+
+// wait returns a channel which will be closed on the next change of the queues.
+func (topic *Topic) wait() <-chan struct{} {
+	topic.Lock()
+	defer topic.Unlock()
+	return topic.changed
+}
+
+// notify wakes up the waiting polls, it should be called under the lock.
+func (topic *Topic) notify() {
+	close(topic.changed)
+	topic.changed = make(chan struct{})
+}
+
+// reserve takes the memory for a new message from the topic and global budgets,
+// with the MemoryDropOldest policy it drops the oldest messages until the new one fits.
+func (topic *Topic) reserve(size int64) error {
+	for {
+		err := topic.tryReserve(size)
+		if err == nil || topic.memory.policy() != MemoryDropOldest || !topic.dropOldest() {
+			return err
+		}
+	}
+}
+
+func (topic *Topic) tryReserve(size int64) error {
+	if topic.memory.TopicMaxBytes > 0 && topic.size+size > topic.memory.TopicMaxBytes {
+		return ErrTopicMemoryLimit
+	}
+
+	if err := topic.budget.reserve(size); err != nil {
+		return err
+	}
+
+	topic.size += size
+	return nil
+}
+
+// dropOldest deletes the oldest stored message and removes it from the subscribers queues,
+// it is counted as dropped for the subscribers which have not received it.
+func (topic *Topic) dropOldest() bool {
+	id, ok := topic.oldestID()
+	if ok {
+		for _, sub := range topic.subscribers {
+			if sub.remove(id) {
+				sub.dropped += 1
+			}
+		}
+
+		topic.deleteMessage(id)
+		topic.firstID = id + 1
+	}
+	return ok
+}
+
+// oldestID finds the identifier of the oldest stored message.
+func (topic *Topic) oldestID() (int64, bool) {
+	for ; topic.firstID <= topic.lastID; topic.firstID++ {
+		if _, ok := topic.messages[topic.firstID]; ok {
+			return topic.firstID, true
+		}
+	}
+	return 0, false
+}
+
+func (topic *Topic) deleteMessage(id int64) {
+	size := int64(len(topic.messages[id].data))
+	delete(topic.messages, id)
+	delete(topic.unreadCount, id)
+
+	topic.size -= size
+	topic.budget.release(size)
+}
+
+func (topic *Topic) fetchMessage(id int64) (json.RawMessage, bool) {
+	msg, found := topic.messages[id]
 	if !found {
-		return nil
+		return nil, false
 	}
 
 	topic.unreadCount[id] -= 1
 	if topic.unreadCount[id] <= 0 {
-		delete(topic.messages, id)
-		delete(topic.unreadCount, id)
+		topic.deleteMessage(id)
 	}
 
-	return data
+	return msg.data, true
 }
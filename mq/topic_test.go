@@ -2,7 +2,9 @@ package mq
 
 import (
 	"encoding/json"
+	"strconv"
 	"testing"
+	"time"
 
 	"github.com/stretchr/testify/assert"
 )
@@ -36,7 +38,7 @@ func TestTopic_Subscribe(t *testing.T) {
 		list, ok := topic.subscribers[name]
 		assert.True(t, ok)
 		assert.NotNil(t, list)
-		assert.Equal(t, 0, list.Len())
+		assert.Equal(t, 0, list.queue.Len())
 	}
 
 	assert.Equal(t, int64(len(names)), topic.subCount)
@@ -106,7 +108,7 @@ func TestTopic_PutMessage(t *testing.T) {
 
 		m, ok := topic.messages[id]
 		assert.True(t, ok)
-		assert.Equal(t, msg, m)
+		assert.Equal(t, msg, m.data)
 
 		count, ok := topic.unreadCount[id]
 		assert.True(t, ok)
@@ -116,7 +118,7 @@ func TestTopic_PutMessage(t *testing.T) {
 			list, ok := topic.subscribers[name]
 			assert.True(t, ok)
 			assert.NotNil(t, list)
-			assert.Equal(t, int(id), list.Len())
+			assert.Equal(t, int(id), list.queue.Len())
 		}
 	}
 
@@ -124,7 +126,7 @@ func TestTopic_PutMessage(t *testing.T) {
 
 	for i, message := range messages {
 		m := topic.messages[int64(i+1)]
-		assert.Equal(t, message, m)
+		assert.Equal(t, message, m.data)
 	}
 }
 
@@ -156,7 +158,7 @@ func TestTopic_Poll(t *testing.T) {
 		list, ok := topic.subscribers[name]
 		assert.True(t, ok)
 		assert.NotNil(t, list)
-		assert.Equal(t, msgCount, list.Len())
+		assert.Equal(t, msgCount, list.queue.Len())
 
 		for msgID := 0; msgID < msgCount; msgID++ {
 			message, subscribed := topic.Poll(name)
@@ -165,7 +167,7 @@ func TestTopic_Poll(t *testing.T) {
 			list, ok := topic.subscribers[name]
 			assert.True(t, ok)
 			assert.NotNil(t, list)
-			assert.Equal(t, msgCount-(msgID+1), list.Len())
+			assert.Equal(t, msgCount-(msgID+1), list.queue.Len())
 			assert.Equal(t, messages[msgID], message)
 
 			unreadCount := topic.unreadCount[int64(msgID+1)]
@@ -180,3 +182,400 @@ func TestTopic_Poll(t *testing.T) {
 		assert.False(t, ok)
 	}
 }
+
+func TestTopic_PutMessage_MemoryLimit(t *testing.T) {
+	topic := newTopic(MemoryConfig{TopicMaxBytes: 12}, newBudget(0))
+	topic.Subscribe("alice")
+
+	assert.NoError(t, topic.PutMessage(json.RawMessage("test_1")))
+	assert.NoError(t, topic.PutMessage(json.RawMessage("test_2")))
+	assert.Equal(t, ErrTopicMemoryLimit, topic.PutMessage(json.RawMessage("test_3")))
+	assert.Equal(t, int64(12), topic.MemoryUsage().Used)
+
+	message, subscribed := topic.Poll("alice")
+	assert.True(t, subscribed)
+	assert.Equal(t, json.RawMessage("test_1"), message)
+	assert.Equal(t, int64(6), topic.MemoryUsage().Used)
+
+	assert.NoError(t, topic.PutMessage(json.RawMessage("test_3")))
+	assert.Equal(t, ErrTopicMemoryLimit, topic.PutMessage(json.RawMessage("too_large_message")))
+}
+
+func TestTopic_PutMessage_DropOldest(t *testing.T) {
+	global := newBudget(12)
+	topic := newTopic(MemoryConfig{Policy: MemoryDropOldest}, global)
+	topic.Subscribe("alice")
+	topic.Subscribe("bob")
+
+	messages := []json.RawMessage{
+		json.RawMessage("test_1"),
+		json.RawMessage("test_2"),
+		json.RawMessage("test_3"),
+		json.RawMessage("test_4"),
+	}
+	for _, msg := range messages {
+		assert.NoError(t, topic.PutMessage(msg))
+	}
+
+	assert.Equal(t, 2, len(topic.messages))
+	assert.Equal(t, 2, len(topic.unreadCount))
+	assert.Equal(t, int64(12), global.stats().Used)
+
+	for _, name := range []string{"alice", "bob"} {
+		for _, expected := range messages[2:] {
+			message, subscribed := topic.Poll(name)
+			assert.True(t, subscribed)
+			assert.Equal(t, expected, message)
+		}
+
+		message, subscribed := topic.Poll(name)
+		assert.True(t, subscribed)
+		assert.Nil(t, message)
+	}
+
+	assert.Equal(t, 0, len(topic.messages))
+	assert.Equal(t, int64(0), global.stats().Used)
+}
+
+func TestTopic_PutMessage_DropOldestPending(t *testing.T) {
+	topic := newTopic(MemoryConfig{Policy: MemoryDropOldest}, newBudget(12))
+	topic.Subscribe("alice")
+	topic.SubscribeWithOptions("bob", SubscribeOptions{MaxPending: 3, Overflow: OverflowReject})
+
+	// the dropped messages are removed from the queues, so the queue limit is not reached.
+	for i := 1; i <= 10; i++ {
+		assert.NoError(t, topic.PutMessage(json.RawMessage("test_"+strconv.Itoa(i%10))))
+	}
+
+	for _, info := range topic.SubscribersInfo() {
+		assert.Equal(t, 2, info.Pending, info.Name)
+		assert.Equal(t, int64(8), info.Dropped, info.Name)
+	}
+
+	delivery, _ := topic.Fetch("alice")
+	assert.Equal(t, Delivery{ID: 9, Data: json.RawMessage("test_9"), Dropped: 8}, delivery)
+	delivery, _ = topic.Fetch("alice")
+	assert.Equal(t, Delivery{ID: 10, Data: json.RawMessage("test_0")}, delivery)
+	assert.Zero(t, topic.subscribers["alice"].queue.Len())
+	assert.Empty(t, topic.subscribers["alice"].elements)
+}
+
+func TestTopic_PutMessage_Block(t *testing.T) {
+	topic := newTopic(MemoryConfig{TopicMaxBytes: 6, Policy: MemoryBlock, BlockTimeout: time.Second}, newBudget(0))
+	topic.Subscribe("alice")
+	assert.NoError(t, topic.PutMessage(json.RawMessage("test_1")))
+
+	done := make(chan error)
+	go func() { done <- topic.PutMessage(json.RawMessage("test_2")) }()
+
+	select {
+	case <-done:
+		t.Fatal("publishing should be blocked")
+	case <-time.After(50 * time.Millisecond):
+	}
+
+	message, _ := topic.Poll("alice")
+	assert.Equal(t, json.RawMessage("test_1"), message)
+	assert.NoError(t, <-done)
+
+	topic.memory.BlockTimeout = 10 * time.Millisecond
+	assert.Equal(t, ErrTopicMemoryLimit, topic.PutMessage(json.RawMessage("test_3")))
+}
+
+func TestTopic_PutMessage_Overflow(t *testing.T) {
+	topic := NewTopic()
+	topic.SubscribeWithOptions("oldest", SubscribeOptions{MaxPending: 2, Overflow: OverflowDropOldest})
+	topic.SubscribeWithOptions("newest", SubscribeOptions{MaxPending: 2, Overflow: OverflowDropNewest})
+	topic.SubscribeWithOptions("leaver", SubscribeOptions{MaxPending: 2, Overflow: OverflowUnsubscribe})
+	topic.Subscribe("unlimited")
+
+	messages := []json.RawMessage{
+		json.RawMessage("test_1"),
+		json.RawMessage("test_2"),
+		json.RawMessage("test_3"),
+		json.RawMessage("test_4"),
+	}
+	for _, msg := range messages {
+		assert.NoError(t, topic.PutMessage(msg))
+	}
+
+	assert.Equal(t, int64(3), topic.subCount)
+	_, ok := topic.subscribers["leaver"]
+	assert.False(t, ok)
+
+	delivery, ok := topic.Fetch("oldest")
+	assert.True(t, ok)
+	assert.Equal(t, messages[2], delivery.Data)
+	assert.Equal(t, int64(3), delivery.ID)
+	assert.Equal(t, int64(2), delivery.Dropped)
+
+	delivery, ok = topic.Fetch("newest")
+	assert.True(t, ok)
+	assert.Equal(t, messages[0], delivery.Data)
+	assert.Equal(t, int64(2), delivery.Dropped)
+
+	delivery, ok = topic.Fetch("newest")
+	assert.True(t, ok)
+	assert.Equal(t, messages[1], delivery.Data)
+	assert.Equal(t, int64(0), delivery.Dropped)
+
+	for _, msg := range messages {
+		delivery, ok = topic.Fetch("unlimited")
+		assert.True(t, ok)
+		assert.Equal(t, msg, delivery.Data)
+	}
+
+	delivery, _ = topic.Fetch("oldest")
+	assert.Equal(t, messages[3], delivery.Data)
+
+	assert.Equal(t, 0, len(topic.messages))
+	assert.Equal(t, 0, len(topic.unreadCount))
+}
+
+func TestTopic_PutMessage_OverflowReject(t *testing.T) {
+	topic := NewTopic()
+	topic.SubscribeWithOptions("alice", SubscribeOptions{MaxPending: 1, Overflow: OverflowReject})
+	topic.Subscribe("bob")
+
+	assert.NoError(t, topic.PutMessage(json.RawMessage("test_1")))
+	assert.Equal(t, ErrQueueFull, topic.PutMessage(json.RawMessage("test_2")))
+	assert.Equal(t, 1, len(topic.messages))
+	assert.Equal(t, 1, topic.subscribers["bob"].queue.Len())
+
+	topic.Poll("alice")
+	assert.NoError(t, topic.PutMessage(json.RawMessage("test_2")))
+}
+
+func TestTopic_Subscribe_Twice(t *testing.T) {
+	topic := NewTopic()
+	topic.Subscribe("alice")
+	topic.SubscribeWithOptions("alice", SubscribeOptions{MaxPending: 1})
+
+	assert.Equal(t, int64(1), topic.subCount)
+	assert.Equal(t, 1, topic.subscribers["alice"].options.MaxPending)
+
+	assert.NoError(t, topic.PutMessage(json.RawMessage("test_1")))
+	topic.Poll("alice")
+	assert.Equal(t, 0, len(topic.messages))
+}
+
+func TestTopic_ExpireIdle(t *testing.T) {
+	topic := NewTopic()
+	topic.Subscribe("alice")
+	topic.Subscribe("bob")
+	assert.NoError(t, topic.PutMessage(json.RawMessage("test_1")))
+
+	now := time.Now()
+	assert.Empty(t, topic.ExpireIdle(now, time.Minute))
+
+	topic.subscribers["bob"].lastPoll = now.Add(-2 * time.Minute)
+	assert.Equal(t, []string{"bob"}, topic.ExpireIdle(now, time.Minute))
+	assert.Equal(t, int64(1), topic.subCount)
+	assert.Equal(t, int64(1), topic.unreadCount[1])
+
+	topic.Poll("alice")
+	assert.Equal(t, 0, len(topic.messages))
+	assert.Equal(t, 0, len(topic.unreadCount))
+}
+
+func TestTopic_Info(t *testing.T) {
+	topic := NewTopic()
+	topic.Subscribe("bob")
+	topic.SubscribeWithOptions("alice", SubscribeOptions{MaxPending: 5, Overflow: OverflowReject})
+
+	assert.NoError(t, topic.PutMessage(json.RawMessage("test_1")))
+	assert.NoError(t, topic.PutMessage(json.RawMessage("test_22")))
+	topic.Poll("alice")
+	topic.Poll("bob")
+
+	info := topic.Info(topic.messages[2].createdAt.Add(time.Second))
+	assert.Equal(t, int64(2), info.Subscribers)
+	assert.Equal(t, 1, info.Messages)
+	assert.Equal(t, int64(7), info.Bytes)
+	assert.Equal(t, int64(2), info.LastID)
+	assert.Equal(t, time.Second, info.OldestPendingAge)
+
+	subscribers := topic.SubscribersInfo()
+	assert.Equal(t, 2, len(subscribers))
+	assert.Equal(t, "alice", subscribers[0].Name)
+	assert.Equal(t, 1, subscribers[0].Pending)
+	assert.Equal(t, 5, subscribers[0].MaxPending)
+	assert.Equal(t, OverflowReject, subscribers[0].Overflow)
+	assert.Equal(t, "bob", subscribers[1].Name)
+	assert.Equal(t, OverflowDropOldest, subscribers[1].Overflow)
+}
+
+func TestTopic_ResetSubscriber(t *testing.T) {
+	topic := NewTopic()
+	topic.Subscribe("alice")
+	topic.Subscribe("bob")
+	assert.NoError(t, topic.PutMessage(json.RawMessage("test_1")))
+	assert.NoError(t, topic.PutMessage(json.RawMessage("test_2")))
+	topic.Poll("bob")
+
+	assert.False(t, topic.ResetSubscriber("unknown"))
+	assert.True(t, topic.ResetSubscriber("alice"))
+	assert.Equal(t, int64(2), topic.subCount)
+	assert.Equal(t, 0, topic.subscribers["alice"].queue.Len())
+	assert.Equal(t, 1, len(topic.messages))
+	assert.Equal(t, int64(1), topic.unreadCount[2])
+
+	message, _ := topic.Poll("bob")
+	assert.Equal(t, json.RawMessage("test_2"), message)
+	assert.Equal(t, 0, len(topic.messages))
+	assert.Equal(t, 0, len(topic.unreadCount))
+}
+
+func TestTopic_Purge(t *testing.T) {
+	global := newBudget(0)
+	topic := newTopic(MemoryConfig{}, global)
+	topic.Subscribe("alice")
+	topic.Subscribe("bob")
+	assert.NoError(t, topic.PutMessage(json.RawMessage("test_1")))
+	assert.NoError(t, topic.PutMessage(json.RawMessage("test_2")))
+
+	topic.Purge()
+	assert.Equal(t, int64(2), topic.subCount)
+	assert.Equal(t, 0, len(topic.messages))
+	assert.Equal(t, 0, len(topic.unreadCount))
+	assert.Equal(t, int64(0), global.stats().Used)
+
+	message, subscribed := topic.Poll("alice")
+	assert.True(t, subscribed)
+	assert.Nil(t, message)
+
+	assert.NoError(t, topic.PutMessage(json.RawMessage("test_3")))
+	message, _ = topic.Poll("alice")
+	assert.Equal(t, json.RawMessage("test_3"), message)
+}
+
+func TestTopic_FetchWait(t *testing.T) {
+	topic := NewTopic()
+	topic.Subscribe("alice")
+
+	done := make(chan struct{})
+	close(done)
+	delivery, subscribed := topic.FetchWait("alice", done)
+	assert.True(t, subscribed)
+	assert.Nil(t, delivery.Data)
+
+	_, subscribed = topic.FetchWait("unknown", nil)
+	assert.False(t, subscribed)
+
+	go func() {
+		time.Sleep(10 * time.Millisecond)
+		assert.NoError(t, topic.PutMessage(json.RawMessage("test_1")))
+	}()
+	delivery, subscribed = topic.FetchWait("alice", nil)
+	assert.True(t, subscribed)
+	assert.Equal(t, json.RawMessage("test_1"), delivery.Data)
+
+	go func() {
+		time.Sleep(10 * time.Millisecond)
+		topic.Unsubscribe("alice")
+	}()
+	_, subscribed = topic.FetchWait("alice", nil)
+	assert.False(t, subscribed)
+}
+
+func TestTopic_Requeue(t *testing.T) {
+	topic := NewTopic()
+	topic.Subscribe("alice")
+	topic.Subscribe("bob")
+	assert.NoError(t, topic.PutMessage(json.RawMessage("test_1")))
+	assert.NoError(t, topic.PutMessage(json.RawMessage("test_2")))
+
+	first, _ := topic.Fetch("alice")
+	ok, err := topic.Requeue("alice", first.ID)
+	assert.True(t, ok)
+	assert.NoError(t, err)
+	assert.Equal(t, int64(2), topic.unreadCount[first.ID])
+
+	// the message is requeued only once per delivery.
+	_, err = topic.Requeue("alice", first.ID)
+	assert.Equal(t, ErrUnknownMessage, err)
+
+	delivery, _ := topic.Fetch("alice")
+	assert.Equal(t, first, delivery)
+
+	// the message received by all subscribers is stored again.
+	topic.Fetch("bob")
+	assert.Equal(t, 1, len(topic.messages))
+	ok, err = topic.Requeue("bob", first.ID)
+	assert.True(t, ok)
+	assert.NoError(t, err)
+	assert.Equal(t, int64(len("test_1")+len("test_2")), topic.size)
+
+	delivery, _ = topic.Fetch("bob")
+	assert.Equal(t, json.RawMessage("test_1"), delivery.Data)
+	delivery, _ = topic.Fetch("bob")
+	assert.Equal(t, json.RawMessage("test_2"), delivery.Data)
+
+	ok, _ = topic.Requeue("unknown", first.ID)
+	assert.False(t, ok)
+	// the message not delivered to the subscriber can not be requeued.
+	_, err = topic.Requeue("alice", 10)
+	assert.Equal(t, ErrUnknownMessage, err)
+	_, err = topic.Requeue("alice", 2)
+	assert.Equal(t, ErrUnknownMessage, err)
+}
+
+func TestTopic_RequeueOverflow(t *testing.T) {
+	topic := NewTopic()
+	topic.SubscribeWithOptions("alice", SubscribeOptions{MaxPending: 1})
+	topic.SubscribeWithOptions("bob", SubscribeOptions{MaxPending: 1, Overflow: OverflowReject})
+	topic.SubscribeWithOptions("carol", SubscribeOptions{MaxPending: 1, Overflow: OverflowDropNewest})
+	topic.SubscribeWithOptions("dave", SubscribeOptions{MaxPending: 1, Overflow: OverflowUnsubscribe})
+
+	var first = map[string]Delivery{}
+	for i := 1; i <= 2; i++ {
+		assert.NoError(t, topic.PutMessage(json.RawMessage("test_"+strconv.Itoa(i))))
+		for _, name := range []string{"alice", "bob", "carol", "dave"} {
+			if delivery, _ := topic.Fetch(name); i == 1 {
+				first[name] = delivery
+			}
+		}
+	}
+	assert.NoError(t, topic.PutMessage(json.RawMessage("test_3")))
+
+	// the oldest unread message is dropped for the requeued one.
+	ok, err := topic.Requeue("alice", first["alice"].ID)
+	assert.True(t, ok)
+	assert.NoError(t, err)
+	delivery, _ := topic.Fetch("alice")
+	assert.Equal(t, Delivery{ID: 1, Data: json.RawMessage("test_1"), Dropped: 1}, delivery)
+
+	ok, err = topic.Requeue("bob", first["bob"].ID)
+	assert.True(t, ok)
+	assert.Equal(t, ErrQueueFull, err)
+
+	ok, err = topic.Requeue("carol", first["carol"].ID)
+	assert.True(t, ok)
+	assert.NoError(t, err)
+	delivery, _ = topic.Fetch("carol")
+	assert.Equal(t, Delivery{ID: 3, Data: json.RawMessage("test_3"), Dropped: 1}, delivery)
+
+	ok, err = topic.Requeue("dave", first["dave"].ID)
+	assert.False(t, ok)
+	assert.NoError(t, err)
+
+	info := topic.SubscribersInfo()
+	assert.Equal(t, 3, len(info))
+	for _, sub := range info {
+		assert.True(t, sub.Pending <= 1, sub.Name)
+	}
+}
+
+func TestTopic_RequeueInFlightLimit(t *testing.T) {
+	topic := NewTopic()
+	topic.Subscribe("alice")
+	for i := 0; i <= InFlightLimit; i++ {
+		assert.NoError(t, topic.PutMessage(json.RawMessage(`1`)))
+		topic.Fetch("alice")
+	}
+
+	_, err := topic.Requeue("alice", 1)
+	assert.Equal(t, ErrUnknownMessage, err)
+	_, err = topic.Requeue("alice", 2)
+	assert.NoError(t, err)
+}
@@ -0,0 +1,103 @@
+package server
+
+import (
+	"errors"
+	"strings"
+
+	"github.com/sheb-gregor/polly-demo/mq"
+)
+
+var (
+	errAccessDenied = errors.New("access to the topic is denied")
+	errNotOwner     = errors.New("subscription belongs to another client")
+)
+
+// ACL is a list of rules which grant access to topics, the access is
+// not restricted if the list is empty, otherwise everything not granted is denied.
+type ACL []ACLRule
+
+// ACLRule grants the principal access to the topics matching the patterns.
+// The principal, namespace and the patterns may contain `*` wildcard which matches any sequence of characters.
+type ACLRule struct {
+	Principal string `json:"principal" yaml:"principal"`
+	// Namespace is the pattern of namespaces the rule applies to, empty means any namespace.
+	Namespace string `json:"namespace" yaml:"namespace"`
+	// Publish is the patterns of topics the principal may publish to.
+	Publish []string `json:"publish" yaml:"publish"`
+	// Subscribe is the patterns of topics the principal may subscribe to, poll and unsubscribe from.
+	Subscribe []string `json:"subscribe" yaml:"subscribe"`
+}
+
+// CanPublish checks if the principal is allowed to publish to the topic of the namespace.
+func (acl ACL) CanPublish(principal, namespace, topic string) bool {
+	return acl.allowed(principal, namespace, topic, func(rule ACLRule) []string { return rule.Publish })
+}
+
+// CanSubscribe checks if the principal is allowed to subscribe to the topic of the namespace.
+func (acl ACL) CanSubscribe(principal, namespace, topic string) bool {
+	return acl.allowed(principal, namespace, topic, func(rule ACLRule) []string { return rule.Subscribe })
+}
+
+// CanView checks if the principal is allowed to see the topic statistics.
+func (acl ACL) CanView(principal, namespace, topic string) bool {
+	return acl.CanPublish(principal, namespace, topic) || acl.CanSubscribe(principal, namespace, topic)
+}
+
+func (acl ACL) allowed(principal, namespace, topic string, patterns func(rule ACLRule) []string) bool {
+	if len(acl) == 0 {
+		return true
+	}
+
+	for _, rule := range acl {
+		if !matchWildcard(rule.Principal, principal) {
+			continue
+		}
+		if rule.Namespace != "" && !matchWildcard(rule.Namespace, namespace) {
+			continue
+		}
+		for _, pattern := range patterns(rule) {
+			if matchWildcard(pattern, topic) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// checkSubscriber verifies that the principal may subscribe to the topic
+// and the subscription does not belong to another principal.
+func checkSubscriber(ns *mq.Namespace, acl ACL, principal string, req PollReq) error {
+	if !acl.CanSubscribe(principal, ns.Name(), req.Topic) {
+		return errAccessDenied
+	}
+
+	owner, ok := ns.Owner(req.Topic, req.Subscriber)
+	if ok && owner != "" && owner != principal {
+		return errNotOwner
+	}
+	return nil
+}
+
+// matchWildcard reports whether the value matches the pattern where `*` matches any sequence of characters.
+func matchWildcard(pattern, value string) bool {
+	parts := strings.Split(pattern, "*")
+	if len(parts) == 1 {
+		return pattern == value
+	}
+
+	if !strings.HasPrefix(value, parts[0]) {
+		return false
+	}
+	value = value[len(parts[0]):]
+
+	last := parts[len(parts)-1]
+	for _, part := range parts[1 : len(parts)-1] {
+		idx := strings.Index(value, part)
+		if idx < 0 {
+			return false
+		}
+		value = value[idx+len(part):]
+	}
+
+	return len(value) >= len(last) && strings.HasSuffix(value, last)
+}
@@ -0,0 +1,54 @@
+package server
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestMatchWildcard(t *testing.T) {
+	cases := []struct {
+		pattern string
+		value   string
+		match   bool
+	}{
+		{"orders", "orders", true},
+		{"orders", "orders.eu", false},
+		{"*", "", true},
+		{"*", "orders/eu", true},
+		{"orders.*", "orders.eu", true},
+		{"orders.*", "orders.", true},
+		{"orders.*", "payments.eu", false},
+		{"*.eu", "orders.eu", true},
+		{"*.eu", "orders.us", false},
+		{"o*s.*.e*", "orders.x.eu", true},
+		{"a*a", "a", false},
+		{"a*a", "aa", true},
+	}
+
+	for _, c := range cases {
+		assert.Equal(t, c.match, matchWildcard(c.pattern, c.value), c.pattern+" ~ "+c.value)
+	}
+}
+
+func TestACL(t *testing.T) {
+	assert.True(t, ACL{}.CanPublish("alice", "default", "orders"))
+
+	acl := ACL{
+		{Principal: "alice", Publish: []string{"orders.*"}, Subscribe: []string{"payments"}},
+		{Principal: "*", Subscribe: []string{"public.*"}},
+		{Principal: "bob", Namespace: "team-b", Publish: []string{"*"}},
+	}
+
+	assert.True(t, acl.CanPublish("alice", "default", "orders.eu"))
+	assert.True(t, acl.CanPublish("alice", "team-b", "orders.eu"))
+	assert.False(t, acl.CanPublish("alice", "default", "payments"))
+	assert.True(t, acl.CanSubscribe("alice", "default", "payments"))
+	assert.True(t, acl.CanSubscribe("alice", "default", "public.news"))
+	assert.True(t, acl.CanView("alice", "default", "orders.eu"))
+
+	assert.False(t, acl.CanPublish("bob", "default", "orders.eu"))
+	assert.True(t, acl.CanPublish("bob", "team-b", "orders.eu"))
+	assert.True(t, acl.CanSubscribe("bob", "default", "public.news"))
+	assert.False(t, acl.CanView("bob", "default", "payments"))
+}
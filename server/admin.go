@@ -0,0 +1,100 @@
+package server
+
+import (
+	"crypto/subtle"
+	"net/http"
+	"strings"
+
+	"github.com/go-chi/chi"
+	"github.com/sheb-gregor/polly-demo/metrics"
+	"github.com/sheb-gregor/polly-demo/mq"
+)
+
+// operatorRoutes registers the health probes, metrics and admin endpoints.
+// If they are `shared` with the main API, the metrics require the admin token,
+// because they expose the names of all namespaces, topics and subscribers.
+func operatorRoutes(
+	broker *mq.Broker, cfg AdminConfig, collector *metrics.Collector, limiter *rateLimiter, shared bool,
+) func(r chi.Router) {
+	return func(r chi.Router) {
+		r.Group(healthRoutes(broker))
+		if shared {
+			r.With(adminAuth(cfg)).Get("/metrics", collector.ServeHTTP)
+		} else {
+			r.Get("/metrics", collector.ServeHTTP)
+		}
+		r.Route("/admin", adminRoutes(broker, cfg, limiter))
+	}
+}
+
+// adminRoutes registers the endpoints for the broker operators.
+func adminRoutes(broker *mq.Broker, cfg AdminConfig, limiter *rateLimiter) func(r chi.Router) {
+	return func(r chi.Router) {
+		r.Use(adminAuth(cfg))
+
+		r.Get("/memory", func(w http.ResponseWriter, r *http.Request) {
+			writeSuccess(w, broker.MemoryUsage())
+		})
+
+		r.Get("/namespaces", func(w http.ResponseWriter, r *http.Request) {
+			writeSuccess(w, broker.Namespaces())
+		})
+
+		r.Get("/rate_limits", func(w http.ResponseWriter, r *http.Request) {
+			writeSuccess(w, limiter.info())
+		})
+
+		r.Group(adminTopicRoutes(broker))
+		r.Route("/ns/{namespace}", adminTopicRoutes(broker))
+	}
+}
+
+// adminTopicRoutes registers the admin operations with topics of the namespace.
+func adminTopicRoutes(broker *mq.Broker) func(r chi.Router) {
+	return func(r chi.Router) {
+		r.Post("/topics/{topic}/purge", func(w http.ResponseWriter, r *http.Request) {
+			if !requestNamespace(broker, r).Purge(urlParam(r, "topic")) {
+				writeError(w, ErrTopicNotFound)
+				return
+			}
+			writeSuccess(w, StatusMsg{Message: http.StatusText(http.StatusOK)})
+		})
+
+		r.Delete("/topics/{topic}", func(w http.ResponseWriter, r *http.Request) {
+			if !requestNamespace(broker, r).DeleteTopic(urlParam(r, "topic")) {
+				writeError(w, ErrTopicNotFound)
+				return
+			}
+			writeSuccess(w, StatusMsg{Message: http.StatusText(http.StatusOK)})
+		})
+
+		r.Post("/topics/{topic}/subscribers/{subscriber}/reset", func(w http.ResponseWriter, r *http.Request) {
+			ns := requestNamespace(broker, r)
+			if !ns.ResetSubscriber(urlParam(r, "topic"), urlParam(r, "subscriber")) {
+				writeError(w, ErrNotSubscribed)
+				return
+			}
+			writeSuccess(w, StatusMsg{Message: http.StatusText(http.StatusOK)})
+		})
+	}
+}
+
+// adminAuth checks the admin token in the `Authorization: Bearer <token>` header.
+func adminAuth(cfg AdminConfig) func(next http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if cfg.Token == "" {
+				writeError(w, newError(http.StatusForbidden, CodeAdminDisabled, "admin endpoints are disabled"))
+				return
+			}
+
+			token := strings.TrimPrefix(r.Header.Get("Authorization"), "Bearer ")
+			if subtle.ConstantTimeCompare([]byte(token), []byte(cfg.Token)) != 1 {
+				writeError(w, newError(http.StatusUnauthorized, CodeUnauthorized, "invalid admin token"))
+				return
+			}
+
+			next.ServeHTTP(w, r)
+		})
+	}
+}
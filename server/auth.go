@@ -0,0 +1,183 @@
+package server
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/hex"
+	"errors"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	// HeaderKey is the name of the client which signed the request.
+	HeaderKey = "X-Polly-Key"
+	// HeaderTimestamp is the unix time of the request signing.
+	HeaderTimestamp = "X-Polly-Timestamp"
+	// HeaderSignature is the hex encoded HMAC-SHA256 of the request, see SignRequest.
+	HeaderSignature = "X-Polly-Signature"
+)
+
+// DefaultMaxClockSkew is the max difference between the signature timestamp and the server time.
+const DefaultMaxClockSkew = 5 * time.Minute
+
+type principalKey struct{}
+
+type namespaceKey struct{}
+
+// PrincipalFromContext returns the name of the authenticated client.
+func PrincipalFromContext(ctx context.Context) (string, bool) {
+	principal, ok := ctx.Value(principalKey{}).(string)
+	return principal, ok
+}
+
+// WithPrincipal returns a copy of the context with the name of the authenticated client.
+func WithPrincipal(ctx context.Context, principal string) context.Context {
+	return context.WithValue(ctx, principalKey{}, principal)
+}
+
+// NamespaceFromContext returns the namespace of the authenticated client.
+func NamespaceFromContext(ctx context.Context) (string, bool) {
+	namespace, ok := ctx.Value(namespaceKey{}).(string)
+	return namespace, ok
+}
+
+// WithNamespace returns a copy of the context with the namespace of the authenticated client.
+func WithNamespace(ctx context.Context, namespace string) context.Context {
+	return context.WithValue(ctx, namespaceKey{}, namespace)
+}
+
+// SignRequest computes the HMAC-SHA256 signature of the request:
+// `hex(hmac(secret, method + "\n" + requestURI + "\n" + timestamp + "\n" + hex(sha256(body))))`.
+func SignRequest(secret, method, requestURI, timestamp string, body []byte) string {
+	bodyHash := sha256.Sum256(body)
+
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(method + "\n" + requestURI + "\n" + timestamp + "\n" + hex.EncodeToString(bodyHash[:])))
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// authenticate checks the bearer token, the HMAC signature or the verified client certificate
+// of the request and puts the name of the client into the request context.
+// The credentials take precedence over the certificate.
+// The requests are not checked if there are no configured clients and no client certificate.
+// The signed body is read before the handler, so it is limited by `maxBodyBytes`.
+func authenticate(cfg AuthConfig, maxBodyBytes int64) func(next http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			client, verified := cfg.certificateClient(r)
+			hasCredentials := r.Header.Get(HeaderSignature) != "" || r.Header.Get("Authorization") != ""
+			if !verified && len(cfg.Clients) == 0 {
+				next.ServeHTTP(w, r)
+				return
+			}
+
+			var err error
+			switch {
+			case len(cfg.Clients) == 0 || verified && !hasCredentials:
+				// the client is authenticated by the certificate.
+			case r.Header.Get(HeaderSignature) != "":
+				client, err = cfg.checkSignature(r, maxBodyBytes)
+			default:
+				client, err = cfg.checkToken(r)
+			}
+
+			if err == errPayloadTooLarge {
+				writeError(w, err)
+				return
+			}
+			if err != nil {
+				w.Header().Set("WWW-Authenticate", `Bearer realm="polly"`)
+				writeError(w, newError(http.StatusUnauthorized, CodeUnauthorized, err.Error()))
+				return
+			}
+
+			ctx := WithPrincipal(r.Context(), client.Name)
+			if client.Namespace != "" {
+				ctx = WithNamespace(ctx, client.Namespace)
+			}
+			next.ServeHTTP(w, r.WithContext(ctx))
+		})
+	}
+}
+
+func (cfg AuthConfig) checkToken(r *http.Request) (ClientConfig, error) {
+	header := r.Header.Get("Authorization")
+	if !strings.HasPrefix(header, "Bearer ") {
+		return ClientConfig{}, errors.New("authorization is required")
+	}
+
+	token := []byte(strings.TrimPrefix(header, "Bearer "))
+	for _, client := range cfg.Clients {
+		if client.Token != "" && subtle.ConstantTimeCompare(token, []byte(client.Token)) == 1 {
+			return client, nil
+		}
+	}
+	return ClientConfig{}, errors.New("invalid token")
+}
+
+func (cfg AuthConfig) checkSignature(r *http.Request, maxBodyBytes int64) (ClientConfig, error) {
+	client, ok := cfg.client(r.Header.Get(HeaderKey))
+	if !ok || client.Secret == "" {
+		return ClientConfig{}, errors.New("unknown key")
+	}
+
+	timestamp := r.Header.Get(HeaderTimestamp)
+	unix, err := strconv.ParseInt(timestamp, 10, 64)
+	if err != nil {
+		return ClientConfig{}, errors.New("invalid timestamp")
+	}
+
+	skew := time.Since(time.Unix(unix, 0))
+	if skew < 0 {
+		skew = -skew
+	}
+	if skew > cfg.maxClockSkew() {
+		return ClientConfig{}, errors.New("timestamp is out of the allowed range")
+	}
+
+	var body []byte
+	if r.Body != nil {
+		if maxBodyBytes <= 0 {
+			maxBodyBytes = DefaultMaxBodyBytes
+		}
+		// the body is limited before the signature is verified, so it can not exhaust the memory.
+		body, err = ioutil.ReadAll(io.LimitReader(r.Body, maxBodyBytes+1))
+		if err != nil {
+			return ClientConfig{}, errors.New("unable to read body")
+		}
+		if int64(len(body)) > maxBodyBytes {
+			return ClientConfig{}, errPayloadTooLarge
+		}
+		r.Body = ioutil.NopCloser(bytes.NewReader(body))
+	}
+
+	expected := SignRequest(client.Secret, r.Method, r.URL.RequestURI(), timestamp, body)
+	if !hmac.Equal([]byte(expected), []byte(r.Header.Get(HeaderSignature))) {
+		return ClientConfig{}, errors.New("invalid signature")
+	}
+	return client, nil
+}
+
+func (cfg AuthConfig) client(name string) (ClientConfig, bool) {
+	for _, client := range cfg.Clients {
+		if client.Name == name {
+			return client, true
+		}
+	}
+	return ClientConfig{}, false
+}
+
+func (cfg AuthConfig) maxClockSkew() time.Duration {
+	if cfg.MaxClockSkew <= 0 {
+		return DefaultMaxClockSkew
+	}
+	return cfg.MaxClockSkew
+}
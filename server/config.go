@@ -0,0 +1,99 @@
+package server
+
+import (
+	"errors"
+	"time"
+
+	"github.com/lancer-kit/uwe/v2/presets/api"
+)
+
+// Config is a parameters of the Polly HTTP API.
+type Config struct {
+	Admin AdminConfig `json:"admin" yaml:"admin"`
+	Auth  AuthConfig  `json:"auth" yaml:"auth"`
+	ACL   ACL         `json:"acl" yaml:"acl"`
+
+	RateLimit RateLimitConfig `json:"rate_limit" yaml:"rate_limit"`
+	// TLS is the certificates of the API listener.
+	TLS TLSConfig `json:"tls" yaml:"tls"`
+	// Idempotency is the deduplication of the publish requests with the `Idempotency-Key` header.
+	Idempotency IdempotencyConfig `json:"idempotency" yaml:"idempotency"`
+	// MaxPollWait is the limit of the `wait` parameter of the long poll, default is DefaultMaxPollWait.
+	MaxPollWait time.Duration `json:"max_poll_wait" yaml:"max_poll_wait"`
+	// Stream is the parameters of the server-sent events stream of the messages.
+	Stream StreamConfig `json:"stream" yaml:"stream"`
+	// MaxBodyBytes is the limit of the request body size, default is DefaultMaxBodyBytes.
+	MaxBodyBytes int64 `json:"max_body_bytes" yaml:"max_body_bytes"`
+}
+
+// Validate checks the correctness of the configuration.
+func (cfg Config) Validate() error {
+	if err := cfg.Auth.validate(cfg.TLS.ClientCAFile != ""); err != nil {
+		return err
+	}
+	if err := cfg.TLS.Validate(); err != nil {
+		return err
+	}
+	return cfg.RateLimit.Validate()
+}
+
+// AdminConfig is a parameters of the admin endpoints.
+type AdminConfig struct {
+	// Token is the credential expected in the `Authorization: Bearer <token>` header,
+	// the admin endpoints are disabled if it is empty.
+	Token string `json:"token" yaml:"token"`
+	// Listener is the address of the separate admin API with the health probes,
+	// metrics and admin endpoints. They are served by the main API if it is not set.
+	Listener *api.Config `json:"listener" yaml:"listener"`
+}
+
+// AuthConfig is a list of clients allowed to use the API,
+// the authentication is disabled if it is empty.
+type AuthConfig struct {
+	Clients []ClientConfig `json:"clients" yaml:"clients"`
+	// MaxClockSkew is the max age of the HMAC signature, default is DefaultMaxClockSkew.
+	MaxClockSkew time.Duration `json:"max_clock_skew" yaml:"max_clock_skew"`
+}
+
+// Validate checks the correctness of the configuration.
+func (cfg AuthConfig) Validate() error {
+	return cfg.validate(false)
+}
+
+// validate checks the configuration, the clients without a token and secret
+// are allowed only if they can be authenticated by the client certificate.
+func (cfg AuthConfig) validate(withCertificates bool) error {
+	names := map[string]bool{}
+	for _, client := range cfg.Clients {
+		if client.Name == "" {
+			return errors.New("client name should not be empty")
+		}
+		if client.Token == "" && client.Secret == "" && !withCertificates {
+			return errors.New("client " + client.Name + " should have token or secret")
+		}
+		if client.RateLimit != nil {
+			if err := client.RateLimit.Validate(); err != nil {
+				return errors.New("client " + client.Name + " rate limit: " + err.Error())
+			}
+		}
+		if names[client.Name] {
+			return errors.New("client " + client.Name + " is duplicated")
+		}
+		names[client.Name] = true
+	}
+	return nil
+}
+
+// ClientConfig is the credentials of the client.
+type ClientConfig struct {
+	// Name is the identity of the client (principal).
+	Name string `json:"name" yaml:"name"`
+	// Token is expected in the `Authorization: Bearer <token>` header.
+	Token string `json:"token" yaml:"token"`
+	// Secret is the key of the HMAC request signature sent with the `X-Polly-*` headers.
+	Secret string `json:"secret" yaml:"secret"`
+	// Namespace is used for requests without the `/ns/{namespace}` URL prefix, default is mq.DefaultNamespace.
+	Namespace string `json:"namespace" yaml:"namespace"`
+	// RateLimit overrides the default rate limits of the client.
+	RateLimit *RateLimits `json:"rate_limit" yaml:"rate_limit"`
+}
@@ -0,0 +1,125 @@
+package server
+
+import (
+	"encoding/json"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"time"
+
+	"github.com/sheb-gregor/polly-demo/mq"
+)
+
+// DefaultMaxBodyBytes is the default limit of the request body size.
+const DefaultMaxBodyBytes = 1 << 20
+
+// DefaultMaxPollWait is the default limit of the long poll duration.
+const DefaultMaxPollWait = 30 * time.Second
+
+// ErrorCode is the machine-readable reason of the failed request.
+type ErrorCode string
+
+// Codes of the API errors.
+const (
+	CodeInvalidJSON        ErrorCode = "invalid_json"
+	CodeTopicRequired      ErrorCode = "topic_required"
+	CodeSubscriberRequired ErrorCode = "subscriber_required"
+	CodeDataRequired       ErrorCode = "data_required"
+	CodeInvalidOptions     ErrorCode = "invalid_options"
+	CodeInvalidParameter   ErrorCode = "invalid_parameter"
+	CodeUnauthorized       ErrorCode = "unauthorized"
+	CodeAccessDenied       ErrorCode = "access_denied"
+	CodeNotOwner           ErrorCode = "not_owner"
+	CodeAdminDisabled      ErrorCode = "admin_disabled"
+	CodeNotFound           ErrorCode = "not_found"
+	CodeNotSubscribed      ErrorCode = "not_subscribed"
+	CodeTopicNotFound      ErrorCode = "topic_not_found"
+	CodeMessageNotFound    ErrorCode = "message_not_found"
+	CodeMethodNotAllowed   ErrorCode = "method_not_allowed"
+	CodePayloadTooLarge    ErrorCode = "payload_too_large"
+	CodeRateLimited        ErrorCode = "rate_limited"
+	CodeQueueFull          ErrorCode = "queue_full"
+	CodeTopicMemoryLimit   ErrorCode = "topic_memory_limit"
+	CodeMemoryLimit        ErrorCode = "memory_limit"
+	CodeUnavailable        ErrorCode = "unavailable"
+	CodeInternal           ErrorCode = "internal"
+)
+
+// Error is the response of the failed request.
+type Error struct {
+	// Status is the HTTP status code of the response.
+	Status  int       `json:"-"`
+	Code    ErrorCode `json:"code"`
+	Message string    `json:"message"`
+}
+
+func (e *Error) Error() string {
+	return e.Message
+}
+
+func newError(status int, code ErrorCode, message string) *Error {
+	return &Error{Status: status, Code: code, Message: message}
+}
+
+var (
+	// ErrNotSubscribed is returned when the subscription of the request is not found.
+	ErrNotSubscribed = newError(http.StatusNotFound, CodeNotSubscribed, "subscription is not found")
+	// ErrTopicNotFound is returned when the topic of the request is not found.
+	ErrTopicNotFound = newError(http.StatusNotFound, CodeTopicNotFound, "topic is not found")
+)
+
+var (
+	errTopicRequired      = newError(http.StatusBadRequest, CodeTopicRequired, "topic should not be empty")
+	errSubscriberRequired = newError(http.StatusBadRequest, CodeSubscriberRequired, "subscriber should not be empty")
+	errDataRequired       = newError(http.StatusBadRequest, CodeDataRequired, "data should not be empty")
+	errNotFound           = newError(http.StatusNotFound, CodeNotFound, http.StatusText(http.StatusNotFound))
+	errMethodNotAllowed   = newError(http.StatusMethodNotAllowed, CodeMethodNotAllowed,
+		http.StatusText(http.StatusMethodNotAllowed))
+	errPayloadTooLarge = newError(http.StatusRequestEntityTooLarge, CodePayloadTooLarge,
+		"request body is too large")
+)
+
+// APIError converts the error to the API error returned by the server, unknown errors are internal.
+func APIError(err error) *Error {
+	switch err {
+	case errAccessDenied:
+		return newError(http.StatusForbidden, CodeAccessDenied, err.Error())
+	case errNotOwner:
+		return newError(http.StatusForbidden, CodeNotOwner, err.Error())
+	case errRateLimited:
+		return newError(http.StatusTooManyRequests, CodeRateLimited, err.Error())
+	case mq.ErrUnknownMessage:
+		return newError(http.StatusNotFound, CodeMessageNotFound, err.Error())
+	case mq.ErrQueueFull:
+		return newError(http.StatusTooManyRequests, CodeQueueFull, err.Error())
+	case mq.ErrTopicMemoryLimit:
+		return newError(http.StatusTooManyRequests, CodeTopicMemoryLimit, err.Error())
+	case mq.ErrMemoryLimit, mq.ErrNamespaceMemoryLimit:
+		return newError(http.StatusInsufficientStorage, CodeMemoryLimit, err.Error())
+	}
+
+	if e, ok := err.(*Error); ok {
+		return e
+	}
+	return newError(http.StatusInternalServerError, CodeInternal, err.Error())
+}
+
+// decodeBody reads the JSON request body which should not be larger than `limit` bytes.
+func decodeBody(r *http.Request, limit int64, dest interface{}) error {
+	if limit <= 0 {
+		limit = DefaultMaxBodyBytes
+	}
+
+	raw, err := ioutil.ReadAll(io.LimitReader(r.Body, limit+1))
+	if err != nil {
+		return newError(http.StatusBadRequest, CodeInvalidJSON, err.Error())
+	}
+	if int64(len(raw)) > limit {
+		return errPayloadTooLarge
+	}
+
+	if err := json.Unmarshal(raw, dest); err != nil {
+		return newError(http.StatusBadRequest, CodeInvalidJSON, err.Error())
+	}
+	return nil
+}
@@ -0,0 +1,50 @@
+package server
+
+import (
+	"errors"
+	"net/http"
+	"time"
+
+	"github.com/go-chi/chi"
+	"github.com/sheb-gregor/polly-demo/mq"
+)
+
+// LivenessTimeout is the max time the broker should respond to the liveness probe.
+const LivenessTimeout = time.Second
+
+// healthRoutes registers the liveness and readiness probes.
+func healthRoutes(broker *mq.Broker) func(r chi.Router) {
+	return func(r chi.Router) {
+		r.Get("/healthz", func(w http.ResponseWriter, r *http.Request) {
+			if err := checkAlive(broker, LivenessTimeout); err != nil {
+				writeError(w, newError(http.StatusServiceUnavailable, CodeUnavailable, err.Error()))
+				return
+			}
+			writeSuccess(w, StatusMsg{Message: http.StatusText(http.StatusOK)})
+		})
+
+		r.Get("/readyz", func(w http.ResponseWriter, r *http.Request) {
+			if err := broker.Ready(); err != nil {
+				writeError(w, newError(http.StatusServiceUnavailable, CodeUnavailable, err.Error()))
+				return
+			}
+			writeSuccess(w, StatusMsg{Message: http.StatusText(http.StatusOK)})
+		})
+	}
+}
+
+// checkAlive makes sure that locks of the broker topics can be acquired in time.
+func checkAlive(broker *mq.Broker, timeout time.Duration) error {
+	done := make(chan struct{})
+	go func() {
+		broker.MemoryUsage()
+		close(done)
+	}()
+
+	select {
+	case <-done:
+		return nil
+	case <-time.After(timeout):
+		return errors.New("broker does not respond")
+	}
+}
@@ -0,0 +1,111 @@
+package server
+
+import (
+	"net/http"
+	"sync"
+	"time"
+)
+
+// HeaderIdempotencyKey is the unique key of the publish request, the retries with the same key
+// are acknowledged without publishing the message again.
+const HeaderIdempotencyKey = "Idempotency-Key"
+
+// headerReplayed marks the response to the request which was already handled.
+const headerReplayed = "Idempotent-Replayed"
+
+// DefaultIdempotencyTTL is the default time the idempotency keys are remembered.
+const DefaultIdempotencyTTL = 5 * time.Minute
+
+// IdempotencyConfig is the parameters of the deduplication of the publish requests.
+type IdempotencyConfig struct {
+	// TTL is how long the idempotency key is remembered after the message is published,
+	// default is DefaultIdempotencyTTL.
+	TTL time.Duration `json:"ttl" yaml:"ttl"`
+}
+
+// idempotencyEntry is the state of the request with the key.
+type idempotencyEntry struct {
+	// done is closed when the first request is handled.
+	done    chan struct{}
+	err     error
+	expires time.Time
+}
+
+// idempotencyKeys remembers the handled publish requests by the client and the key.
+type idempotencyKeys struct {
+	sync.Mutex
+	ttl     time.Duration
+	entries map[string]*idempotencyEntry
+	pruned  time.Time
+}
+
+func newIdempotencyKeys(cfg IdempotencyConfig) *idempotencyKeys {
+	ttl := cfg.TTL
+	if ttl <= 0 {
+		ttl = DefaultIdempotencyTTL
+	}
+	return &idempotencyKeys{ttl: ttl, entries: map[string]*idempotencyEntry{}}
+}
+
+// do calls `handle` once for the key while it is remembered. The concurrent requests
+// with the same key wait for the first one. The failed request is forgotten, so it can be retried.
+// It returns true if the request is a duplicate.
+func (keys *idempotencyKeys) do(key string, handle func() error) (bool, error) {
+	now := time.Now()
+
+	keys.Lock()
+	if now.Sub(keys.pruned) > keys.ttl {
+		keys.prune(now)
+	}
+
+	entry, ok := keys.entries[key]
+	if ok && (entry.expires.IsZero() || now.Before(entry.expires)) {
+		keys.Unlock()
+		<-entry.done
+		return true, entry.err
+	}
+
+	entry = &idempotencyEntry{done: make(chan struct{})}
+	keys.entries[key] = entry
+	keys.Unlock()
+
+	err := handle()
+
+	keys.Lock()
+	if err != nil {
+		delete(keys.entries, key)
+	} else {
+		entry.expires = time.Now().Add(keys.ttl)
+	}
+	entry.err = err
+	close(entry.done)
+	keys.Unlock()
+
+	return false, err
+}
+
+// prune removes the expired keys, the requests in progress are kept.
+func (keys *idempotencyKeys) prune(now time.Time) {
+	for key, entry := range keys.entries {
+		if !entry.expires.IsZero() && !now.Before(entry.expires) {
+			delete(keys.entries, key)
+		}
+	}
+	keys.pruned = now
+}
+
+// publishOnce publishes the message once for the idempotency key of the request,
+// the requests without the key are always published.
+func publishOnce(w http.ResponseWriter, r *http.Request, keys *idempotencyKeys, namespace string,
+	publish func() error) error {
+	key := r.Header.Get(HeaderIdempotencyKey)
+	if key == "" {
+		return publish()
+	}
+
+	duplicate, err := keys.do(clientKey(r)+"/"+namespace+"/"+key, publish)
+	if duplicate && err == nil {
+		w.Header().Set(headerReplayed, "true")
+	}
+	return err
+}
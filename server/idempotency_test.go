@@ -0,0 +1,43 @@
+package server
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestIdempotencyKeys(t *testing.T) {
+	keys := newIdempotencyKeys(IdempotencyConfig{TTL: time.Minute})
+
+	var published int
+	publish := func() error {
+		published++
+		return nil
+	}
+
+	duplicate, err := keys.do("alice/default/1", publish)
+	assert.NoError(t, err)
+	assert.False(t, duplicate)
+	duplicate, err = keys.do("alice/default/1", publish)
+	assert.NoError(t, err)
+	assert.True(t, duplicate)
+	_, err = keys.do("bob/default/1", publish)
+	assert.NoError(t, err)
+	assert.Equal(t, 2, published)
+
+	failed := errors.New("queue is full")
+	duplicate, err = keys.do("alice/default/2", func() error { return failed })
+	assert.Equal(t, failed, err)
+	assert.False(t, duplicate)
+	duplicate, err = keys.do("alice/default/2", publish)
+	assert.NoError(t, err)
+	assert.False(t, duplicate)
+	assert.Equal(t, 3, published)
+
+	keys.prune(time.Now().Add(2 * time.Minute))
+	assert.Empty(t, keys.entries)
+	duplicate, _ = keys.do("alice/default/1", publish)
+	assert.False(t, duplicate)
+}
@@ -0,0 +1,522 @@
+package server
+
+import (
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// object is a node of the OpenAPI document.
+type object = map[string]interface{}
+
+// operation describes the endpoint in the OpenAPI document.
+type operation struct {
+	method  string
+	path    string
+	tag     string
+	summary string
+	// params are the query and header parameters, the path parameters are taken from the path.
+	params []object
+	// body is the schema of the JSON request body.
+	body object
+	// optionalBody marks the request body as not required.
+	optionalBody bool
+	// result is the schema of the successful response, `text/plain` if nil.
+	result object
+	// resultType is the media type of the result, default is `application/json`.
+	resultType string
+	// errors are the statuses of the error responses.
+	errors []int
+	// security is the names of the security schemes, no authentication if empty.
+	security []string
+}
+
+var (
+	apiSecurity   = []string{"bearerAuth", "hmacAuth"}
+	adminSecurity = []string{"adminToken"}
+)
+
+// idempotencyKeyParam is the optional key of the publish request, the retries with the same key
+// do not publish the message again.
+var idempotencyKeyParam = object{
+	"in":          "header",
+	"name":        HeaderIdempotencyKey,
+	"required":    false,
+	"description": "Unique key of the message, the requests with the same key are published once.",
+	"schema":      object{"type": "string"},
+}
+
+// waitParam is the max time of waiting for a new message by the long poll.
+var waitParam = object{
+	"in":   "query",
+	"name": "wait",
+	"description": "Max time of waiting for a new message, e.g. `30s`, limited by the server. " +
+		"The response is returned immediately if it is not set.",
+	"schema": object{"type": "string"},
+}
+
+// lastEventIDParam is the identifier of the last message received by the previous stream.
+var lastEventIDParam = object{
+	"in":   "header",
+	"name": "Last-Event-ID",
+	"description": "Identifier of the last received message, the messages sent after it by the previous stream " +
+		"are delivered again.",
+	"required": false,
+	"schema":   object{"type": "string"},
+}
+
+// streamResult is the server-sent events stream of the messages.
+var streamResult = object{
+	"type": "string",
+	"description": "Server-sent events: `message` events with the `Message` JSON in the data " +
+		"and the message identifier in the `id` field, and the `error` event with the `Error` JSON " +
+		"if the subscription is removed.",
+}
+
+// apiOperations are the endpoints served at the root and with the `/ns/{namespace}` prefix.
+var apiOperations = []operation{
+	{
+		method: http.MethodPost, path: "/publish", tag: "pubsub",
+		summary: "Publish the message to the topic",
+		params:  []object{idempotencyKeyParam},
+		body:    ref("Message"), result: ref("StatusMsg"),
+		errors: []int{400, 401, 403, 413, 429, 507},
+	},
+	{
+		method: http.MethodPost, path: "/subscribe", tag: "pubsub",
+		summary: "Subscribe to the topic or update the subscription options",
+		body:    ref("SubscribeReq"), result: ref("StatusMsg"),
+		errors: []int{400, 401, 403, 413},
+	},
+	{
+		method: http.MethodPost, path: "/unsubscribe", tag: "pubsub",
+		summary: "Remove the subscription",
+		body:    ref("PollReq"), result: ref("StatusMsg"),
+		errors: []int{400, 401, 403, 413},
+	},
+	{
+		method: http.MethodGet, path: "/poll", tag: "pubsub",
+		summary: "Receive the next unread message of the subscriber",
+		params: []object{
+			param("query", "topic", "Name of the topic."),
+			param("query", "subscriber", "Name of the subscriber."),
+			waitParam,
+		},
+		result: ref("Message"),
+		errors: []int{400, 401, 403, 404, 429},
+	},
+	{
+		method: http.MethodGet, path: "/stream", tag: "pubsub",
+		summary: "Receive the messages of the subscriber as server-sent events",
+		params: []object{
+			param("query", "topic", "Name of the topic."),
+			param("query", "subscriber", "Name of the subscriber."),
+			lastEventIDParam,
+		},
+		result: streamResult, resultType: "text/event-stream",
+		errors: []int{400, 401, 403, 404, 429},
+	},
+	{
+		method: http.MethodPost, path: "/nack", tag: "pubsub",
+		summary: "Return the polled message to the front of the subscriber queue",
+		body:    ref("NackReq"), result: ref("StatusMsg"),
+		errors: []int{400, 401, 403, 404, 413, 429, 507},
+	},
+	{
+		method: http.MethodPost, path: "/topics/{topic}/messages", tag: "pubsub",
+		summary: "Publish the message, the body is the message data",
+		params:  []object{idempotencyKeyParam},
+		body:    object{"description": "Any JSON value."}, result: ref("StatusMsg"),
+		errors: []int{400, 401, 403, 413, 429, 507},
+	},
+	{
+		method: http.MethodPut, path: "/topics/{topic}/subscriptions/{subscriber}", tag: "pubsub",
+		summary: "Subscribe to the topic or update the subscription options",
+		body:    ref("SubscribeOptions"), optionalBody: true, result: ref("StatusMsg"),
+		errors: []int{400, 401, 403, 413},
+	},
+	{
+		method: http.MethodDelete, path: "/topics/{topic}/subscriptions/{subscriber}", tag: "pubsub",
+		summary: "Remove the subscription",
+		result:  ref("StatusMsg"),
+		errors:  []int{400, 401, 403},
+	},
+	{
+		method: http.MethodGet, path: "/topics/{topic}/subscriptions/{subscriber}/messages", tag: "pubsub",
+		summary: "Receive the next unread message of the subscriber",
+		params:  []object{waitParam},
+		result:  ref("Message"),
+		errors:  []int{400, 401, 403, 404, 429},
+	},
+	{
+		method: http.MethodGet, path: "/topics/{topic}/subscriptions/{subscriber}/stream", tag: "pubsub",
+		summary: "Receive the messages of the subscriber as server-sent events",
+		params:  []object{lastEventIDParam},
+		result:  streamResult, resultType: "text/event-stream",
+		errors: []int{400, 401, 403, 404, 429},
+	},
+	{
+		method: http.MethodPost, path: "/topics/{topic}/subscriptions/{subscriber}/nack", tag: "pubsub",
+		summary: "Return the polled message to the front of the subscriber queue",
+		body: properties([]string{"id"}, object{
+			"id": typed("integer", "Identifier of the polled message."),
+		}),
+		result: ref("StatusMsg"),
+		errors: []int{400, 401, 403, 404, 413, 429, 507},
+	},
+	{
+		method: http.MethodGet, path: "/topics", tag: "introspection",
+		summary: "Statistics of the topics visible to the client",
+		result:  arrayOf(ref("TopicInfo")),
+		errors:  []int{401},
+	},
+	{
+		method: http.MethodGet, path: "/topics/{topic}", tag: "introspection",
+		summary: "Statistics of the topic",
+		result:  ref("TopicInfo"),
+		errors:  []int{401, 403, 404},
+	},
+	{
+		method: http.MethodGet, path: "/topics/{topic}/subscribers", tag: "introspection",
+		summary: "Statistics of the topic subscribers",
+		result:  arrayOf(ref("SubscriberInfo")),
+		errors:  []int{401, 403, 404},
+	},
+}
+
+// rootOperations are the endpoints served only at the root.
+var rootOperations = []operation{
+	{
+		method: http.MethodGet, path: "/rate_limits", tag: "introspection",
+		summary: "Rate limiter counters of the client",
+		result:  ref("OperationsStats"), errors: []int{401}, security: apiSecurity,
+	},
+	{
+		method: http.MethodGet, path: "/openapi.json", tag: "operator",
+		summary: "This document",
+		result:  object{"type": "object"},
+	},
+	{
+		method: http.MethodGet, path: "/healthz", tag: "operator",
+		summary: "Liveness probe",
+		result:  ref("StatusMsg"), errors: []int{503},
+	},
+	{
+		method: http.MethodGet, path: "/readyz", tag: "operator",
+		summary: "Readiness probe",
+		result:  ref("StatusMsg"), errors: []int{503},
+	},
+	{
+		method: http.MethodGet, path: "/metrics", tag: "operator",
+		summary: "Metrics in the Prometheus text format, the admin token is required on the main API",
+		errors:  []int{401, 403}, security: adminSecurity,
+	},
+	{
+		method: http.MethodGet, path: "/admin/memory", tag: "admin",
+		summary: "Memory usage of the broker, namespaces and topics",
+		result:  ref("BrokerMemoryStats"), errors: []int{401, 403}, security: adminSecurity,
+	},
+	{
+		method: http.MethodGet, path: "/admin/namespaces", tag: "admin",
+		summary: "Statistics of the namespaces",
+		result:  arrayOf(ref("NamespaceInfo")), errors: []int{401, 403}, security: adminSecurity,
+	},
+	{
+		method: http.MethodGet, path: "/admin/rate_limits", tag: "admin",
+		summary: "Rate limiter counters of all clients and topics",
+		result:  ref("RateLimitsInfo"), errors: []int{401, 403}, security: adminSecurity,
+	},
+}
+
+// adminTopicOperations are the admin endpoints served with the `/admin` and `/admin/ns/{namespace}` prefixes.
+var adminTopicOperations = []operation{
+	{
+		method: http.MethodPost, path: "/topics/{topic}/purge", tag: "admin",
+		summary: "Delete all messages of the topic, subscriptions are kept",
+		result:  ref("StatusMsg"), errors: []int{401, 403, 404}, security: adminSecurity,
+	},
+	{
+		method: http.MethodDelete, path: "/topics/{topic}", tag: "admin",
+		summary: "Delete the topic with all messages and subscriptions",
+		result:  ref("StatusMsg"), errors: []int{401, 403, 404}, security: adminSecurity,
+	},
+	{
+		method: http.MethodPost, path: "/topics/{topic}/subscribers/{subscriber}/reset", tag: "admin",
+		summary: "Clear the queue of the subscriber",
+		result:  ref("StatusMsg"), errors: []int{401, 403, 404}, security: adminSecurity,
+	},
+}
+
+// openAPIOperations returns all endpoints of the API with the prefixed variants.
+func openAPIOperations() []operation {
+	var operations []operation
+	for _, prefix := range []string{"", "/ns/{namespace}"} {
+		for _, op := range apiOperations {
+			op.path = prefix + op.path
+			op.security = apiSecurity
+			operations = append(operations, op)
+		}
+	}
+
+	operations = append(operations, rootOperations...)
+	for _, prefix := range []string{"/admin", "/admin/ns/{namespace}"} {
+		for _, op := range adminTopicOperations {
+			op.path = prefix + op.path
+			operations = append(operations, op)
+		}
+	}
+	return operations
+}
+
+// OpenAPI returns the OpenAPI 3 document of the Polly HTTP API.
+func OpenAPI() object {
+	paths := object{}
+	for _, op := range openAPIOperations() {
+		item, ok := paths[op.path].(object)
+		if !ok {
+			item = object{}
+			paths[op.path] = item
+		}
+		item[strings.ToLower(op.method)] = op.spec()
+	}
+
+	return object{
+		"openapi": "3.0.3",
+		"info": object{
+			"title":       "Polly",
+			"description": "Pub/Sub message broker with polling subscribers.",
+			"version":     "1.0.0",
+		},
+		"paths": paths,
+		"components": object{
+			"schemas": openAPISchemas,
+			"securitySchemes": object{
+				"bearerAuth": object{"type": "http", "scheme": "bearer",
+					"description": "Token of the client from `server.auth.clients`."},
+				"hmacAuth": object{"type": "apiKey", "in": "header", "name": HeaderSignature,
+					"description": "HMAC-SHA256 signature of the request with the `" +
+						HeaderKey + "` and `" + HeaderTimestamp + "` headers."},
+				"adminToken": object{"type": "http", "scheme": "bearer",
+					"description": "Token from `server.admin.token`."},
+			},
+		},
+	}
+}
+
+func (op operation) spec() object {
+	spec := object{
+		"tags":        []string{op.tag},
+		"summary":     op.summary,
+		"operationId": operationID(op.method, op.path),
+	}
+
+	params := append([]object{}, op.params...)
+	for _, segment := range strings.Split(op.path, "/") {
+		if strings.HasPrefix(segment, "{") && strings.HasSuffix(segment, "}") {
+			name := strings.Trim(segment, "{}")
+			params = append(params, param("path", name, "URL-escaped name of the "+name+"."))
+		}
+	}
+	if len(params) > 0 {
+		spec["parameters"] = params
+	}
+
+	if op.body != nil {
+		spec["requestBody"] = object{
+			"required": !op.optionalBody,
+			"content":  object{"application/json": object{"schema": op.body}},
+		}
+	}
+
+	responses := object{}
+	if op.result != nil {
+		resultType := op.resultType
+		if resultType == "" {
+			resultType = "application/json"
+		}
+		responses["200"] = object{
+			"description": "Success.",
+			"content":     object{resultType: object{"schema": op.result}},
+		}
+	} else {
+		responses["200"] = object{
+			"description": "Success.",
+			"content":     object{"text/plain": object{"schema": object{"type": "string"}}},
+		}
+	}
+	for _, status := range op.errors {
+		responses[strconv.Itoa(status)] = object{
+			"description": http.StatusText(status) + ".",
+			"content":     object{"application/json": object{"schema": ref("Error")}},
+		}
+	}
+	spec["responses"] = responses
+
+	if len(op.security) > 0 {
+		security := make([]object, 0, len(op.security))
+		for _, name := range op.security {
+			security = append(security, object{name: []string{}})
+		}
+		spec["security"] = security
+	}
+	return spec
+}
+
+// operationID builds the unique identifier of the operation, e.g. `get_topics_topic_subscribers`.
+func operationID(method, path string) string {
+	parts := []string{strings.ToLower(method)}
+	for _, segment := range strings.Split(path, "/") {
+		segment = strings.Trim(segment, "{}")
+		segment = strings.Replace(segment, ".", "_", -1)
+		if segment != "" {
+			parts = append(parts, segment)
+		}
+	}
+	return strings.Join(parts, "_")
+}
+
+func ref(name string) object {
+	return object{"$ref": "#/components/schemas/" + name}
+}
+
+func arrayOf(items object) object {
+	return object{"type": "array", "items": items}
+}
+
+func param(in, name, description string) object {
+	return object{
+		"in":          in,
+		"name":        name,
+		"required":    true,
+		"description": description,
+		"schema":      object{"type": "string"},
+	}
+}
+
+func properties(required []string, props object) object {
+	schema := object{"type": "object", "properties": props}
+	if len(required) > 0 {
+		schema["required"] = required
+	}
+	return schema
+}
+
+func typed(kind, description string) object {
+	schema := object{"type": kind}
+	if description != "" {
+		schema["description"] = description
+	}
+	return schema
+}
+
+func errorCodes() []string {
+	codes := []string{
+		string(CodeInvalidJSON), string(CodeTopicRequired), string(CodeSubscriberRequired),
+		string(CodeDataRequired), string(CodeInvalidOptions), string(CodeInvalidParameter), string(CodeUnauthorized),
+		string(CodeAccessDenied), string(CodeNotOwner), string(CodeAdminDisabled),
+		string(CodeNotFound), string(CodeNotSubscribed), string(CodeTopicNotFound), string(CodeMessageNotFound),
+		string(CodeMethodNotAllowed), string(CodePayloadTooLarge), string(CodeRateLimited),
+		string(CodeQueueFull), string(CodeTopicMemoryLimit), string(CodeMemoryLimit),
+		string(CodeUnavailable), string(CodeInternal),
+	}
+	sort.Strings(codes)
+	return codes
+}
+
+var (
+	subscribeOptions = object{
+		"max_pending": typed("integer", "Limit of unread messages, 0 means no limit."),
+		"overflow": object{
+			"type":        "string",
+			"enum":        []string{"drop_oldest", "drop_newest", "unsubscribe", "reject"},
+			"description": "Policy applied when the queue is full, default is `drop_oldest`.",
+		},
+	}
+	rateLimitStats = properties(nil, object{
+		"allowed": typed("integer", ""),
+		"limited": typed("integer", ""),
+	})
+	memoryStats = properties(nil, object{
+		"used":  typed("integer", "Bytes held by messages."),
+		"limit": typed("integer", "Limit of bytes, 0 means no limit."),
+	})
+)
+
+var openAPISchemas = object{
+	"Message": properties([]string{"topic"}, object{
+		"id":    typed("integer", "Identifier of the polled message."),
+		"topic": typed("string", ""),
+		"data": object{"description": "Any JSON value, required to publish " +
+			"and absent in the poll response if there are no unread messages."},
+		"dropped": typed("integer", "Count of messages lost by the subscriber since the previous poll."),
+	}),
+	"PollReq": properties([]string{"topic", "subscriber"}, object{
+		"topic":      typed("string", ""),
+		"subscriber": typed("string", ""),
+	}),
+	"NackReq": properties([]string{"topic", "subscriber", "id"}, object{
+		"topic":      typed("string", ""),
+		"subscriber": typed("string", ""),
+		"id":         typed("integer", "Identifier of the polled message."),
+	}),
+	"SubscribeOptions": properties(nil, subscribeOptions),
+	"SubscribeReq": properties([]string{"topic", "subscriber"}, object{
+		"topic":       typed("string", ""),
+		"subscriber":  typed("string", ""),
+		"max_pending": subscribeOptions["max_pending"],
+		"overflow":    subscribeOptions["overflow"],
+	}),
+	"StatusMsg": properties([]string{"message"}, object{
+		"message": typed("string", ""),
+	}),
+	"Error": properties([]string{"code", "message"}, object{
+		"code":    object{"type": "string", "enum": errorCodes()},
+		"message": typed("string", ""),
+	}),
+	"TopicInfo": properties(nil, object{
+		"name":               typed("string", ""),
+		"subscribers":        typed("integer", ""),
+		"messages":           typed("integer", "Count of stored messages not yet received by all subscribers."),
+		"bytes":              typed("integer", "Size of stored messages."),
+		"last_id":            typed("integer", "Identifier of the last published message."),
+		"oldest_pending_age": typed("integer", "Age of the oldest stored message in nanoseconds."),
+	}),
+	"SubscriberInfo": properties(nil, object{
+		"name":        typed("string", ""),
+		"pending":     typed("integer", "Count of unread messages."),
+		"dropped":     typed("integer", "Count of messages lost since the last poll."),
+		"max_pending": subscribeOptions["max_pending"],
+		"overflow":    subscribeOptions["overflow"],
+		"owner":       typed("string", "Client which created the subscription."),
+		"last_poll":   object{"type": "string", "format": "date-time"},
+	}),
+	"NamespaceInfo": properties(nil, object{
+		"name":        typed("string", ""),
+		"topics":      typed("integer", ""),
+		"subscribers": typed("integer", ""),
+		"messages":    typed("integer", ""),
+		"memory":      memoryStats,
+	}),
+	"MemoryStats": memoryStats,
+	"NamespaceMemoryStats": properties(nil, object{
+		"used":   typed("integer", ""),
+		"limit":  typed("integer", ""),
+		"topics": object{"type": "object", "additionalProperties": ref("MemoryStats")},
+	}),
+	"BrokerMemoryStats": properties(nil, object{
+		"used":       typed("integer", ""),
+		"limit":      typed("integer", ""),
+		"policy":     object{"type": "string", "enum": []string{"reject", "drop_oldest", "block"}},
+		"namespaces": object{"type": "object", "additionalProperties": ref("NamespaceMemoryStats")},
+	}),
+	"RateLimitStats": rateLimitStats,
+	"OperationsStats": properties(nil, object{
+		"publish": ref("RateLimitStats"),
+		"poll":    ref("RateLimitStats"),
+	}),
+	"RateLimitsInfo": properties(nil, object{
+		"clients": object{"type": "object", "additionalProperties": ref("OperationsStats")},
+		"topics":  object{"type": "object", "additionalProperties": ref("OperationsStats")},
+	}),
+}
@@ -0,0 +1,212 @@
+package server
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"sort"
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/go-chi/chi"
+	"github.com/sheb-gregor/polly-demo/mq"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestOpenAPI_Routes(t *testing.T) {
+	handler := NewHandler(mq.NewBroker(), Config{})
+
+	routes, ok := handler.(chi.Routes)
+	assert.True(t, ok)
+
+	var registered []string
+	err := chi.Walk(routes, func(method, route string, _ http.Handler, _ ...func(http.Handler) http.Handler) error {
+		registered = append(registered, method+" "+strings.Replace(route, "/*/", "/", -1))
+		return nil
+	})
+	assert.NoError(t, err)
+
+	rec := httptest.NewRecorder()
+	handler.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/openapi.json", nil))
+	assert.Equal(t, http.StatusOK, rec.Code)
+
+	spec := struct {
+		OpenAPI string                                `json:"openapi"`
+		Paths   map[string]map[string]json.RawMessage `json:"paths"`
+	}{}
+	assert.NoError(t, json.NewDecoder(rec.Body).Decode(&spec))
+	assert.Equal(t, "3.0.3", spec.OpenAPI)
+
+	var documented []string
+	for path, item := range spec.Paths {
+		for method := range item {
+			documented = append(documented, strings.ToUpper(method)+" "+path)
+		}
+	}
+
+	sort.Strings(registered)
+	sort.Strings(documented)
+	assert.Equal(t, registered, documented)
+}
+
+func TestOpenAPI_Refs(t *testing.T) {
+	raw, err := json.Marshal(OpenAPI())
+	assert.NoError(t, err)
+
+	ids := map[string]bool{}
+	for _, op := range openAPIOperations() {
+		id := operationID(op.method, op.path)
+		assert.False(t, ids[id], id)
+		ids[id] = true
+	}
+
+	for _, part := range strings.Split(string(raw), `"#/components/schemas/`)[1:] {
+		name := part[:strings.Index(part, `"`)]
+		_, ok := openAPISchemas[name]
+		assert.True(t, ok, name)
+	}
+}
+
+func TestOpenAPI_Responses(t *testing.T) {
+	broker := mq.NewBroker()
+	broker.Subscribe("orders", "bob")
+	handler := NewHandler(broker, Config{Admin: AdminConfig{Token: "secret"}})
+
+	requests := []struct {
+		method string
+		path   string
+		body   string
+		status int
+	}{
+		{http.MethodPost, "/subscribe", `{"topic":"orders","subscriber":"alice","max_pending":10}`, 200},
+		{http.MethodGet, "/poll?topic=orders&subscriber=alice", "", 200},
+		{http.MethodPost, "/publish", `{"topic":"orders","data":{"id":1}}`, 200},
+		{http.MethodPost, "/topics/orders/messages", `{"id":2}`, 200},
+		{http.MethodGet, "/poll?topic=orders&subscriber=alice", "", 200},
+		{http.MethodGet, "/topics/orders/subscriptions/alice/messages", "", 200},
+		{http.MethodPost, "/nack", `{"topic":"orders","subscriber":"alice","id":2}`, 200},
+		{http.MethodGet, "/poll?topic=orders&subscriber=carol", "", 404},
+		{http.MethodPost, "/publish", `{"topic":"orders"}`, 400},
+		{http.MethodGet, "/topics", "", 200},
+		{http.MethodGet, "/topics/orders", "", 200},
+		{http.MethodGet, "/topics/orders/subscribers", "", 200},
+		{http.MethodGet, "/topics/unknown", "", 404},
+		{http.MethodGet, "/ns/team-a/topics", "", 200},
+		{http.MethodGet, "/rate_limits", "", 200},
+		{http.MethodGet, "/healthz", "", 200},
+		{http.MethodGet, "/readyz", "", 200},
+		{http.MethodGet, "/admin/memory", "", 200},
+		{http.MethodGet, "/admin/namespaces", "", 200},
+		{http.MethodGet, "/admin/rate_limits", "", 200},
+		{http.MethodPost, "/admin/topics/orders/purge", "", 200},
+		{http.MethodDelete, "/admin/topics/unknown", "", 404},
+		{http.MethodDelete, "/topics/orders/subscriptions/alice", "", 200},
+	}
+
+	for _, req := range requests {
+		name := req.method + " " + req.path
+		op, ok := findOperation(req.method, req.path)
+		if !assert.True(t, ok, name) {
+			continue
+		}
+
+		httpReq := httptest.NewRequest(req.method, req.path, strings.NewReader(req.body))
+		if strings.HasPrefix(req.path, "/admin") {
+			httpReq.Header.Set("Authorization", "Bearer secret")
+		}
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, httpReq)
+		if !assert.Equal(t, req.status, rec.Code, name) {
+			continue
+		}
+
+		schema := op.result
+		if rec.Code != http.StatusOK {
+			assert.Contains(t, op.errors, rec.Code, name)
+			schema = ref("Error")
+		}
+
+		decoder := json.NewDecoder(rec.Body)
+		decoder.UseNumber()
+		var value interface{}
+		assert.NoError(t, decoder.Decode(&value), name)
+		validateSchema(t, schema, value, name)
+	}
+}
+
+// findOperation returns the documented operation of the request.
+func findOperation(method, target string) (operation, bool) {
+	path := strings.Split(strings.SplitN(target, "?", 2)[0], "/")
+	for _, op := range openAPIOperations() {
+		pattern := strings.Split(op.path, "/")
+		if op.method != method || len(pattern) != len(path) {
+			continue
+		}
+
+		matched := true
+		for i, segment := range pattern {
+			if segment != path[i] && !strings.HasPrefix(segment, "{") {
+				matched = false
+				break
+			}
+		}
+		if matched {
+			return op, true
+		}
+	}
+	return operation{}, false
+}
+
+// validateSchema checks that the decoded JSON value matches the schema of the OpenAPI document.
+func validateSchema(t *testing.T, schema object, value interface{}, path string) {
+	if name, ok := schema["$ref"].(string); ok {
+		schema, _ = openAPISchemas[strings.TrimPrefix(name, "#/components/schemas/")].(object)
+	}
+
+	switch schema["type"] {
+	case "object":
+		fields, ok := value.(map[string]interface{})
+		if !assert.True(t, ok, "%s is not an object", path) {
+			return
+		}
+
+		required, _ := schema["required"].([]string)
+		for _, name := range required {
+			assert.Contains(t, fields, name, "%s.%s is required", path, name)
+		}
+
+		props, _ := schema["properties"].(object)
+		additional, _ := schema["additionalProperties"].(object)
+		for name, field := range fields {
+			switch prop, ok := props[name].(object); {
+			case ok:
+				validateSchema(t, prop, field, path+"."+name)
+			case additional != nil:
+				validateSchema(t, additional, field, path+"."+name)
+			case props != nil:
+				t.Errorf("%s.%s is not documented", path, name)
+			}
+		}
+	case "array":
+		items, ok := value.([]interface{})
+		if !assert.True(t, ok, "%s is not an array", path) {
+			return
+		}
+		for i, item := range items {
+			validateSchema(t, schema["items"].(object), item, path+"["+strconv.Itoa(i)+"]")
+		}
+	case "string":
+		str, ok := value.(string)
+		assert.True(t, ok, "%s is not a string", path)
+		if enum, ok := schema["enum"].([]string); ok {
+			assert.Contains(t, enum, str, path)
+		}
+	case "integer":
+		number, ok := value.(json.Number)
+		if assert.True(t, ok, "%s is not a number", path) {
+			_, err := number.Int64()
+			assert.NoError(t, err, "%s is not an integer", path)
+		}
+	}
+}
@@ -0,0 +1,350 @@
+package server
+
+import (
+	"errors"
+	"math"
+	"net"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/sheb-gregor/polly-demo/metrics"
+)
+
+// Operations and scopes of the rate limits.
+const (
+	OperationPublish = "publish"
+	OperationPoll    = "poll"
+
+	ScopeClient = "client"
+	ScopeTopic  = "topic"
+)
+
+// pruneInterval is how often the buckets refilled to the full burst are removed with their counters.
+const pruneInterval = time.Minute
+
+var errRateLimited = errors.New("rate limit exceeded")
+
+// RateLimitConfig is the token bucket limits of requests per client and per topic.
+type RateLimitConfig struct {
+	// Client is the limits of each client, the authenticated clients are identified by the name,
+	// other ones by the remote address.
+	Client RateLimits `json:"client" yaml:"client"`
+	// Topic is the limits of each topic shared by all clients.
+	Topic RateLimits `json:"topic" yaml:"topic"`
+}
+
+// Validate checks the correctness of the configuration.
+func (cfg RateLimitConfig) Validate() error {
+	if err := cfg.Client.Validate(); err != nil {
+		return err
+	}
+	return cfg.Topic.Validate()
+}
+
+// RateLimits is the limits of the publish and poll requests.
+type RateLimits struct {
+	Publish Rate `json:"publish" yaml:"publish"`
+	Poll    Rate `json:"poll" yaml:"poll"`
+}
+
+// Validate checks the correctness of the configuration.
+func (limits RateLimits) Validate() error {
+	if err := limits.Publish.Validate(); err != nil {
+		return errors.New("publish: " + err.Error())
+	}
+	if err := limits.Poll.Validate(); err != nil {
+		return errors.New("poll: " + err.Error())
+	}
+	return nil
+}
+
+func (limits RateLimits) rate(operation string) Rate {
+	if operation == OperationPublish {
+		return limits.Publish
+	}
+	return limits.Poll
+}
+
+// Rate is the parameters of the token bucket.
+type Rate struct {
+	// PerSecond is the count of requests allowed per second, 0 means no limit.
+	PerSecond float64 `json:"per_second" yaml:"per_second"`
+	// Burst is the max count of requests allowed at once, default is PerSecond rounded up.
+	Burst int `json:"burst" yaml:"burst"`
+}
+
+// Validate checks the correctness of the configuration.
+func (rate Rate) Validate() error {
+	if rate.PerSecond < 0 {
+		return errors.New("per_second should not be negative")
+	}
+	if rate.Burst < 0 {
+		return errors.New("burst should not be negative")
+	}
+	return nil
+}
+
+func (rate Rate) burst() float64 {
+	if rate.Burst > 0 {
+		return float64(rate.Burst)
+	}
+	return math.Max(1, math.Ceil(rate.PerSecond))
+}
+
+// RateLimitStats is the counters of the requests checked by the rate limiter,
+// they are reset when the client or the topic is idle until its bucket is refilled.
+type RateLimitStats struct {
+	Allowed int64 `json:"allowed"`
+	Limited int64 `json:"limited"`
+}
+
+// OperationsStats is the rate limiter counters of the publish and poll requests.
+type OperationsStats struct {
+	Publish RateLimitStats `json:"publish"`
+	Poll    RateLimitStats `json:"poll"`
+}
+
+// RateLimitsInfo is the rate limiter counters of each client and topic,
+// the topics are named as `{namespace}/{topic}`.
+type RateLimitsInfo struct {
+	Clients map[string]OperationsStats `json:"clients"`
+	Topics  map[string]OperationsStats `json:"topics"`
+}
+
+// rateLimiter checks the requests against the limits of the client and the topic.
+type rateLimiter struct {
+	cfg RateLimitConfig
+	// clients is the limits of the clients which override the default ones.
+	clients map[string]RateLimits
+
+	// buckets is the token buckets by the scope and the operation.
+	buckets map[string]*tokenBuckets
+}
+
+func newRateLimiter(cfg Config) *rateLimiter {
+	limiter := &rateLimiter{
+		cfg:     cfg.RateLimit,
+		clients: map[string]RateLimits{},
+		buckets: map[string]*tokenBuckets{},
+	}
+
+	for _, client := range cfg.Auth.Clients {
+		if client.RateLimit != nil {
+			limiter.clients[client.Name] = *client.RateLimit
+		}
+	}
+
+	for _, scope := range []string{ScopeClient, ScopeTopic} {
+		for _, operation := range []string{OperationPublish, OperationPoll} {
+			limiter.buckets[scope+"/"+operation] = newTokenBuckets()
+		}
+	}
+	return limiter
+}
+
+// allow takes a token from the buckets of the client and the topic.
+// If the request is limited, it returns the scope of the exhausted bucket and the time to wait for a token.
+func (limiter *rateLimiter) allow(operation, client, topic string, now time.Time) (string, time.Duration) {
+	limits, ok := limiter.clients[client]
+	if !ok {
+		limits = limiter.cfg.Client
+	}
+
+	clientBuckets, clientRate := limiter.buckets[ScopeClient+"/"+operation], limits.rate(operation)
+	if wait := clientBuckets.take(client, clientRate, now); wait > 0 {
+		return ScopeClient, wait
+	}
+
+	rate := limiter.cfg.Topic.rate(operation)
+	if wait := limiter.buckets[ScopeTopic+"/"+operation].take(topic, rate, now); wait > 0 {
+		// the request limited by the topic does not spend the budget of the client.
+		clientBuckets.refund(client, clientRate)
+		return ScopeTopic, wait
+	}
+	return "", 0
+}
+
+// clientStats returns the counters of the client.
+func (limiter *rateLimiter) clientStats(client string) OperationsStats {
+	return OperationsStats{
+		Publish: limiter.buckets[ScopeClient+"/"+OperationPublish].stats(client),
+		Poll:    limiter.buckets[ScopeClient+"/"+OperationPoll].stats(client),
+	}
+}
+
+// info returns the counters of all limited clients and topics.
+func (limiter *rateLimiter) info() RateLimitsInfo {
+	collect := func(scope string) map[string]OperationsStats {
+		result := map[string]OperationsStats{}
+		for key, stats := range limiter.buckets[scope+"/"+OperationPublish].allStats() {
+			info := result[key]
+			info.Publish = stats
+			result[key] = info
+		}
+		for key, stats := range limiter.buckets[scope+"/"+OperationPoll].allStats() {
+			info := result[key]
+			info.Poll = stats
+			result[key] = info
+		}
+		return result
+	}
+
+	return RateLimitsInfo{Clients: collect(ScopeClient), Topics: collect(ScopeTopic)}
+}
+
+// bucket is the count of available tokens at the moment of the last update.
+type bucket struct {
+	rate    Rate
+	tokens  float64
+	updated time.Time
+}
+
+// refill adds the tokens accumulated since the last update.
+func (b *bucket) refill(now time.Time) {
+	if elapsed := now.Sub(b.updated); elapsed > 0 {
+		b.tokens = math.Min(b.rate.burst(), b.tokens+elapsed.Seconds()*b.rate.PerSecond)
+		b.updated = now
+	}
+}
+
+// tokenBuckets is a set of token buckets with the counters of requests by key.
+type tokenBuckets struct {
+	sync.Mutex
+	buckets  map[string]*bucket
+	counters map[string]*RateLimitStats
+	pruned   time.Time
+}
+
+func newTokenBuckets() *tokenBuckets {
+	return &tokenBuckets{
+		buckets:  map[string]*bucket{},
+		counters: map[string]*RateLimitStats{},
+	}
+}
+
+// take takes a token from the bucket of the key, if there are no tokens
+// it returns the time until the next one will be available. Nothing is limited if the rate is 0.
+func (tb *tokenBuckets) take(key string, rate Rate, now time.Time) time.Duration {
+	if rate.PerSecond <= 0 {
+		return 0
+	}
+
+	tb.Lock()
+	defer tb.Unlock()
+
+	if now.Sub(tb.pruned) > pruneInterval {
+		tb.prune(now)
+	}
+
+	b, ok := tb.buckets[key]
+	if !ok {
+		b = &bucket{rate: rate, tokens: rate.burst(), updated: now}
+		tb.buckets[key] = b
+	}
+	b.refill(now)
+
+	counter, ok := tb.counters[key]
+	if !ok {
+		counter = &RateLimitStats{}
+		tb.counters[key] = counter
+	}
+
+	if b.tokens >= 1 {
+		b.tokens -= 1
+		counter.Allowed += 1
+		return 0
+	}
+
+	counter.Limited += 1
+	return time.Duration((1 - b.tokens) / rate.PerSecond * float64(time.Second))
+}
+
+// refund returns the token taken for the request which is limited by another bucket.
+func (tb *tokenBuckets) refund(key string, rate Rate) {
+	if rate.PerSecond <= 0 {
+		return
+	}
+
+	tb.Lock()
+	defer tb.Unlock()
+
+	if b, ok := tb.buckets[key]; ok {
+		b.tokens = math.Min(rate.burst(), b.tokens+1)
+	}
+	if counter, ok := tb.counters[key]; ok && counter.Allowed > 0 {
+		counter.Allowed -= 1
+	}
+}
+
+// prune removes the buckets which are refilled to the full burst,
+// they are the same as the new ones, together with their counters.
+func (tb *tokenBuckets) prune(now time.Time) {
+	for key, b := range tb.buckets {
+		b.refill(now)
+		if b.tokens >= b.rate.burst() {
+			delete(tb.buckets, key)
+			delete(tb.counters, key)
+		}
+	}
+	tb.pruned = now
+}
+
+func (tb *tokenBuckets) stats(key string) RateLimitStats {
+	tb.Lock()
+	defer tb.Unlock()
+
+	if counter, ok := tb.counters[key]; ok {
+		return *counter
+	}
+	return RateLimitStats{}
+}
+
+func (tb *tokenBuckets) allStats() map[string]RateLimitStats {
+	tb.Lock()
+	defer tb.Unlock()
+
+	result := make(map[string]RateLimitStats, len(tb.counters))
+	for key, counter := range tb.counters {
+		result[key] = *counter
+	}
+	return result
+}
+
+// clientKey identifies the client by the authenticated name or by the remote address.
+func clientKey(r *http.Request) string {
+	if principal, ok := PrincipalFromContext(r.Context()); ok && principal != "" {
+		return principal
+	}
+
+	if host, _, err := net.SplitHostPort(r.RemoteAddr); err == nil {
+		return host
+	}
+	return r.RemoteAddr
+}
+
+// checkRateLimit takes a token for the request to the topic, if it is limited
+// it writes the response and returns false.
+func checkRateLimit(w http.ResponseWriter, r *http.Request, limiter *rateLimiter,
+	collector *metrics.Collector, operation, topic string) bool {
+	scope, wait := limiter.allow(operation, clientKey(r), topic, time.Now())
+	if wait == 0 {
+		return true
+	}
+
+	collector.RateLimited(scope, operation)
+	writeRateLimited(w, wait)
+	return false
+}
+
+// writeRateLimited responds with `429 Too Many Requests` and the `Retry-After` header in seconds.
+func writeRateLimited(w http.ResponseWriter, wait time.Duration) {
+	seconds := int64(math.Ceil(wait.Seconds()))
+	if seconds < 1 {
+		seconds = 1
+	}
+
+	w.Header().Set("Retry-After", strconv.FormatInt(seconds, 10))
+	writeError(w, errRateLimited)
+}
@@ -0,0 +1,91 @@
+package server
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestTokenBuckets(t *testing.T) {
+	buckets := newTokenBuckets()
+	rate := Rate{PerSecond: 2, Burst: 3}
+	now := time.Now()
+
+	for i := 0; i < 3; i++ {
+		assert.Equal(t, time.Duration(0), buckets.take("alice", rate, now))
+	}
+	assert.Equal(t, 500*time.Millisecond, buckets.take("alice", rate, now))
+	assert.Equal(t, time.Duration(0), buckets.take("bob", rate, now))
+
+	assert.Equal(t, 250*time.Millisecond, buckets.take("alice", rate, now.Add(250*time.Millisecond)))
+	assert.Equal(t, time.Duration(0), buckets.take("alice", rate, now.Add(500*time.Millisecond)))
+	assert.Equal(t, RateLimitStats{Allowed: 4, Limited: 2}, buckets.stats("alice"))
+
+	assert.Equal(t, time.Duration(0), buckets.take("carol", Rate{}, now))
+	assert.Equal(t, RateLimitStats{}, buckets.stats("carol"))
+
+	buckets.prune(now.Add(time.Hour))
+	assert.Empty(t, buckets.buckets)
+	assert.Empty(t, buckets.counters)
+	assert.Equal(t, RateLimitStats{}, buckets.stats("alice"))
+}
+
+func TestRateLimiter(t *testing.T) {
+	limiter := newRateLimiter(Config{
+		Auth: AuthConfig{Clients: []ClientConfig{
+			{Name: "alice", Token: "alice-token", RateLimit: &RateLimits{Publish: Rate{PerSecond: 10}}},
+		}},
+		RateLimit: RateLimitConfig{
+			Client: RateLimits{Publish: Rate{PerSecond: 1}},
+			Topic:  RateLimits{Poll: Rate{PerSecond: 1}},
+		},
+	})
+	now := time.Now()
+
+	scope, wait := limiter.allow(OperationPublish, "bob", "default/orders", now)
+	assert.Equal(t, "", scope)
+	assert.Zero(t, wait)
+	scope, wait = limiter.allow(OperationPublish, "bob", "default/orders", now)
+	assert.Equal(t, ScopeClient, scope)
+	assert.Equal(t, time.Second, wait)
+
+	for i := 0; i < 10; i++ {
+		_, wait = limiter.allow(OperationPublish, "alice", "default/orders", now)
+		assert.Zero(t, wait)
+	}
+
+	_, wait = limiter.allow(OperationPoll, "alice", "default/orders", now)
+	assert.Zero(t, wait)
+	scope, _ = limiter.allow(OperationPoll, "bob", "default/orders", now)
+	assert.Equal(t, ScopeTopic, scope)
+
+	info := limiter.info()
+	assert.Equal(t, RateLimitStats{Allowed: 1, Limited: 1}, info.Clients["bob"].Publish)
+	assert.Equal(t, RateLimitStats{Allowed: 10}, info.Clients["alice"].Publish)
+	assert.Equal(t, RateLimitStats{Allowed: 1, Limited: 1}, info.Topics["default/orders"].Poll)
+}
+
+func TestRateLimiter_TopicLimited(t *testing.T) {
+	limiter := newRateLimiter(Config{
+		RateLimit: RateLimitConfig{
+			Client: RateLimits{Publish: Rate{PerSecond: 1, Burst: 2}},
+			Topic:  RateLimits{Publish: Rate{PerSecond: 1}},
+		},
+	})
+	now := time.Now()
+
+	_, wait := limiter.allow(OperationPublish, "alice", "default/orders", now)
+	assert.Zero(t, wait)
+	for i := 0; i < 3; i++ {
+		scope, _ := limiter.allow(OperationPublish, "alice", "default/orders", now)
+		assert.Equal(t, ScopeTopic, scope)
+	}
+
+	// the requests rejected by the topic limit do not spend the client budget.
+	assert.Equal(t, RateLimitStats{Allowed: 1}, limiter.clientStats("alice").Publish)
+	_, wait = limiter.allow(OperationPublish, "alice", "default/payments", now)
+	assert.Zero(t, wait)
+	scope, _ := limiter.allow(OperationPublish, "alice", "default/payments", now)
+	assert.Equal(t, ScopeClient, scope)
+}
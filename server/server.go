@@ -1,28 +1,35 @@
 package server
 
 import (
+	"context"
 	"encoding/json"
-	"errors"
 	"log"
 	"net/http"
+	"net/url"
+	"time"
 
 	"github.com/go-chi/chi"
 	"github.com/go-chi/chi/middleware"
+	"github.com/sheb-gregor/polly-demo/metrics"
 	"github.com/sheb-gregor/polly-demo/mq"
 )
 
 type Message struct {
+	// ID is the identifier of the polled message, it is ignored on publish.
+	ID    int64           `json:"id,omitempty"`
 	Topic string          `json:"topic"`
 	Data  json.RawMessage `json:"data,omitempty"`
+	// Dropped is the count of messages lost by the subscriber because of the queue overflow.
+	Dropped int64 `json:"dropped,omitempty"`
 }
 
 func (msg Message) Validate() error {
 	if msg.Topic == "" {
-		return errors.New("topic should not be empty")
+		return errTopicRequired
 	}
 
 	if msg.Data == nil {
-		return errors.New("data should not be empty")
+		return errDataRequired
 	}
 	return nil
 }
@@ -34,11 +41,54 @@ type PollReq struct {
 
 func (msg PollReq) Validate() error {
 	if msg.Topic == "" {
-		return errors.New("topic should not be empty")
+		return errTopicRequired
 	}
 
 	if msg.Subscriber == "" {
-		return errors.New("subscriber should not be empty")
+		return errSubscriberRequired
+	}
+	return nil
+}
+
+type SubscribeReq struct {
+	PollReq
+	// MaxPending is a limit of unread messages for the subscriber, 0 means no limit.
+	MaxPending int `json:"max_pending,omitempty"`
+	// Overflow is the policy applied when the queue is full:
+	// `drop_oldest` (default), `drop_newest`, `unsubscribe` or `reject`.
+	Overflow mq.OverflowPolicy `json:"overflow,omitempty"`
+}
+
+func (msg SubscribeReq) Validate() error {
+	if err := msg.PollReq.Validate(); err != nil {
+		return err
+	}
+
+	if err := msg.Options().Validate(); err != nil {
+		return newError(http.StatusBadRequest, CodeInvalidOptions, err.Error())
+	}
+	return nil
+}
+
+func (msg SubscribeReq) Options() mq.SubscribeOptions {
+	return mq.SubscribeOptions{MaxPending: msg.MaxPending, Overflow: msg.Overflow}
+}
+
+// NackReq returns the polled message to the front of the subscriber queue.
+type NackReq struct {
+	PollReq
+	// ID is the identifier of the polled message, the message is stored again
+	// if it is already received by all subscribers.
+	ID int64 `json:"id"`
+}
+
+func (msg NackReq) Validate() error {
+	if err := msg.PollReq.Validate(); err != nil {
+		return err
+	}
+
+	if msg.ID <= 0 {
+		return newError(http.StatusBadRequest, CodeInvalidParameter, "id should be positive")
 	}
 	return nil
 }
@@ -48,90 +98,484 @@ type StatusMsg struct {
 }
 
 func GetServer(broker *mq.Broker) http.Handler {
+	return NewHandler(broker, Config{})
+}
+
+// NewHandler creates the Polly HTTP API for the broker together with
+// the health probes, metrics and admin endpoints.
+func NewHandler(broker *mq.Broker, cfg Config) http.Handler {
+	return apiHandler(broker, cfg, metrics.NewCollector(broker), newRateLimiter(cfg), true)
+}
+
+// NewHandlers creates the Polly HTTP API and, if `cfg.Admin.Listener` is set, the separate admin API
+// with the health probes, metrics and admin endpoints. Otherwise the admin handler is nil
+// and these endpoints are served by the main API.
+func NewHandlers(broker *mq.Broker, cfg Config) (http.Handler, http.Handler) {
+	collector := metrics.NewCollector(broker)
+	limiter := newRateLimiter(cfg)
+	if cfg.Admin.Listener == nil {
+		return apiHandler(broker, cfg, collector, limiter, true), nil
+	}
+
+	return apiHandler(broker, cfg, collector, limiter, false), adminHandler(broker, cfg, collector, limiter)
+}
+
+func adminHandler(broker *mq.Broker, cfg Config, collector *metrics.Collector, limiter *rateLimiter) http.Handler {
+	mux := chi.NewMux()
+	mux.NotFound(notFound)
+	mux.MethodNotAllowed(methodNotAllowed)
+
+	mux.Use(middleware.Recoverer)
+	mux.Use(collector.Middleware)
+	mux.Group(operatorRoutes(broker, cfg.Admin, collector, limiter, false))
+
+	return mux
+}
+
+func apiHandler(
+	broker *mq.Broker, cfg Config, collector *metrics.Collector, limiter *rateLimiter, withAdmin bool,
+) http.Handler {
 	mux := chi.NewMux()
+	mux.NotFound(notFound)
+	mux.MethodNotAllowed(methodNotAllowed)
 
 	mux.Use(middleware.Logger)
 	mux.Use(middleware.Recoverer)
+	mux.Use(collector.Middleware)
 
-	mux.Get("/poll", func(w http.ResponseWriter, r *http.Request) {
-		query := r.URL.Query()
-		req := PollReq{
-			Topic:      query.Get("topic"),
-			Subscriber: query.Get("subscriber"),
-		}
+	keys := newIdempotencyKeys(cfg.Idempotency)
+	windows := newStreamWindows(cfg.Stream)
+	mux.Group(func(r chi.Router) {
+		r.Use(authenticate(cfg.Auth, cfg.MaxBodyBytes))
+		r.Group(apiRoutes(broker, cfg, collector, limiter, keys, windows))
+		r.Route("/ns/{namespace}", func(r chi.Router) {
+			r.Use(restrictNamespace)
+			apiRoutes(broker, cfg, collector, limiter, keys, windows)(r)
+		})
+
+		r.Get("/rate_limits", func(w http.ResponseWriter, r *http.Request) {
+			writeSuccess(w, limiter.clientStats(clientKey(r)))
+		})
+	})
+
+	mux.Get("/openapi.json", func(w http.ResponseWriter, r *http.Request) {
+		writeSuccess(w, OpenAPI())
+	})
+
+	if withAdmin {
+		mux.Group(operatorRoutes(broker, cfg.Admin, collector, limiter, true))
+	} else {
+		mux.Group(healthRoutes(broker))
+	}
+
+	return mux
+}
+
+// apiRoutes registers the pub/sub and introspection endpoints of the namespace.
+func apiRoutes(broker *mq.Broker, cfg Config, collector *metrics.Collector, limiter *rateLimiter,
+	keys *idempotencyKeys, windows *streamWindows) func(mux chi.Router) {
+	acl := cfg.ACL
 
+	poll := func(w http.ResponseWriter, r *http.Request, req PollReq) {
+		ns := requestNamespace(broker, r)
 		if err := req.Validate(); err != nil {
 			writeError(w, err)
 			return
 		}
 
-		msg, subscribed := broker.Poll(req.Topic, req.Subscriber)
-		if !subscribed {
-			writeData(w, http.StatusNotFound, StatusMsg{Message: http.StatusText(http.StatusNotFound)})
+		principal, _ := PrincipalFromContext(r.Context())
+		if err := checkSubscriber(ns, acl, principal, req); err != nil {
+			writeError(w, err)
 			return
 		}
-		writeSuccess(w, Message{Topic: req.Topic, Data: msg})
-	})
 
-	mux.Post("/publish", func(w http.ResponseWriter, r *http.Request) {
-		req := Message{}
-		err := json.NewDecoder(r.Body).Decode(&req)
+		wait, err := pollWait(r, cfg.MaxPollWait)
 		if err != nil {
 			writeError(w, err)
 			return
 		}
+
+		if !checkRateLimit(w, r, limiter, collector, OperationPoll, ns.Name()+"/"+req.Topic) {
+			return
+		}
+
+		ctx, cancel := context.WithTimeout(r.Context(), wait)
+		defer cancel()
+
+		start := time.Now()
+		delivery, subscribed := ns.FetchWait(req.Topic, req.Subscriber, ctx.Done())
+		if delivery.Data != nil && r.Context().Err() != nil {
+			// the client is gone while waiting, so the message is returned to the queue.
+			_, _ = ns.Requeue(req.Topic, req.Subscriber, delivery.ID)
+			return
+		}
+
+		if !subscribed {
+			writeError(w, ErrNotSubscribed)
+			return
+		}
+		collector.Polled(ns.Name(), req.Topic, delivery.Data != nil, time.Since(start))
+		writeSuccess(w, Message{ID: delivery.ID, Topic: req.Topic, Data: delivery.Data, Dropped: delivery.Dropped})
+	}
+
+	stream := func(w http.ResponseWriter, r *http.Request, req PollReq) {
+		ns := requestNamespace(broker, r)
 		if err := req.Validate(); err != nil {
 			writeError(w, err)
 			return
 		}
 
-		broker.HandleNewMessage(req.Topic, req.Data)
-		writeSuccess(w, StatusMsg{Message: http.StatusText(http.StatusOK)})
-	})
+		principal, _ := PrincipalFromContext(r.Context())
+		if err := checkSubscriber(ns, acl, principal, req); err != nil {
+			writeError(w, err)
+			return
+		}
+
+		// the error can not be returned with the status after the stream is started.
+		if _, ok := ns.Owner(req.Topic, req.Subscriber); !ok {
+			writeError(w, ErrNotSubscribed)
+			return
+		}
+
+		if !checkRateLimit(w, r, limiter, collector, OperationPoll, ns.Name()+"/"+req.Topic) {
+			return
+		}
+
+		key := ns.Name() + "/" + req.Topic + "/" + req.Subscriber
+		resumeStream(ns, req, r, windows.take(key, time.Now()))
+
+		sent := writeStream(w, r, ns, req, cfg.Stream, func(delivery mq.Delivery, wait time.Duration) {
+			collector.Polled(ns.Name(), req.Topic, true, wait)
+		})
+		windows.store(key, lastDeliveries(sent, cfg.Stream.resumeWindow()), time.Now())
+	}
+
+	nack := func(w http.ResponseWriter, r *http.Request, req NackReq) {
+		ns := requestNamespace(broker, r)
+		if err := req.Validate(); err != nil {
+			writeError(w, err)
+			return
+		}
+
+		principal, _ := PrincipalFromContext(r.Context())
+		if err := checkSubscriber(ns, acl, principal, req.PollReq); err != nil {
+			writeError(w, err)
+			return
+		}
 
-	mux.Post("/subscribe", func(w http.ResponseWriter, r *http.Request) {
-		req := PollReq{}
-		err := json.NewDecoder(r.Body).Decode(&req)
+		subscribed, err := ns.Requeue(req.Topic, req.Subscriber, req.ID)
+		if !subscribed {
+			writeError(w, ErrNotSubscribed)
+			return
+		}
 		if err != nil {
 			writeError(w, err)
 			return
 		}
+		writeSuccess(w, StatusMsg{Message: http.StatusText(http.StatusOK)})
+	}
+
+	publish := func(w http.ResponseWriter, r *http.Request, req Message) {
+		ns := requestNamespace(broker, r)
 		if err := req.Validate(); err != nil {
 			writeError(w, err)
 			return
 		}
 
-		broker.Subscribe(req.Topic, req.Subscriber)
-		writeSuccess(w, StatusMsg{Message: http.StatusText(http.StatusOK)})
-	})
+		principal, _ := PrincipalFromContext(r.Context())
+		if !acl.CanPublish(principal, ns.Name(), req.Topic) {
+			writeError(w, errAccessDenied)
+			return
+		}
 
-	mux.Post("/unsubscribe", func(w http.ResponseWriter, r *http.Request) {
-		req := PollReq{}
-		err := json.NewDecoder(r.Body).Decode(&req)
+		if !checkRateLimit(w, r, limiter, collector, OperationPublish, ns.Name()+"/"+req.Topic) {
+			return
+		}
+
+		err := publishOnce(w, r, keys, ns.Name(), func() error {
+			if err := ns.HandleNewMessage(req.Topic, req.Data); err != nil {
+				return err
+			}
+			// the message to the topic without subscribers is not stored, so it is not counted.
+			if ns.HasTopic(req.Topic) {
+				collector.Published(ns.Name(), req.Topic)
+			}
+			return nil
+		})
 		if err != nil {
 			writeError(w, err)
 			return
 		}
+		writeSuccess(w, StatusMsg{Message: http.StatusText(http.StatusOK)})
+	}
+
+	subscribe := func(w http.ResponseWriter, r *http.Request, req SubscribeReq) {
+		ns := requestNamespace(broker, r)
 		if err := req.Validate(); err != nil {
 			writeError(w, err)
 			return
 		}
 
-		broker.Unsubscribe(req.Topic, req.Subscriber)
+		principal, _ := PrincipalFromContext(r.Context())
+		if err := checkSubscriber(ns, acl, principal, req.PollReq); err != nil {
+			writeError(w, err)
+			return
+		}
+
+		opts := req.Options()
+		if req.MaxPending == 0 && req.Overflow == "" {
+			opts = ns.SubscriptionDefaults()
+		}
+		opts.Owner = principal
+		// the namespace is created by the first subscription.
+		broker.GetNamespace(ns.Name()).SubscribeWithOptions(req.Topic, req.Subscriber, opts)
+		writeSuccess(w, StatusMsg{Message: http.StatusText(http.StatusOK)})
+	}
+
+	unsubscribe := func(w http.ResponseWriter, r *http.Request, req PollReq) {
+		ns := requestNamespace(broker, r)
+		if err := req.Validate(); err != nil {
+			writeError(w, err)
+			return
+		}
+
+		principal, _ := PrincipalFromContext(r.Context())
+		if err := checkSubscriber(ns, acl, principal, req); err != nil {
+			writeError(w, err)
+			return
+		}
+
+		ns.Unsubscribe(req.Topic, req.Subscriber)
 		writeSuccess(w, StatusMsg{Message: http.StatusText(http.StatusOK)})
+	}
+
+	return func(mux chi.Router) {
+		// RPC-style routes, the parameters are passed in the query or in the body.
+
+		mux.Get("/poll", func(w http.ResponseWriter, r *http.Request) {
+			query := r.URL.Query()
+			poll(w, r, PollReq{Topic: query.Get("topic"), Subscriber: query.Get("subscriber")})
+		})
+
+		mux.Get("/stream", func(w http.ResponseWriter, r *http.Request) {
+			query := r.URL.Query()
+			stream(w, r, PollReq{Topic: query.Get("topic"), Subscriber: query.Get("subscriber")})
+		})
+
+		mux.Post("/publish", func(w http.ResponseWriter, r *http.Request) {
+			req := Message{}
+			if err := decodeBody(r, cfg.MaxBodyBytes, &req); err != nil {
+				writeError(w, err)
+				return
+			}
+			publish(w, r, req)
+		})
+
+		mux.Post("/subscribe", func(w http.ResponseWriter, r *http.Request) {
+			req := SubscribeReq{}
+			if err := decodeBody(r, cfg.MaxBodyBytes, &req); err != nil {
+				writeError(w, err)
+				return
+			}
+			subscribe(w, r, req)
+		})
+
+		mux.Post("/unsubscribe", func(w http.ResponseWriter, r *http.Request) {
+			req := PollReq{}
+			if err := decodeBody(r, cfg.MaxBodyBytes, &req); err != nil {
+				writeError(w, err)
+				return
+			}
+			unsubscribe(w, r, req)
+		})
+
+		mux.Post("/nack", func(w http.ResponseWriter, r *http.Request) {
+			req := NackReq{}
+			if err := decodeBody(r, cfg.MaxBodyBytes, &req); err != nil {
+				writeError(w, err)
+				return
+			}
+			nack(w, r, req)
+		})
+
+		// Resource routes, the topic and the subscriber are passed in the path.
+
+		mux.Post("/topics/{topic}/messages", func(w http.ResponseWriter, r *http.Request) {
+			req := Message{Topic: urlParam(r, "topic")}
+			if err := decodeBody(r, cfg.MaxBodyBytes, &req.Data); err != nil {
+				writeError(w, err)
+				return
+			}
+			publish(w, r, req)
+		})
+
+		mux.Put("/topics/{topic}/subscriptions/{subscriber}", func(w http.ResponseWriter, r *http.Request) {
+			req := SubscribeReq{}
+			// the subscription options are optional.
+			if r.ContentLength != 0 {
+				if err := decodeBody(r, cfg.MaxBodyBytes, &req); err != nil {
+					writeError(w, err)
+					return
+				}
+			}
+			req.PollReq = subscriptionParams(r)
+			subscribe(w, r, req)
+		})
+
+		mux.Delete("/topics/{topic}/subscriptions/{subscriber}", func(w http.ResponseWriter, r *http.Request) {
+			unsubscribe(w, r, subscriptionParams(r))
+		})
+
+		mux.Get("/topics/{topic}/subscriptions/{subscriber}/messages", func(w http.ResponseWriter, r *http.Request) {
+			poll(w, r, subscriptionParams(r))
+		})
+
+		mux.Get("/topics/{topic}/subscriptions/{subscriber}/stream", func(w http.ResponseWriter, r *http.Request) {
+			stream(w, r, subscriptionParams(r))
+		})
+
+		mux.Post("/topics/{topic}/subscriptions/{subscriber}/nack", func(w http.ResponseWriter, r *http.Request) {
+			req := NackReq{}
+			if err := decodeBody(r, cfg.MaxBodyBytes, &req); err != nil {
+				writeError(w, err)
+				return
+			}
+			req.PollReq = subscriptionParams(r)
+			nack(w, r, req)
+		})
+
+		mux.Get("/topics", func(w http.ResponseWriter, r *http.Request) {
+			ns := requestNamespace(broker, r)
+			principal, _ := PrincipalFromContext(r.Context())
+
+			topics := []mq.TopicInfo{}
+			for _, info := range ns.Topics() {
+				if acl.CanView(principal, ns.Name(), info.Name) {
+					topics = append(topics, info)
+				}
+			}
+			writeSuccess(w, topics)
+		})
+
+		mux.Get("/topics/{topic}", func(w http.ResponseWriter, r *http.Request) {
+			ns := requestNamespace(broker, r)
+			topic := urlParam(r, "topic")
+			principal, _ := PrincipalFromContext(r.Context())
+			if !acl.CanView(principal, ns.Name(), topic) {
+				writeError(w, errAccessDenied)
+				return
+			}
+
+			info, ok := ns.TopicInfo(topic)
+			if !ok {
+				writeError(w, ErrTopicNotFound)
+				return
+			}
+			writeSuccess(w, info)
+		})
+
+		mux.Get("/topics/{topic}/subscribers", func(w http.ResponseWriter, r *http.Request) {
+			ns := requestNamespace(broker, r)
+			topic := urlParam(r, "topic")
+			principal, _ := PrincipalFromContext(r.Context())
+			if !acl.CanView(principal, ns.Name(), topic) {
+				writeError(w, errAccessDenied)
+				return
+			}
+
+			subscribers, ok := ns.Subscribers(topic)
+			if !ok {
+				writeError(w, ErrTopicNotFound)
+				return
+			}
+			writeSuccess(w, subscribers)
+		})
+	}
+}
+
+// pollWait parses the `wait` query parameter, the max time of waiting for a new message.
+// The longer time is limited by `maxWait`, default is DefaultMaxPollWait.
+func pollWait(r *http.Request, maxWait time.Duration) (time.Duration, error) {
+	value := r.URL.Query().Get("wait")
+	if value == "" {
+		return 0, nil
+	}
+
+	wait, err := time.ParseDuration(value)
+	if err != nil || wait < 0 {
+		return 0, newError(http.StatusBadRequest, CodeInvalidParameter, "wait should be a non-negative duration, e.g. 30s")
+	}
+
+	if maxWait <= 0 {
+		maxWait = DefaultMaxPollWait
+	}
+	if wait > maxWait {
+		wait = maxWait
+	}
+	return wait, nil
+}
+
+// subscriptionParams returns the topic and the subscriber from the resource path.
+func subscriptionParams(r *http.Request) PollReq {
+	return PollReq{Topic: urlParam(r, "topic"), Subscriber: urlParam(r, "subscriber")}
+}
+
+// requestNamespace selects the namespace by the `/ns/{namespace}` URL prefix
+// or by the namespace of the authenticated client. The namespace is not created,
+// the missing one has no topics.
+func requestNamespace(broker *mq.Broker, r *http.Request) *mq.Namespace {
+	ns, _ := broker.LookupNamespace(namespaceName(r))
+	return ns
+}
+
+// namespaceName returns the name of the namespace selected by the request.
+func namespaceName(r *http.Request) string {
+	name := urlParam(r, "namespace")
+	if name == "" {
+		name, _ = NamespaceFromContext(r.Context())
+	}
+	return name
+}
+
+// restrictNamespace rejects the requests of the client bound to the namespace to other namespaces.
+func restrictNamespace(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		bound, ok := NamespaceFromContext(r.Context())
+		if ok && urlParam(r, "namespace") != bound {
+			writeError(w, errAccessDenied)
+			return
+		}
+		next.ServeHTTP(w, r)
 	})
+}
 
-	return mux
+// urlParam returns the unescaped value of the URL parameter.
+func urlParam(r *http.Request, key string) string {
+	value := chi.URLParam(r, key)
+	if unescaped, err := url.PathUnescape(value); err == nil {
+		return unescaped
+	}
+	return value
 }
 
 func writeSuccess(w http.ResponseWriter, data interface{}) {
 	writeData(w, http.StatusOK, data)
 }
 
+// writeError responds with the API error, unknown errors are internal.
 func writeError(w http.ResponseWriter, err error) {
 	log.Println("ERROR: processing error", err.Error())
 
-	writeData(w, http.StatusBadRequest, StatusMsg{Message: err.Error()})
+	apiErr := APIError(err)
+	writeData(w, apiErr.Status, apiErr)
+}
+
+func notFound(w http.ResponseWriter, _ *http.Request) {
+	writeError(w, errNotFound)
+}
+
+func methodNotAllowed(w http.ResponseWriter, _ *http.Request) {
+	writeError(w, errMethodNotAllowed)
 }
 
 func writeData(w http.ResponseWriter, code int, data interface{}) {
@@ -0,0 +1,205 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/sheb-gregor/polly-demo/mq"
+)
+
+// Defaults of the StreamConfig.
+const (
+	DefaultStreamHeartbeat    = 15 * time.Second
+	DefaultStreamResumeWindow = 100
+	DefaultStreamResumeTTL    = 5 * time.Minute
+)
+
+// StreamConfig is the parameters of the server-sent events stream of the messages.
+type StreamConfig struct {
+	// Heartbeat is the interval of the comments which keep the idle connection open,
+	// default is DefaultStreamHeartbeat.
+	Heartbeat time.Duration `json:"heartbeat" yaml:"heartbeat"`
+	// ResumeWindow is the count of the last sent messages which are returned to the queue
+	// if the client reconnects with the older `Last-Event-ID`, default is DefaultStreamResumeWindow,
+	// it is limited by mq.InFlightLimit.
+	ResumeWindow int `json:"resume_window" yaml:"resume_window"`
+	// ResumeTTL is how long the sent messages are kept after the disconnect, default is DefaultStreamResumeTTL.
+	ResumeTTL time.Duration `json:"resume_ttl" yaml:"resume_ttl"`
+}
+
+func (cfg StreamConfig) heartbeat() time.Duration {
+	if cfg.Heartbeat > 0 {
+		return cfg.Heartbeat
+	}
+	return DefaultStreamHeartbeat
+}
+
+func (cfg StreamConfig) resumeWindow() int {
+	switch {
+	case cfg.ResumeWindow > mq.InFlightLimit:
+		return mq.InFlightLimit
+	case cfg.ResumeWindow > 0:
+		return cfg.ResumeWindow
+	}
+	return DefaultStreamResumeWindow
+}
+
+// streamWindow is the messages sent by the closed stream, they may be not received by the client.
+type streamWindow struct {
+	deliveries []mq.Delivery
+	expires    time.Time
+}
+
+// streamWindows keeps the last messages of the closed streams by the subscription,
+// so the client can resume the stream from the last received message.
+type streamWindows struct {
+	sync.Mutex
+	ttl     time.Duration
+	windows map[string]streamWindow
+	pruned  time.Time
+}
+
+func newStreamWindows(cfg StreamConfig) *streamWindows {
+	ttl := cfg.ResumeTTL
+	if ttl <= 0 {
+		ttl = DefaultStreamResumeTTL
+	}
+	return &streamWindows{ttl: ttl, windows: map[string]streamWindow{}}
+}
+
+// take removes and returns the messages of the closed stream of the subscription.
+func (sw *streamWindows) take(key string, now time.Time) []mq.Delivery {
+	sw.Lock()
+	defer sw.Unlock()
+
+	window, ok := sw.windows[key]
+	delete(sw.windows, key)
+	if !ok || !now.Before(window.expires) {
+		return nil
+	}
+	return window.deliveries
+}
+
+// store keeps the messages of the closed stream.
+func (sw *streamWindows) store(key string, deliveries []mq.Delivery, now time.Time) {
+	sw.Lock()
+	defer sw.Unlock()
+
+	if now.Sub(sw.pruned) > sw.ttl {
+		for k, window := range sw.windows {
+			if !now.Before(window.expires) {
+				delete(sw.windows, k)
+			}
+		}
+		sw.pruned = now
+	}
+
+	if len(deliveries) > 0 {
+		sw.windows[key] = streamWindow{deliveries: deliveries, expires: now.Add(sw.ttl)}
+	}
+}
+
+// resumeStream returns the messages sent after `Last-Event-ID` by the previous stream
+// to the front of the queue in the original order. Without the header they are considered received.
+func resumeStream(ns *mq.Namespace, req PollReq, r *http.Request, deliveries []mq.Delivery) {
+	lastID, err := strconv.ParseInt(r.Header.Get("Last-Event-ID"), 10, 64)
+	if err != nil {
+		return
+	}
+
+	for i := len(deliveries) - 1; i >= 0; i-- {
+		if deliveries[i].ID > lastID {
+			_, _ = ns.Requeue(req.Topic, req.Subscriber, deliveries[i].ID)
+		}
+	}
+}
+
+// writeStream sends the messages of the subscription as the server-sent events until the client disconnects.
+// It returns the last sent messages, up to the resume window.
+func writeStream(w http.ResponseWriter, r *http.Request, ns *mq.Namespace, req PollReq,
+	cfg StreamConfig, onDelivery func(delivery mq.Delivery, wait time.Duration)) []mq.Delivery {
+	flusher, _ := w.(http.Flusher)
+	flush := func() {
+		if flusher != nil {
+			flusher.Flush()
+		}
+	}
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Set("X-Accel-Buffering", "no")
+	w.WriteHeader(http.StatusOK)
+	flush()
+
+	size := cfg.resumeWindow()
+	var window []mq.Delivery
+	for {
+		start := time.Now()
+		ctx, cancel := context.WithTimeout(r.Context(), cfg.heartbeat())
+		delivery, subscribed := ns.FetchWait(req.Topic, req.Subscriber, ctx.Done())
+		cancel()
+
+		if delivery.Data != nil && r.Context().Err() != nil {
+			// the client is gone while waiting, so the message is returned to the queue.
+			_, _ = ns.Requeue(req.Topic, req.Subscriber, delivery.ID)
+			return window
+		}
+		if !subscribed {
+			_ = writeEvent(w, "error", "", APIError(ErrNotSubscribed))
+			flush()
+			return window
+		}
+		if r.Context().Err() != nil {
+			return window
+		}
+
+		if delivery.Data == nil {
+			if _, err := fmt.Fprint(w, ": heartbeat\n\n"); err != nil {
+				return window
+			}
+			flush()
+			continue
+		}
+
+		onDelivery(delivery, time.Since(start))
+		window = append(window, delivery)
+		if len(window) >= 2*size {
+			window = append([]mq.Delivery(nil), window[len(window)-size:]...)
+		}
+
+		msg := Message{ID: delivery.ID, Topic: req.Topic, Data: delivery.Data, Dropped: delivery.Dropped}
+		if err := writeEvent(w, "message", strconv.FormatInt(delivery.ID, 10), msg); err != nil {
+			return window
+		}
+		flush()
+	}
+}
+
+// writeEvent writes the server-sent event with the JSON data.
+func writeEvent(w http.ResponseWriter, event, id string, data interface{}) error {
+	raw, err := json.Marshal(data)
+	if err != nil {
+		return err
+	}
+
+	if id != "" {
+		if _, err := fmt.Fprintf(w, "id: %s\n", id); err != nil {
+			return err
+		}
+	}
+	_, err = fmt.Fprintf(w, "event: %s\ndata: %s\n\n", event, raw)
+	return err
+}
+
+// lastDeliveries returns at most `size` last messages.
+func lastDeliveries(deliveries []mq.Delivery, size int) []mq.Delivery {
+	if len(deliveries) > size {
+		return deliveries[len(deliveries)-size:]
+	}
+	return deliveries
+}
@@ -0,0 +1,155 @@
+package server
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"io/ioutil"
+	"net/http"
+
+	"github.com/lancer-kit/uwe/v2"
+	"github.com/lancer-kit/uwe/v2/presets/api"
+)
+
+// TLSConfig is the certificates of the API listener, TLS is disabled if the certificate is not set.
+type TLSConfig struct {
+	CertFile string `json:"cert_file" yaml:"cert_file"`
+	KeyFile  string `json:"key_file" yaml:"key_file"`
+	// ClientCAFile is the PEM bundle of CAs used to verify the client certificates (mTLS).
+	// The verified certificate authenticates the client by its subject.
+	ClientCAFile string `json:"client_ca_file" yaml:"client_ca_file"`
+	// RequireClientCert rejects the connections without a valid client certificate.
+	RequireClientCert bool `json:"require_client_cert" yaml:"require_client_cert"`
+}
+
+// Enabled checks if the listener should serve HTTPS.
+func (cfg TLSConfig) Enabled() bool {
+	return cfg.CertFile != ""
+}
+
+// Validate checks the correctness of the configuration.
+func (cfg TLSConfig) Validate() error {
+	if !cfg.Enabled() {
+		if cfg.KeyFile != "" || cfg.ClientCAFile != "" || cfg.RequireClientCert {
+			return errors.New("tls: cert_file should be set")
+		}
+		return nil
+	}
+
+	if cfg.KeyFile == "" {
+		return errors.New("tls: key_file should be set")
+	}
+	if cfg.RequireClientCert && cfg.ClientCAFile == "" {
+		return errors.New("tls: client_ca_file is required to verify client certificates")
+	}
+	return nil
+}
+
+// ServerTLS loads the certificates and creates the configuration of the TLS listener.
+func (cfg TLSConfig) ServerTLS() (*tls.Config, error) {
+	cert, err := tls.LoadX509KeyPair(cfg.CertFile, cfg.KeyFile)
+	if err != nil {
+		return nil, err
+	}
+
+	tlsConfig := &tls.Config{
+		Certificates: []tls.Certificate{cert},
+		MinVersion:   tls.VersionTLS12,
+	}
+	if cfg.ClientCAFile == "" {
+		return tlsConfig, nil
+	}
+
+	pem, err := ioutil.ReadFile(cfg.ClientCAFile)
+	if err != nil {
+		return nil, err
+	}
+
+	tlsConfig.ClientCAs = x509.NewCertPool()
+	if !tlsConfig.ClientCAs.AppendCertsFromPEM(pem) {
+		return nil, errors.New("tls: no certificates found in " + cfg.ClientCAFile)
+	}
+
+	tlsConfig.ClientAuth = tls.VerifyClientCertIfGiven
+	if cfg.RequireClientCert {
+		tlsConfig.ClientAuth = tls.RequireAndVerifyClientCert
+	}
+	return tlsConfig, nil
+}
+
+// Server is the worker that serves the handler over HTTP or, if TLS is enabled, over HTTPS.
+type Server struct {
+	config    api.Config
+	tls       TLSConfig
+	tlsConfig *tls.Config
+	handler   http.Handler
+}
+
+// NewServer creates the worker for the `uwe.Chief`.
+func NewServer(config api.Config, tls TLSConfig, handler http.Handler) *Server {
+	return &Server{config: config, tls: tls, handler: handler}
+}
+
+func (s *Server) Init() error {
+	if !s.tls.Enabled() {
+		return nil
+	}
+
+	tlsConfig, err := s.tls.ServerTLS()
+	if err != nil {
+		return err
+	}
+	s.tlsConfig = tlsConfig
+	return nil
+}
+
+func (s *Server) Run(ctx uwe.Context) error {
+	server := &http.Server{
+		Addr:      s.config.TCPAddr(),
+		Handler:   s.handler,
+		TLSConfig: s.tlsConfig,
+	}
+
+	serverFailed := make(chan error, 1)
+	go func() {
+		var err error
+		if s.tlsConfig != nil {
+			// the certificates are already loaded into the TLSConfig.
+			err = server.ListenAndServeTLS("", "")
+		} else {
+			err = server.ListenAndServe()
+		}
+		if err != nil && err != http.ErrServerClosed {
+			serverFailed <- err
+		}
+	}()
+
+	select {
+	case <-ctx.Done():
+		shutdownCtx, cancel := context.WithTimeout(context.Background(), api.ForceStopTimeout)
+		defer cancel()
+		return server.Shutdown(shutdownCtx)
+	case err := <-serverFailed:
+		return err
+	}
+}
+
+// certificateClient returns the identity of the client by the subject of the verified certificate,
+// the known clients also get their configuration.
+func (cfg AuthConfig) certificateClient(r *http.Request) (ClientConfig, bool) {
+	if r.TLS == nil || len(r.TLS.VerifiedChains) == 0 || len(r.TLS.VerifiedChains[0]) == 0 {
+		return ClientConfig{}, false
+	}
+
+	subject := r.TLS.VerifiedChains[0][0].Subject
+	name := subject.CommonName
+	if name == "" {
+		name = subject.String()
+	}
+
+	if client, ok := cfg.client(name); ok {
+		return client, true
+	}
+	return ClientConfig{Name: name}, true
+}
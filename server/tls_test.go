@@ -0,0 +1,24 @@
+package server
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestTLSConfig_Validate(t *testing.T) {
+	assert.NoError(t, TLSConfig{}.Validate())
+	assert.NoError(t, TLSConfig{CertFile: "server.crt", KeyFile: "server.key"}.Validate())
+	assert.NoError(t, TLSConfig{CertFile: "server.crt", KeyFile: "server.key",
+		ClientCAFile: "ca.crt", RequireClientCert: true}.Validate())
+
+	assert.Error(t, TLSConfig{KeyFile: "server.key"}.Validate())
+	assert.Error(t, TLSConfig{CertFile: "server.crt"}.Validate())
+	assert.Error(t, TLSConfig{CertFile: "server.crt", KeyFile: "server.key", RequireClientCert: true}.Validate())
+
+	auth := AuthConfig{Clients: []ClientConfig{{Name: "alice", Namespace: "team-a"}}}
+	assert.Error(t, Config{Auth: auth}.Validate())
+	assert.NoError(t, Config{Auth: auth, TLS: TLSConfig{
+		CertFile: "server.crt", KeyFile: "server.key", ClientCAFile: "ca.crt",
+	}}.Validate())
+}
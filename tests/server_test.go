@@ -1,12 +1,33 @@
 package tests
 
 import (
+	"bufio"
 	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
 	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"io/ioutil"
+	"math/big"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
 	"testing"
+	"time"
 
 	"github.com/lancer-kit/uwe/v2/presets/api"
 	"github.com/sheb-gregor/polly-demo/client"
+	"github.com/sheb-gregor/polly-demo/client/clienttest"
 	"github.com/sheb-gregor/polly-demo/mq"
 	"github.com/sheb-gregor/polly-demo/server"
 	"github.com/stretchr/testify/assert"
@@ -18,11 +39,10 @@ func TestAPI(t *testing.T) {
 	message := json.RawMessage(`{"my_key":"my_message"}`)
 	broker := mq.NewBroker()
 
-	ctx, cancel := context.WithCancel(context.Background())
-	apiServer := api.NewServer(api.Config{Host: "0.0.0.0", Port: 8080}, server.GetServer(broker))
-	go func() { _ = apiServer.Run(ctx) }()
+	testServer := clienttest.NewServer(broker)
+	defer testServer.Close()
 
-	pClient, err := client.NewClient("http://0.0.0.0:8080")
+	pClient, err := client.NewClient(testServer.URL)
 	assert.NoError(t, err)
 
 	err = pClient.Subscribe(topic, name)
@@ -37,5 +57,1258 @@ func TestAPI(t *testing.T) {
 
 	err = pClient.Unsubscribe(topic, name)
 	assert.NoError(t, err)
+
+	_, err = pClient.Poll(topic, name)
+	assert.True(t, errors.Is(err, client.ErrNotSubscribed))
+	_, err = pClient.(client.Inspector).Topic("unknown")
+	assert.True(t, errors.Is(err, client.ErrTopicNotFound))
+	assert.True(t, errors.Is(pClient.Publish("", message), client.ErrInvalidRequest))
+}
+
+func TestAPI_Topics(t *testing.T) {
+	topic := "test/topic"
+	broker := mq.NewBroker()
+
+	testServer := clienttest.NewServer(broker)
+	defer testServer.Close()
+
+	pClient, err := client.NewClient(testServer.URL)
+	assert.NoError(t, err)
+	inspector, ok := pClient.(client.Inspector)
+	assert.True(t, ok)
+
+	topics, err := inspector.Topics()
+	assert.NoError(t, err)
+	assert.Empty(t, topics)
+
+	assert.NoError(t, pClient.Subscribe(topic, "alice"))
+	subscriber, ok := pClient.(client.OptionsSubscriber)
+	assert.True(t, ok)
+	assert.NoError(t, subscriber.SubscribeWithOptions(topic, "bob", client.SubscribeOptions{MaxPending: 1}))
+	assert.NoError(t, pClient.Publish(topic, json.RawMessage(`{"id":1}`)))
+	assert.NoError(t, pClient.Publish(topic, json.RawMessage(`{"id":2}`)))
+
+	topics, err = inspector.Topics()
+	assert.NoError(t, err)
+	assert.Equal(t, 1, len(topics))
+
+	info, err := inspector.Topic(topic)
+	assert.NoError(t, err)
+	assert.Equal(t, topic, info.Name)
+	assert.Equal(t, int64(2), info.Subscribers)
+	assert.Equal(t, 2, info.Messages)
+	assert.Equal(t, int64(16), info.Bytes)
+	assert.Equal(t, int64(2), info.LastID)
+	assert.True(t, info.OldestPendingAge > 0)
+
+	subscribers, err := inspector.Subscribers(topic)
+	assert.NoError(t, err)
+	assert.Equal(t, 2, len(subscribers))
+	assert.Equal(t, "alice", subscribers[0].Name)
+	assert.Equal(t, 2, subscribers[0].Pending)
+	assert.Equal(t, "bob", subscribers[1].Name)
+	assert.Equal(t, 1, subscribers[1].Pending)
+	assert.Equal(t, int64(1), subscribers[1].Dropped)
+
+	_, err = inspector.Topic("unknown")
+	assert.Error(t, err)
+}
+
+func TestAPI_Admin(t *testing.T) {
+	topic := "test_topic"
+	broker := mq.NewBroker()
+	handler := server.NewHandler(broker, server.Config{Admin: server.AdminConfig{Token: "secret"}})
+
+	broker.Subscribe(topic, "alice")
+	assert.NoError(t, broker.HandleNewMessage(topic, json.RawMessage(`{"id":1}`)))
+
+	request := func(method, path, token string) int {
+		req := httptest.NewRequest(method, path, nil)
+		if token != "" {
+			req.Header.Set("Authorization", "Bearer "+token)
+		}
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, req)
+		return rec.Code
+	}
+
+	assert.Equal(t, http.StatusUnauthorized, request(http.MethodPost, "/admin/topics/test_topic/purge", ""))
+	assert.Equal(t, http.StatusUnauthorized, request(http.MethodPost, "/admin/topics/test_topic/purge", "wrong"))
+	assert.Equal(t, http.StatusOK, request(http.MethodGet, "/admin/memory", "secret"))
+	assert.Equal(t, http.StatusOK, request(http.MethodGet, "/admin/namespaces", "secret"))
+	assert.Equal(t, http.StatusNotFound, request(http.MethodPost, "/admin/ns/other/topics/test_topic/purge", "secret"))
+
+	assert.Equal(t, http.StatusOK, request(http.MethodPost, "/admin/topics/test_topic/subscribers/alice/reset", "secret"))
+	assert.Equal(t, http.StatusNotFound, request(http.MethodPost, "/admin/topics/test_topic/subscribers/bob/reset", "secret"))
+	assert.Equal(t, http.StatusOK, request(http.MethodPost, "/admin/topics/test_topic/purge", "secret"))
+	assert.Equal(t, http.StatusOK, request(http.MethodDelete, "/admin/topics/test_topic", "secret"))
+	assert.Equal(t, http.StatusNotFound, request(http.MethodDelete, "/admin/topics/test_topic", "secret"))
+
+	disabled := server.GetServer(broker)
+	rec := httptest.NewRecorder()
+	disabled.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/admin/memory", nil))
+	assert.Equal(t, http.StatusForbidden, rec.Code)
+}
+
+func TestAPI_Metrics(t *testing.T) {
+	topic := "test_topic"
+	broker := mq.NewBroker()
+	handler := server.NewHandler(broker, server.Config{Admin: server.AdminConfig{Token: "secret"}})
+
+	serve := func(method, path, body string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(method, path, strings.NewReader(body))
+		req.Header.Set("Authorization", "Bearer secret")
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, req)
+		return rec
+	}
+
+	serve(http.MethodPost, "/subscribe", `{"topic":"test_topic","subscriber":"alice"}`)
+	serve(http.MethodPost, "/publish", `{"topic":"test_topic","data":{"id":1}}`)
+	serve(http.MethodGet, "/poll?topic=test_topic&subscriber=alice", "")
+	serve(http.MethodGet, "/poll?topic=test_topic&subscriber=bob", "")
+	serve(http.MethodPost, "/publish", `{"topic":"test_topic","data":{"id":2}}`)
+	// the requests to the missing topics and subscriptions are not recorded.
+	serve(http.MethodPost, "/publish", `{"topic":"unknown_topic","data":{"id":3}}`)
+	serve(http.MethodGet, "/poll?topic=unknown_topic&subscriber=alice", "")
+
+	rec := serve(http.MethodGet, "/metrics", "")
+	assert.Equal(t, http.StatusOK, rec.Code)
+
+	body := rec.Body.String()
+	assert.Contains(t, body, `polly_messages_published_total{namespace="default",topic="`+topic+`"} 2`)
+	assert.Contains(t, body, `polly_messages_polled_total{namespace="default",topic="`+topic+`"} 1`)
+	assert.Contains(t, body, `polly_poll_duration_seconds_count{namespace="default",topic="`+topic+`"} 1`)
+	assert.NotContains(t, body, "unknown_topic")
+	assert.Contains(t, body, `polly_subscription_pending_messages{namespace="default",topic="`+topic+`",subscriber="alice"} 1`)
+	assert.Contains(t, body, `polly_topic_bytes{namespace="default",topic="`+topic+`"} 8`)
+	assert.Contains(t, body, `polly_http_requests_total{method="GET",route="/poll",code="404"} 2`)
+	assert.Contains(t, body, `polly_http_requests_total{method="POST",route="/publish",code="200"} 3`)
+}
+
+func TestAPI_Health(t *testing.T) {
+	broker := mq.NewBrokerWithConfig(mq.Config{Memory: mq.MemoryConfig{MaxBytes: 8}})
+	cfg := server.Config{Admin: server.AdminConfig{Listener: &api.Config{Host: "0.0.0.0", Port: 8082}}}
+	apiHandler, adminHandler := server.NewHandlers(broker, cfg)
+	assert.NotNil(t, adminHandler)
+
+	serve := func(handler http.Handler, path string) int {
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, path, nil))
+		return rec.Code
+	}
+
+	assert.Equal(t, http.StatusOK, serve(apiHandler, "/healthz"))
+	assert.Equal(t, http.StatusOK, serve(apiHandler, "/readyz"))
+	assert.Equal(t, http.StatusNotFound, serve(apiHandler, "/metrics"))
+	assert.Equal(t, http.StatusOK, serve(adminHandler, "/healthz"))
+	assert.Equal(t, http.StatusOK, serve(adminHandler, "/metrics"))
+	assert.Equal(t, http.StatusNotFound, serve(adminHandler, "/topics"))
+
+	broker.Subscribe("test_topic", "alice")
+	assert.NoError(t, broker.HandleNewMessage("test_topic", json.RawMessage(`{"id":1}`)))
+	assert.Equal(t, http.StatusServiceUnavailable, serve(adminHandler, "/readyz"))
+	assert.Equal(t, http.StatusOK, serve(adminHandler, "/healthz"))
+
+	// the metrics on the main API require the admin token.
+	apiHandler, adminHandler = server.NewHandlers(broker, server.Config{Admin: server.AdminConfig{Token: "secret"}})
+	assert.Nil(t, adminHandler)
+	assert.Equal(t, http.StatusUnauthorized, serve(apiHandler, "/metrics"))
+	rec := httptest.NewRecorder()
+	req := httptest.NewRequest(http.MethodGet, "/metrics", nil)
+	req.Header.Set("Authorization", "Bearer secret")
+	apiHandler.ServeHTTP(rec, req)
+	assert.Equal(t, http.StatusOK, rec.Code)
+
+	apiHandler, _ = server.NewHandlers(broker, server.Config{})
+	assert.Equal(t, http.StatusForbidden, serve(apiHandler, "/metrics"))
+}
+
+func TestAPI_Auth(t *testing.T) {
+	topic := "test_topic"
+	message := json.RawMessage(`{"my_key":"my_message"}`)
+	broker := mq.NewBroker()
+	cfg := server.Config{Auth: server.AuthConfig{Clients: []server.ClientConfig{
+		{Name: "alice", Token: "alice-token"},
+		{Name: "bob", Secret: "bob-secret"},
+	}}, MaxBodyBytes: 1024}
+	assert.NoError(t, cfg.Validate())
+
+	testServer := clienttest.NewServerWithConfig(broker, cfg)
+	defer testServer.Close()
+
+	anonymous, err := client.NewClient(testServer.URL)
+	assert.NoError(t, err)
+	assert.Error(t, anonymous.Subscribe(topic, "anonymous"))
+
+	wrongToken, err := client.NewClient(testServer.URL, client.WithToken("bob-secret"))
+	assert.NoError(t, err)
+	assert.Error(t, wrongToken.Subscribe(topic, "anonymous"))
+
+	wrongSecret, err := client.NewClient(testServer.URL, client.WithHMAC("bob", "alice-token"))
+	assert.NoError(t, err)
+	assert.Error(t, wrongSecret.Subscribe(topic, "anonymous"))
+
+	alice, err := client.NewClient(testServer.URL, client.WithToken("alice-token"))
+	assert.NoError(t, err)
+	assert.NoError(t, alice.Subscribe(topic, "alice"))
+
+	bob, err := client.NewClient(testServer.URL, client.WithHMAC("bob", "bob-secret"))
+	assert.NoError(t, err)
+	assert.NoError(t, bob.Publish(topic, message))
+
+	// the signed body is limited before the signature is checked.
+	large := json.RawMessage(`"` + strings.Repeat("x", 2048) + `"`)
+	assert.True(t, errors.Is(bob.Publish(topic, large), client.ErrPayloadTooLarge))
+	assert.True(t, errors.Is(wrongSecret.Publish(topic, large), client.ErrPayloadTooLarge))
+
+	msg, err := alice.Poll(topic, "alice")
+	assert.NoError(t, err)
+	assert.Equal(t, message, msg)
+
+	_, err = bob.(client.Inspector).Topic(topic)
+	assert.NoError(t, err)
+
+	resp, err := http.Get(testServer.URL + "/healthz")
+	assert.NoError(t, err)
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+	_ = resp.Body.Close()
+}
+
+func TestAPI_ACL(t *testing.T) {
+	broker := mq.NewBroker()
+	handler := server.NewHandler(broker, server.Config{
+		Auth: server.AuthConfig{Clients: []server.ClientConfig{
+			{Name: "alice", Token: "alice-token"},
+			{Name: "bob", Token: "bob-token"},
+		}},
+		ACL: server.ACL{
+			{Principal: "alice", Publish: []string{"orders.*"}, Subscribe: []string{"orders.*"}},
+			{Principal: "*", Subscribe: []string{"public.*"}},
+		},
+	})
+
+	serve := func(token, method, path, body string) int {
+		req := httptest.NewRequest(method, path, strings.NewReader(body))
+		req.Header.Set("Authorization", "Bearer "+token)
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, req)
+		return rec.Code
+	}
+
+	assert.Equal(t, http.StatusOK, serve("alice-token", http.MethodPost, "/subscribe",
+		`{"topic":"orders.eu","subscriber":"alice"}`))
+	assert.Equal(t, http.StatusForbidden, serve("bob-token", http.MethodPost, "/subscribe",
+		`{"topic":"orders.eu","subscriber":"bob"}`))
+	assert.Equal(t, http.StatusOK, serve("alice-token", http.MethodPost, "/publish",
+		`{"topic":"orders.eu","data":{"id":1}}`))
+	assert.Equal(t, http.StatusForbidden, serve("bob-token", http.MethodPost, "/publish",
+		`{"topic":"orders.eu","data":{"id":1}}`))
+	assert.Equal(t, http.StatusForbidden, serve("alice-token", http.MethodPost, "/publish",
+		`{"topic":"public.news","data":{"id":1}}`))
+
+	assert.Equal(t, http.StatusOK, serve("bob-token", http.MethodPost, "/subscribe",
+		`{"topic":"public.news","subscriber":"bob"}`))
+	assert.Equal(t, http.StatusForbidden, serve("alice-token", http.MethodPost, "/subscribe",
+		`{"topic":"public.news","subscriber":"bob"}`))
+	assert.Equal(t, http.StatusForbidden, serve("alice-token", http.MethodGet,
+		"/poll?topic=public.news&subscriber=bob", ""))
+	assert.Equal(t, http.StatusForbidden, serve("alice-token", http.MethodPost, "/unsubscribe",
+		`{"topic":"public.news","subscriber":"bob"}`))
+	assert.Equal(t, http.StatusOK, serve("bob-token", http.MethodGet,
+		"/poll?topic=public.news&subscriber=bob", ""))
+
+	assert.Equal(t, http.StatusForbidden, serve("bob-token", http.MethodGet, "/topics/orders.eu", ""))
+	assert.Equal(t, http.StatusOK, serve("alice-token", http.MethodGet, "/topics/orders.eu", ""))
+
+	subscribers, ok := broker.Subscribers("public.news")
+	assert.True(t, ok)
+	assert.Equal(t, "bob", subscribers[0].Owner)
+}
+
+func TestAPI_Namespaces(t *testing.T) {
+	topic := "test_topic"
+	message := json.RawMessage(`{"my_key":"my_message"}`)
+	broker := mq.NewBroker()
+	cfg := server.Config{Auth: server.AuthConfig{Clients: []server.ClientConfig{
+		{Name: "alice", Token: "alice-token", Namespace: "team-a"},
+		{Name: "bob", Token: "bob-token"},
+	}}}
+
+	testServer := clienttest.NewServerWithConfig(broker, cfg)
+	defer testServer.Close()
+
+	alice, err := client.NewClient(testServer.URL, client.WithToken("alice-token"))
+	assert.NoError(t, err)
+	assert.NoError(t, alice.Subscribe(topic, "alice"))
+
+	bob, err := client.NewClient(testServer.URL, client.WithToken("bob-token"))
+	assert.NoError(t, err)
+	assert.NoError(t, bob.Subscribe(topic, "bob"))
+	assert.NoError(t, bob.Publish(topic, message))
+
+	msg, err := alice.Poll(topic, "alice")
+	assert.NoError(t, err)
+	assert.Nil(t, msg)
+
+	bobTeamA, err := client.NewClient(testServer.URL,
+		client.WithToken("bob-token"), client.WithNamespace("team-a"))
+	assert.NoError(t, err)
+	assert.NoError(t, bobTeamA.Publish(topic, message))
+
+	msg, err = alice.Poll(topic, "alice")
+	assert.NoError(t, err)
+	assert.Equal(t, message, msg)
+
+	topics, err := bobTeamA.(client.Inspector).Topics()
+	assert.NoError(t, err)
+	assert.Len(t, topics, 1)
+
+	subscribers, ok := broker.GetNamespace("team-a").Subscribers(topic)
+	assert.True(t, ok)
+	assert.Equal(t, "alice", subscribers[0].Name)
+
+	// the client bound to the namespace can not access other namespaces.
+	aliceTeamB, err := client.New(testServer.URL, client.WithToken("alice-token"), client.WithNamespace("team-b"))
+	assert.NoError(t, err)
+	err = aliceTeamB.Subscribe(context.Background(), topic, "alice")
+	assert.True(t, errors.Is(err, client.ErrAccessDenied))
+	aliceTeamA, err := client.New(testServer.URL, client.WithToken("alice-token"), client.WithNamespace("team-a"))
+	assert.NoError(t, err)
+	_, err = aliceTeamA.Topics(context.Background())
+	assert.NoError(t, err)
+
+	// the namespaces are not created by the reads.
+	bobTeamC, err := client.New(testServer.URL, client.WithToken("bob-token"), client.WithNamespace("team-c"))
+	assert.NoError(t, err)
+	teamCTopics, err := bobTeamC.Topics(context.Background())
+	assert.NoError(t, err)
+	assert.Empty(t, teamCTopics)
+	_, err = bobTeamC.Fetch(context.Background(), topic, "bob")
+	assert.True(t, errors.Is(err, client.ErrNotSubscribed))
+	assert.NoError(t, bobTeamC.Publish(context.Background(), topic, message))
+	_, ok = broker.LookupNamespace("team-c")
+	assert.False(t, ok)
+
+	req, err := http.NewRequest(http.MethodGet, testServer.URL+"/admin/namespaces", nil)
+	assert.NoError(t, err)
+	resp, err := http.DefaultClient.Do(req)
+	assert.NoError(t, err)
+	assert.Equal(t, http.StatusForbidden, resp.StatusCode)
+	_ = resp.Body.Close()
+}
+
+func TestAPI_RateLimit(t *testing.T) {
+	broker := mq.NewBroker()
+	handler := server.NewHandler(broker, server.Config{
+		Admin: server.AdminConfig{Token: "secret"},
+		RateLimit: server.RateLimitConfig{
+			Client: server.RateLimits{Publish: server.Rate{PerSecond: 0.1, Burst: 2}},
+		},
+	})
+
+	serve := func(method, path, body string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(method, path, strings.NewReader(body))
+		req.Header.Set("Authorization", "Bearer secret")
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, req)
+		return rec
+	}
+
+	for i := 0; i < 2; i++ {
+		assert.Equal(t, http.StatusOK, serve(http.MethodPost, "/publish", `{"topic":"test_topic","data":1}`).Code)
+	}
+
+	rec := serve(http.MethodPost, "/publish", `{"topic":"test_topic","data":1}`)
+	assert.Equal(t, http.StatusTooManyRequests, rec.Code)
+	assert.Equal(t, "10", rec.Header().Get("Retry-After"))
+
+	rec = serve(http.MethodGet, "/rate_limits", "")
+	assert.Equal(t, http.StatusOK, rec.Code)
+	stats := server.OperationsStats{}
+	assert.NoError(t, json.NewDecoder(rec.Body).Decode(&stats))
+	assert.Equal(t, server.RateLimitStats{Allowed: 2, Limited: 1}, stats.Publish)
+
+	rec = serve(http.MethodGet, "/admin/rate_limits", "")
+	assert.Equal(t, http.StatusOK, rec.Code)
+	info := server.RateLimitsInfo{}
+	assert.NoError(t, json.NewDecoder(rec.Body).Decode(&info))
+	assert.Len(t, info.Clients, 1)
+	assert.Empty(t, info.Topics)
+
+	body := serve(http.MethodGet, "/metrics", "").Body.String()
+	assert.Contains(t, body, `polly_rate_limited_total{scope="client",operation="publish"} 1`)
+}
+
+func TestAPI_TLS(t *testing.T) {
+	dir, err := ioutil.TempDir("", "polly-tls")
+	assert.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	ca, caKey := generateCertificate(t, dir, "ca", nil, nil)
+	generateCertificate(t, dir, "server", ca, caKey)
+	generateCertificate(t, dir, "alice", ca, caKey)
+
+	topic := "orders.eu"
+	message := json.RawMessage(`{"id":1}`)
+	cfg := server.Config{
+		TLS: server.TLSConfig{
+			CertFile:     filepath.Join(dir, "server.crt"),
+			KeyFile:      filepath.Join(dir, "server.key"),
+			ClientCAFile: filepath.Join(dir, "ca.crt"),
+		},
+		ACL: server.ACL{{Principal: "alice", Publish: []string{"orders.*"}, Subscribe: []string{"orders.*"}}},
+	}
+	assert.NoError(t, cfg.Validate())
+
+	httpServer := httptest.NewUnstartedServer(server.NewHandler(mq.NewBroker(), cfg))
+	httpServer.TLS, err = cfg.TLS.ServerTLS()
+	assert.NoError(t, err)
+	httpServer.StartTLS()
+	defer httpServer.Close()
+
+	untrusted, err := client.NewClient(httpServer.URL)
+	assert.NoError(t, err)
+	assert.Error(t, untrusted.Subscribe(topic, "alice"))
+
+	tlsConfig, err := client.LoadTLSConfig(filepath.Join(dir, "ca.crt"), "", "")
+	assert.NoError(t, err)
+	anonymous, err := client.NewClient(httpServer.URL, client.WithTLSConfig(tlsConfig))
+	assert.NoError(t, err)
+	assert.Error(t, anonymous.Subscribe(topic, "alice"))
+
+	tlsConfig, err = client.LoadTLSConfig(filepath.Join(dir, "ca.crt"),
+		filepath.Join(dir, "alice.crt"), filepath.Join(dir, "alice.key"))
+	assert.NoError(t, err)
+	alice, err := client.NewClient(httpServer.URL, client.WithTLSConfig(tlsConfig))
+	assert.NoError(t, err)
+	assert.NoError(t, alice.Subscribe(topic, "alice"))
+	assert.NoError(t, alice.Publish(topic, message))
+
+	msg, err := alice.Poll(topic, "alice")
+	assert.NoError(t, err)
+	assert.Equal(t, message, msg)
+
+	subscribers, err := alice.(client.Inspector).Subscribers(topic)
+	assert.NoError(t, err)
+	assert.Equal(t, "alice", subscribers[0].Owner)
+}
+
+// generateCertificate writes `{name}.crt` and `{name}.key` files with the certificate issued by the parent,
+// the certificate is self-signed CA if the parent is nil.
+func generateCertificate(t *testing.T, dir, name string,
+	parent *x509.Certificate, parentKey *ecdsa.PrivateKey) (*x509.Certificate, *ecdsa.PrivateKey) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	assert.NoError(t, err)
+
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(time.Now().UnixNano()),
+		Subject:      pkix.Name{CommonName: name},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth, x509.ExtKeyUsageClientAuth},
+		IPAddresses:  []net.IP{net.ParseIP("127.0.0.1")},
+	}
+	if parent == nil {
+		template.IsCA = true
+		template.BasicConstraintsValid = true
+		template.KeyUsage |= x509.KeyUsageCertSign
+		parent, parentKey = template, key
+	}
+
+	der, err := x509.CreateCertificate(rand.Reader, template, parent, &key.PublicKey, parentKey)
+	assert.NoError(t, err)
+	cert, err := x509.ParseCertificate(der)
+	assert.NoError(t, err)
+
+	keyDER, err := x509.MarshalECPrivateKey(key)
+	assert.NoError(t, err)
+
+	assert.NoError(t, ioutil.WriteFile(filepath.Join(dir, name+".crt"),
+		pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}), 0600))
+	assert.NoError(t, ioutil.WriteFile(filepath.Join(dir, name+".key"),
+		pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDER}), 0600))
+	return cert, key
+}
+
+func TestAPI_Errors(t *testing.T) {
+	broker := mq.NewBrokerWithConfig(mq.Config{Memory: mq.MemoryConfig{TopicMaxBytes: 4}})
+	handler := server.NewHandler(broker, server.Config{MaxBodyBytes: 64})
+	broker.SubscribeWithOptions("full", "bob", mq.SubscribeOptions{MaxPending: 1, Overflow: mq.OverflowReject})
+	assert.NoError(t, broker.HandleNewMessage("full", json.RawMessage(`1`)))
+
+	serve := func(method, path, body string) (int, server.Error) {
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, httptest.NewRequest(method, path, strings.NewReader(body)))
+
+		apiErr := server.Error{}
+		assert.NoError(t, json.NewDecoder(rec.Body).Decode(&apiErr))
+		return rec.Code, apiErr
+	}
+
+	cases := []struct {
+		method, path, body string
+		status             int
+		code               server.ErrorCode
+	}{
+		{http.MethodPost, "/publish", `{"topic":`, http.StatusBadRequest, server.CodeInvalidJSON},
+		{http.MethodPost, "/publish", `{"data":1}`, http.StatusBadRequest, server.CodeTopicRequired},
+		{http.MethodPost, "/publish", `{"topic":"test"}`, http.StatusBadRequest, server.CodeDataRequired},
+		{http.MethodPost, "/subscribe", `{"topic":"test"}`, http.StatusBadRequest, server.CodeSubscriberRequired},
+		{http.MethodPost, "/subscribe", `{"topic":"test","subscriber":"bob","overflow":"unknown"}`,
+			http.StatusBadRequest, server.CodeInvalidOptions},
+		{http.MethodPost, "/publish", `{"topic":"test","data":"` + strings.Repeat("a", 64) + `"}`,
+			http.StatusRequestEntityTooLarge, server.CodePayloadTooLarge},
+		{http.MethodPost, "/publish", `{"topic":"full","data":2}`, http.StatusTooManyRequests, server.CodeQueueFull},
+		{http.MethodPost, "/publish", `{"topic":"full","data":"large"}`,
+			http.StatusTooManyRequests, server.CodeTopicMemoryLimit},
+		{http.MethodGet, "/poll?topic=test&subscriber=bob", "", http.StatusNotFound, server.CodeNotSubscribed},
+		{http.MethodGet, "/poll?topic=full&subscriber=bob&wait=soon", "",
+			http.StatusBadRequest, server.CodeInvalidParameter},
+		{http.MethodPost, "/nack", `{"topic":"full","subscriber":"bob","data":1}`,
+			http.StatusBadRequest, server.CodeInvalidParameter},
+		{http.MethodPost, "/nack", `{"topic":"full","subscriber":"bob","id":5,"data":1}`,
+			http.StatusNotFound, server.CodeMessageNotFound},
+		{http.MethodGet, "/topics/test", "", http.StatusNotFound, server.CodeTopicNotFound},
+		{http.MethodGet, "/unknown", "", http.StatusNotFound, server.CodeNotFound},
+		{http.MethodGet, "/publish", "", http.StatusMethodNotAllowed, server.CodeMethodNotAllowed},
+		{http.MethodGet, "/admin/memory", "", http.StatusForbidden, server.CodeAdminDisabled},
+	}
+
+	for _, c := range cases {
+		status, apiErr := serve(c.method, c.path, c.body)
+		assert.Equal(t, c.status, status, c.path+" "+c.body)
+		assert.Equal(t, c.code, apiErr.Code, c.path+" "+c.body)
+		assert.NotEmpty(t, apiErr.Message)
+	}
+}
+
+func TestAPI_ResourceRoutes(t *testing.T) {
+	broker := mq.NewBroker()
+	handler := server.GetServer(broker)
+
+	serve := func(method, path, body string) *httptest.ResponseRecorder {
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, httptest.NewRequest(method, path, strings.NewReader(body)))
+		return rec
+	}
+
+	assert.Equal(t, http.StatusOK, serve(http.MethodPut, "/topics/test%2Ftopic/subscriptions/alice", "").Code)
+	assert.Equal(t, http.StatusOK, serve(http.MethodPut, "/ns/team-a/topics/orders/subscriptions/bob",
+		`{"max_pending":10,"overflow":"reject"}`).Code)
+
+	assert.Equal(t, http.StatusOK, serve(http.MethodPost, "/topics/test%2Ftopic/messages", `{"id":1}`).Code)
+	assert.Equal(t, http.StatusOK, serve(http.MethodPost, "/publish", `{"topic":"test/topic","data":{"id":2}}`).Code)
+	assert.Equal(t, http.StatusBadRequest, serve(http.MethodPost, "/topics/test%2Ftopic/messages", `{"id":`).Code)
+
+	rec := serve(http.MethodGet, "/topics/test%2Ftopic/subscriptions/alice/messages", "")
+	assert.Equal(t, http.StatusOK, rec.Code)
+	assert.JSONEq(t, `{"id":1,"topic":"test/topic","data":{"id":1}}`, rec.Body.String())
+
+	rec = serve(http.MethodGet, "/poll?topic=test/topic&subscriber=alice", "")
+	assert.Equal(t, http.StatusOK, rec.Code)
+	assert.JSONEq(t, `{"id":2,"topic":"test/topic","data":{"id":2}}`, rec.Body.String())
+
+	subscribers, ok := broker.GetNamespace("team-a").Subscribers("orders")
+	assert.True(t, ok)
+	assert.Equal(t, 10, subscribers[0].MaxPending)
+
+	assert.Equal(t, http.StatusOK, serve(http.MethodDelete, "/topics/test%2Ftopic/subscriptions/alice", "").Code)
+	assert.Equal(t, http.StatusNotFound,
+		serve(http.MethodGet, "/topics/test%2Ftopic/subscriptions/alice/messages", "").Code)
+}
+
+func TestClient_Concurrent(t *testing.T) {
+	broker := mq.NewBroker()
+	httpServer := httptest.NewServer(server.GetServer(broker))
+	defer httpServer.Close()
+
+	pClient, err := client.New(httpServer.URL)
+	assert.NoError(t, err)
+
+	ctx := context.Background()
+	done := make(chan struct{})
+	for i := 0; i < 8; i++ {
+		go func(i int) {
+			defer func() { done <- struct{}{} }()
+
+			topic := "topic/" + strconv.Itoa(i)
+			assert.NoError(t, pClient.Subscribe(ctx, topic, "bob"))
+			assert.NoError(t, pClient.Publish(ctx, topic, json.RawMessage(strconv.Itoa(i))))
+
+			msg, err := pClient.Poll(ctx, topic, "bob")
+			assert.NoError(t, err)
+			assert.Equal(t, json.RawMessage(strconv.Itoa(i)), msg)
+
+			_, err = pClient.Topic(ctx, topic)
+			assert.NoError(t, err)
+		}(i)
+	}
+	for i := 0; i < 8; i++ {
+		<-done
+	}
+
+	topics, err := pClient.Topics(ctx)
+	assert.NoError(t, err)
+	assert.Len(t, topics, 8)
+}
+
+func TestClient_Context(t *testing.T) {
+	slow := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		<-r.Context().Done()
+	}))
+	defer slow.Close()
+
+	pClient, err := client.New(slow.URL)
+	assert.NoError(t, err)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	_, err = pClient.Poll(ctx, "test_topic", "bob")
+	assert.True(t, errors.Is(err, context.DeadlineExceeded))
+
+	ctx, cancel = context.WithCancel(context.Background())
+	cancel()
+	err = pClient.Publish(ctx, "test_topic", json.RawMessage(`1`))
+	assert.True(t, errors.Is(err, context.Canceled))
+}
+
+func TestClient_Transport(t *testing.T) {
+	broker := mq.NewBroker()
+	handler := server.GetServer(broker)
+
+	var mu sync.Mutex
+	var headers []http.Header
+	proxy := http.StripPrefix("/polly", http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		headers = append(headers, r.Header.Clone())
+		mu.Unlock()
+		handler.ServeHTTP(w, r)
+	}))
+
+	var connections int
+	httpServer := httptest.NewUnstartedServer(proxy)
+	httpServer.Config.ConnState = func(conn net.Conn, state http.ConnState) {
+		mu.Lock()
+		defer mu.Unlock()
+		if state == http.StateNew {
+			connections++
+		}
+	}
+	httpServer.Start()
+	defer httpServer.Close()
+
+	pClient, err := client.New(httpServer.URL,
+		client.WithBasePath("/polly"),
+		client.WithHTTPClient(&http.Client{Transport: &http.Transport{}}),
+		client.WithHeader("X-Request-Source", "tests"),
+		client.WithUserAgent("polly-tests/1.0"),
+	)
+	assert.NoError(t, err)
+
+	ctx := context.Background()
+	assert.NoError(t, pClient.Subscribe(ctx, "test_topic", "bob"))
+	assert.NoError(t, pClient.Publish(ctx, "test_topic", json.RawMessage(`1`)))
+	msg, err := pClient.Poll(ctx, "test_topic", "bob")
+	assert.NoError(t, err)
+	assert.Equal(t, json.RawMessage(`1`), msg)
+
+	_, err = pClient.Poll(ctx, "test_topic", "alice")
+	assert.True(t, errors.Is(err, client.ErrNotSubscribed))
+	_, err = pClient.Topic(ctx, "unknown")
+	assert.True(t, errors.Is(err, client.ErrTopicNotFound))
+	_, err = pClient.Topics(ctx)
+	assert.NoError(t, err)
+
+	// the error responses are drained, so the single connection is reused.
+	mu.Lock()
+	assert.Equal(t, 1, connections)
+	assert.Len(t, headers, 6)
+	for _, header := range headers {
+		assert.Equal(t, "tests", header.Get("X-Request-Source"))
+		assert.Equal(t, "polly-tests/1.0", header.Get("User-Agent"))
+	}
+	mu.Unlock()
+
+	// the base path is also taken from the address.
+	pClient, err = client.New(httpServer.URL + "/polly/")
+	assert.NoError(t, err)
+	assert.NoError(t, pClient.Publish(ctx, "test_topic", json.RawMessage(`2`)))
+
+	slow := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		<-r.Context().Done()
+	}))
+	defer slow.Close()
+
+	pClient, err = client.New(slow.URL, client.WithTimeout(50*time.Millisecond))
+	assert.NoError(t, err)
+	_, err = pClient.Topics(ctx)
+	assert.True(t, errors.Is(err, context.DeadlineExceeded))
+}
+
+func TestClient_Retry(t *testing.T) {
+	broker := mq.NewBroker()
+	handler := server.GetServer(broker)
+
+	var mu sync.Mutex
+	attempts := map[string]int{}
+	flaky := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		attempts[r.URL.Path]++
+		attempt := attempts[r.URL.Path]
+		mu.Unlock()
+
+		switch {
+		case r.URL.Path == "/publish" && attempt == 1:
+			// the message is published, but the response is lost.
+			handler.ServeHTTP(httptest.NewRecorder(), r)
+			panic(http.ErrAbortHandler)
+		case r.URL.Path == "/poll" && attempt <= 2:
+			w.WriteHeader(http.StatusServiceUnavailable)
+		case r.URL.Path == "/unsubscribe":
+			panic(http.ErrAbortHandler)
+		default:
+			handler.ServeHTTP(w, r)
+		}
+	}))
+	defer flaky.Close()
+
+	policy := client.RetryPolicy{MaxAttempts: 3, MinBackoff: time.Millisecond, MaxBackoff: 10 * time.Millisecond}
+	pClient, err := client.New(flaky.URL, client.WithRetry(policy))
+	assert.NoError(t, err)
+
+	ctx := context.Background()
+	assert.NoError(t, pClient.Subscribe(ctx, "test_topic", "bob"))
+	assert.NoError(t, pClient.Publish(ctx, "test_topic", json.RawMessage(`1`)))
+
+	msg, err := pClient.Poll(ctx, "test_topic", "bob")
+	assert.NoError(t, err)
+	assert.Equal(t, json.RawMessage(`1`), msg)
+
+	// the retry of the publish is deduplicated by the idempotency key.
+	msg, err = pClient.Poll(ctx, "test_topic", "bob")
+	assert.NoError(t, err)
+	assert.Nil(t, msg)
+
+	// the unsubscribe might be handled, so it is not retried.
+	assert.Error(t, pClient.Unsubscribe(ctx, "test_topic", "bob"))
+
+	mu.Lock()
+	assert.Equal(t, 2, attempts["/publish"])
+	assert.Equal(t, 4, attempts["/poll"])
+	assert.Equal(t, 1, attempts["/unsubscribe"])
+	mu.Unlock()
+
+	// the server is unreachable.
+	flaky.Close()
+	pClient, err = client.New(flaky.URL, client.WithRetry(policy))
+	assert.NoError(t, err)
+	assert.Error(t, pClient.Publish(ctx, "test_topic", json.RawMessage(`2`)))
+}
+
+func TestAPI_Idempotency(t *testing.T) {
+	broker := mq.NewBroker()
+	httpServer := httptest.NewServer(server.GetServer(broker))
+	defer httpServer.Close()
+
+	broker.Subscribe("test_topic", "bob")
+	publish := func(key string) *http.Response {
+		req, err := http.NewRequest(http.MethodPost, httpServer.URL+"/topics/test_topic/messages",
+			strings.NewReader(`{"n":1}`))
+		assert.NoError(t, err)
+		req.Header.Set(server.HeaderIdempotencyKey, key)
+
+		resp, err := http.DefaultClient.Do(req)
+		assert.NoError(t, err)
+		resp.Body.Close()
+		return resp
+	}
+
+	assert.Equal(t, "", publish("key-1").Header.Get("Idempotent-Replayed"))
+	assert.Equal(t, "true", publish("key-1").Header.Get("Idempotent-Replayed"))
+	assert.Equal(t, http.StatusOK, publish("key-2").StatusCode)
+
+	info, ok := broker.TopicInfo("test_topic")
+	assert.True(t, ok)
+	assert.Equal(t, 2, info.Messages)
+}
+
+func TestClient_LongPoll(t *testing.T) {
+	broker := mq.NewBroker()
+	httpServer := httptest.NewServer(server.GetServer(broker))
+	defer httpServer.Close()
+
+	pClient, err := client.New(httpServer.URL)
+	assert.NoError(t, err)
+
+	ctx := context.Background()
+	assert.NoError(t, pClient.Subscribe(ctx, "test_topic", "bob"))
+
+	go func() {
+		time.Sleep(50 * time.Millisecond)
+		assert.NoError(t, broker.HandleNewMessage("test_topic", json.RawMessage(`1`)))
+	}()
+	msg, err := pClient.FetchWait(ctx, "test_topic", "bob", 5*time.Second)
+	assert.NoError(t, err)
+	assert.Equal(t, json.RawMessage(`1`), msg.Data)
+
+	start := time.Now()
+	empty, err := pClient.FetchWait(ctx, "test_topic", "bob", 50*time.Millisecond)
+	assert.NoError(t, err)
+	assert.Nil(t, empty.Data)
+	assert.True(t, time.Since(start) >= 50*time.Millisecond)
+
+	// the nacked message is received again.
+	assert.NoError(t, pClient.Nack(ctx, "test_topic", "bob", msg))
+	nacked, err := pClient.Fetch(ctx, "test_topic", "bob")
+	assert.NoError(t, err)
+	assert.Equal(t, msg, nacked)
+
+	err = pClient.Nack(ctx, "test_topic", "bob", client.Message{ID: 10, Data: json.RawMessage(`10`)})
+	assert.True(t, errors.Is(err, client.ErrNotFound))
+
+	// the message is requeued once per delivery with the data stored by the server.
+	assert.NoError(t, pClient.Nack(ctx, "test_topic", "bob", client.Message{ID: nacked.ID, Data: json.RawMessage(`2`)}))
+	err = pClient.Nack(ctx, "test_topic", "bob", nacked)
+	assert.True(t, errors.Is(err, client.ErrNotFound))
+	nacked, err = pClient.Fetch(ctx, "test_topic", "bob")
+	assert.NoError(t, err)
+	assert.Equal(t, msg, nacked)
+
+	// the nacks respect the queue limit of the subscription.
+	assert.NoError(t, pClient.SubscribeWithOptions(ctx, "test_topic", "bob",
+		client.SubscribeOptions{MaxPending: 1, Overflow: "reject"}))
+	assert.NoError(t, broker.HandleNewMessage("test_topic", json.RawMessage(`3`)))
+	err = pClient.Nack(ctx, "test_topic", "bob", nacked)
+	assert.True(t, errors.Is(err, client.ErrQueueFull))
+	info, err := pClient.Subscribers(ctx, "test_topic")
+	assert.NoError(t, err)
+	assert.Equal(t, 1, info[0].Pending)
+}
+
+func TestClient_Consumer(t *testing.T) {
+	broker := mq.NewBroker()
+	httpServer := httptest.NewServer(server.GetServer(broker))
+	defer httpServer.Close()
+
+	pClient, err := client.New(httpServer.URL)
+	assert.NoError(t, err)
+
+	var mu sync.Mutex
+	handled := map[string]int{}
+	failed := false
+	done := make(chan struct{})
+
+	consumer := client.NewConsumer(pClient, client.ConsumerConfig{
+		Topic:       "test_topic",
+		Subscriber:  "bob",
+		Concurrency: 3,
+		Wait:        time.Second,
+		Unsubscribe: true,
+	}, func(ctx context.Context, msg client.Message) error {
+		mu.Lock()
+		defer mu.Unlock()
+
+		if string(msg.Data) == `3` && !failed {
+			failed = true
+			return errors.New("temporary failure")
+		}
+
+		handled[string(msg.Data)]++
+		if len(handled) == 10 {
+			close(done)
+		}
+		return nil
+	})
+
+	ctx, cancel := context.WithCancel(context.Background())
+	stopped := make(chan error)
+	go func() { stopped <- consumer.Run(ctx) }()
+
+	// wait for the subscription.
+	for {
+		if _, ok := broker.Owner("test_topic", "bob"); ok {
+			break
+		}
+		time.Sleep(time.Millisecond)
+	}
+	for i := 0; i < 10; i++ {
+		assert.NoError(t, broker.HandleNewMessage("test_topic", json.RawMessage(strconv.Itoa(i))))
+	}
+
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatal("messages are not handled")
+	}
 	cancel()
+	assert.NoError(t, <-stopped)
+
+	mu.Lock()
+	assert.True(t, failed)
+	for i := 0; i < 10; i++ {
+		assert.Equal(t, 1, handled[strconv.Itoa(i)])
+	}
+	mu.Unlock()
+
+	_, ok := broker.Owner("test_topic", "bob")
+	assert.False(t, ok)
+}
+
+func TestClient_ConsumerDeadLetter(t *testing.T) {
+	broker := mq.NewBroker()
+	httpServer := httptest.NewServer(server.GetServer(broker))
+	defer httpServer.Close()
+
+	pClient, err := client.New(httpServer.URL)
+	assert.NoError(t, err)
+
+	var mu sync.Mutex
+	var calls []time.Time
+	deadLetters := make(chan client.Message, 1)
+	consumer := client.NewConsumer(pClient, client.ConsumerConfig{
+		Topic:      "test_topic",
+		Subscriber: "bob",
+		Wait:       time.Second,
+		Redelivery: client.RetryPolicy{MaxAttempts: 3, MinBackoff: 50 * time.Millisecond},
+		OnDeadLetter: func(msg client.Message, err error) {
+			assert.EqualError(t, err, "poison message")
+			deadLetters <- msg
+		},
+	}, func(ctx context.Context, msg client.Message) error {
+		mu.Lock()
+		defer mu.Unlock()
+		calls = append(calls, time.Now())
+		return errors.New("poison message")
+	})
+
+	ctx, cancel := context.WithCancel(context.Background())
+	stopped := make(chan error)
+	go func() { stopped <- consumer.Run(ctx) }()
+
+	for {
+		if _, ok := broker.Owner("test_topic", "bob"); ok {
+			break
+		}
+		time.Sleep(time.Millisecond)
+	}
+	assert.NoError(t, broker.HandleNewMessage("test_topic", json.RawMessage(`1`)))
+
+	select {
+	case msg := <-deadLetters:
+		assert.Equal(t, json.RawMessage(`1`), msg.Data)
+	case <-time.After(5 * time.Second):
+		t.Fatal("message is not passed to the dead letter handler")
+	}
+	cancel()
+	assert.NoError(t, <-stopped)
+
+	mu.Lock()
+	defer mu.Unlock()
+	// the message is redelivered after the growing backoff.
+	assert.Len(t, calls, 3)
+	assert.True(t, calls[1].Sub(calls[0]) >= 50*time.Millisecond)
+	assert.True(t, calls[2].Sub(calls[1]) >= 100*time.Millisecond)
+
+	info, ok := broker.Subscribers("test_topic")
+	assert.True(t, ok)
+	assert.Zero(t, info[0].Pending)
+}
+
+func TestClient_Producer(t *testing.T) {
+	broker := mq.NewBroker()
+	httpServer := httptest.NewServer(server.GetServer(broker))
+	defer httpServer.Close()
+
+	pClient, err := client.New(httpServer.URL)
+	assert.NoError(t, err)
+	broker.Subscribe("test_topic", "bob")
+
+	results := make(chan client.Result, 20)
+	var callbacks int64
+	producer := client.NewProducer(pClient, client.ProducerConfig{
+		BatchSize:     5,
+		FlushInterval: time.Hour,
+		BufferSize:    20,
+		Results:       results,
+		OnResult: func(result client.Result) {
+			atomic.AddInt64(&callbacks, 1)
+		},
+	})
+
+	ctx := context.Background()
+	for i := 0; i < 12; i++ {
+		assert.NoError(t, producer.Send("test_topic", json.RawMessage(strconv.Itoa(i))))
+	}
+
+	// the full batches are published without waiting for the interval.
+	for i := 0; i < 10; i++ {
+		result := <-results
+		assert.NoError(t, result.Err)
+		assert.Equal(t, json.RawMessage(strconv.Itoa(i)), result.Data)
+	}
+
+	assert.NoError(t, producer.Send("unknown", nil))
+	assert.NoError(t, producer.Flush(ctx))
+	assert.NoError(t, producer.Close(ctx))
+	assert.Equal(t, client.ErrProducerClosed, producer.Send("test_topic", json.RawMessage(`1`)))
+
+	close(results)
+	var failed int
+	for result := range results {
+		if result.Err != nil {
+			failed++
+			assert.True(t, errors.Is(result.Err, client.ErrInvalidRequest))
+		}
+	}
+	assert.Equal(t, 1, failed)
+	assert.Equal(t, int64(13), atomic.LoadInt64(&callbacks))
+
+	for i := 0; i < 12; i++ {
+		msg, _ := broker.Poll("test_topic", "bob")
+		assert.Equal(t, json.RawMessage(strconv.Itoa(i)), msg)
+	}
+}
+
+func TestClient_ProducerCloseDeadline(t *testing.T) {
+	broker := mq.NewBroker()
+	httpServer := httptest.NewServer(server.GetServer(broker))
+	defer httpServer.Close()
+
+	pClient, err := client.New(httpServer.URL)
+	assert.NoError(t, err)
+
+	// the results are not read, so Close is finished by the deadline.
+	producer := client.NewProducer(pClient, client.ProducerConfig{Results: make(chan client.Result)})
+	for i := 0; i < 3; i++ {
+		assert.NoError(t, producer.Send("test_topic", json.RawMessage(strconv.Itoa(i))))
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+	closed := make(chan error, 1)
+	go func() { closed <- producer.Close(ctx) }()
+
+	select {
+	case err := <-closed:
+		assert.Equal(t, context.DeadlineExceeded, err)
+	case <-time.After(5 * time.Second):
+		t.Fatal("Close does not respect the deadline")
+	}
+}
+
+func TestClientTest_Fake(t *testing.T) {
+	testServer := clienttest.NewServer(mq.NewBroker())
+	defer testServer.Close()
+
+	clients := map[string]client.PollyClient{
+		"fake":   clienttest.NewFake(mq.NewBroker()),
+		"server": testServer.NewPollyClient(),
+	}
+
+	// the fake client behaves the same way as the real one.
+	for name, pClient := range clients {
+		t.Run(name, func(t *testing.T) {
+			assert.NoError(t, pClient.Subscribe("test_topic", "bob"))
+			subscriber, ok := pClient.(client.OptionsSubscriber)
+			assert.True(t, ok)
+			inspector, ok := pClient.(client.Inspector)
+			assert.True(t, ok)
+			assert.NoError(t, subscriber.SubscribeWithOptions("test_topic", "alice",
+				client.SubscribeOptions{MaxPending: 1, Overflow: "reject"}))
+			assert.NoError(t, pClient.Publish("test_topic", json.RawMessage(`1`)))
+
+			err := pClient.Publish("test_topic", json.RawMessage(`2`))
+			assert.True(t, errors.Is(err, client.ErrQueueFull))
+			apiErr, ok := err.(*client.APIError)
+			assert.True(t, ok)
+			assert.Equal(t, http.StatusTooManyRequests, apiErr.StatusCode)
+			assert.Equal(t, "queue_full", apiErr.Code)
+
+			msg, err := pClient.Poll("test_topic", "bob")
+			assert.NoError(t, err)
+			assert.Equal(t, json.RawMessage(`1`), msg)
+			msg, err = pClient.Poll("test_topic", "bob")
+			assert.NoError(t, err)
+			assert.Nil(t, msg)
+
+			info, err := inspector.Topic("test_topic")
+			assert.NoError(t, err)
+			assert.Equal(t, int64(2), info.Subscribers)
+			assert.Equal(t, 1, info.Messages)
+
+			subscribers, err := inspector.Subscribers("test_topic")
+			assert.NoError(t, err)
+			assert.Len(t, subscribers, 2)
+			assert.Equal(t, "reject", subscribers[0].Overflow)
+
+			topics, err := inspector.Topics()
+			assert.NoError(t, err)
+			assert.Len(t, topics, 1)
+
+			assert.NoError(t, pClient.Unsubscribe("test_topic", "bob"))
+			_, err = pClient.Poll("test_topic", "bob")
+			assert.True(t, errors.Is(err, client.ErrNotSubscribed))
+			_, err = inspector.Topic("unknown")
+			assert.True(t, errors.Is(err, client.ErrTopicNotFound))
+			assert.True(t, errors.Is(pClient.Publish("", json.RawMessage(`1`)), client.ErrInvalidRequest))
+			assert.True(t, errors.Is(pClient.Subscribe("test_topic", ""), client.ErrInvalidRequest))
+		})
+	}
+}
+
+func TestClient_JSON(t *testing.T) {
+	testServer := clienttest.NewServer(mq.NewBroker())
+	defer testServer.Close()
+
+	type order struct {
+		ID     int    `json:"id"`
+		Status string `json:"status"`
+	}
+
+	_, err := client.New(testServer.URL, client.WithSchema("orders", json.RawMessage(`{"type": 1}`)))
+	assert.Error(t, err)
+
+	pClient, err := client.New(testServer.URL, client.WithSchema("orders", json.RawMessage(`{
+		"type": "object",
+		"required": ["id", "status"],
+		"properties": {"id": {"type": "integer", "minimum": 1}, "status": {"enum": ["new", "paid"]}}
+	}`)))
+	assert.NoError(t, err)
+
+	ctx := context.Background()
+	assert.NoError(t, pClient.Subscribe(ctx, "orders", "billing"))
+	assert.NoError(t, pClient.PublishJSON(ctx, "orders", order{ID: 1, Status: "new"}))
+
+	err = pClient.PublishJSON(ctx, "orders", order{ID: 2, Status: "lost"})
+	assert.True(t, errors.Is(err, client.ErrSchemaViolation))
+	assert.True(t, errors.Is(err, client.ErrInvalidRequest))
+	assert.EqualError(t, err, "topic orders: /status: should be one of the allowed values")
+	// other topics are not validated.
+	assert.NoError(t, pClient.PublishJSON(ctx, "other", order{}))
+
+	result := order{}
+	ok, err := pClient.PollInto(ctx, "orders", "billing", &result)
+	assert.NoError(t, err)
+	assert.True(t, ok)
+	assert.Equal(t, order{ID: 1, Status: "new"}, result)
+
+	ok, err = pClient.PollInto(ctx, "orders", "billing", &result)
+	assert.NoError(t, err)
+	assert.False(t, ok)
+
+	assert.Error(t, pClient.PublishJSON(ctx, "orders", make(chan int)))
+}
+
+func TestAPI_Stream(t *testing.T) {
+	broker := mq.NewBroker()
+	cfg := server.Config{Stream: server.StreamConfig{Heartbeat: 20 * time.Millisecond}}
+	testServer := clienttest.NewServerWithConfig(broker, cfg)
+	defer testServer.Close()
+
+	broker.Subscribe("test_topic", "bob")
+	for i := 1; i <= 3; i++ {
+		assert.NoError(t, broker.HandleNewMessage("test_topic", json.RawMessage(strconv.Itoa(i))))
+	}
+
+	// readIDs reads the identifiers of the messages from the stream until the count is received or the time is out.
+	readIDs := func(lastEventID string, count int) []string {
+		ctx, cancel := context.WithTimeout(context.Background(), 200*time.Millisecond)
+		defer cancel()
+
+		req, err := http.NewRequestWithContext(ctx, http.MethodGet,
+			testServer.URL+"/topics/test_topic/subscriptions/bob/stream", nil)
+		assert.NoError(t, err)
+		if lastEventID != "" {
+			req.Header.Set("Last-Event-ID", lastEventID)
+		}
+
+		resp, err := http.DefaultClient.Do(req)
+		assert.NoError(t, err)
+		defer resp.Body.Close()
+		assert.Equal(t, "text/event-stream", resp.Header.Get("Content-Type"))
+
+		ids := []string{}
+		reader := bufio.NewReader(resp.Body)
+		for len(ids) < count {
+			line, err := reader.ReadString('\n')
+			if err != nil {
+				break
+			}
+			if strings.HasPrefix(line, "id: ") {
+				ids = append(ids, strings.TrimSpace(strings.TrimPrefix(line, "id: ")))
+			}
+		}
+		cancel()
+		// the server stores the sent messages after it notices the disconnect.
+		time.Sleep(50 * time.Millisecond)
+		return ids
+	}
+
+	assert.Equal(t, []string{"1", "2", "3"}, readIDs("", 3))
+	// the messages sent after the last received one are delivered again.
+	assert.Equal(t, []string{"2", "3"}, readIDs("1", 3))
+	// without the header the sent messages are considered received.
+	assert.Equal(t, []string{}, readIDs("", 1))
+
+	_, ok := broker.Poll("test_topic", "bob")
+	assert.True(t, ok)
+	info, _ := broker.Subscribers("test_topic")
+	assert.Equal(t, 0, info[0].Pending)
+
+	resp, err := http.Get(testServer.URL + "/stream?topic=test_topic&subscriber=alice")
+	assert.NoError(t, err)
+	_ = resp.Body.Close()
+	assert.Equal(t, http.StatusNotFound, resp.StatusCode)
+}
+
+func TestClient_Stream(t *testing.T) {
+	broker := mq.NewBroker()
+	cfg := server.Config{Stream: server.StreamConfig{Heartbeat: 20 * time.Millisecond}}
+	testServer := clienttest.NewServerWithConfig(broker, cfg)
+	defer testServer.Close()
+
+	pClient := testServer.NewClient(
+		client.WithTimeout(time.Second),
+		client.WithRetry(client.RetryPolicy{MaxAttempts: 2, MinBackoff: 10 * time.Millisecond}),
+	)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	_, err := pClient.Stream(ctx, "test_topic", "bob")
+	assert.True(t, errors.Is(err, client.ErrNotSubscribed))
+
+	assert.NoError(t, pClient.Subscribe(ctx, "test_topic", "bob"))
+	stream, err := pClient.Stream(ctx, "test_topic", "bob")
+	assert.NoError(t, err)
+
+	receive := func() client.Message {
+		select {
+		case msg := <-stream.Messages():
+			return msg
+		case <-ctx.Done():
+			t.Fatal("message is not received")
+			return client.Message{}
+		}
+	}
+
+	assert.NoError(t, pClient.Publish(ctx, "test_topic", json.RawMessage(`1`)))
+	assert.NoError(t, pClient.Publish(ctx, "test_topic", json.RawMessage(`2`)))
+	assert.Equal(t, client.Message{ID: 1, Topic: "test_topic", Data: json.RawMessage(`1`)}, receive())
+	assert.Equal(t, json.RawMessage(`2`), receive().Data)
+
+	// the stream is resumed after the connection is broken.
+	testServer.CloseClientConnections()
+	time.Sleep(100 * time.Millisecond)
+	assert.NoError(t, pClient.Publish(ctx, "test_topic", json.RawMessage(`3`)))
+	assert.Equal(t, client.Message{ID: 3, Topic: "test_topic", Data: json.RawMessage(`3`)}, receive())
+
+	// the stream is stopped when the subscription is removed.
+	assert.NoError(t, pClient.Unsubscribe(ctx, "test_topic", "bob"))
+	for range stream.Messages() {
+		t.Fatal("unexpected message")
+	}
+	assert.True(t, errors.Is(stream.Err(), client.ErrNotSubscribed))
 }